@@ -0,0 +1,289 @@
+// Package analytics counts blog views and reports them back to authors.
+//
+// Views are deduplicated per viewer, buffered in memory and written in
+// batches, so recording one costs a read nothing but a channel send. Each
+// batch adds to hourly and daily view counts per blog and notes which
+// viewers read a blog in each hour, from which unique viewers are counted
+// at query time. Buckets are aligned to UTC.
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/cache"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Periods of the view count buckets.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// writeTimeout bounds writing one batch.
+const writeTimeout = 30 * time.Second
+
+// View is one read of a blog.
+type View struct {
+	BlogID   primitive.ObjectID
+	AuthorID string
+	// Viewer identifies the reader. It is hashed before it is stored.
+	Viewer string
+	At     time.Time
+}
+
+// Options configures a Recorder.
+type Options struct {
+	// DedupWindow is how long repeated views of a blog by the same viewer
+	// count once.
+	DedupWindow time.Duration
+	// DedupSize caps how many viewer and blog pairs are remembered for
+	// deduplication.
+	DedupSize int
+	// BufferSize is how many views may wait to be written. Views arriving
+	// while it is full are dropped.
+	BufferSize int
+	// FlushInterval is how often buffered views are written.
+	FlushInterval time.Duration
+	// Retention is how long viewer records, needed to count unique
+	// viewers, are kept. View counts are kept forever.
+	Retention time.Duration
+}
+
+// Recorder writes views to Mongo in the background. Record is safe for
+// concurrent use; a nil *Recorder records nothing.
+type Recorder struct {
+	counts  *mongo.Collection
+	viewers *mongo.Collection
+	opts    Options
+	metrics *metrics.MongoMetrics
+	seen    *cache.LRU
+
+	mu      sync.RWMutex
+	closed  bool
+	views   chan View
+	done    chan struct{}
+	dropped uint64
+}
+
+// NewRecorder starts a Recorder writing to db. m may be nil. Call Close to
+// write the views still buffered.
+func NewRecorder(db *mongo.Database, opts Options, m *metrics.MongoMetrics) *Recorder {
+	r := &Recorder{
+		counts:  db.Collection(migrations.ViewCountCollection),
+		viewers: db.Collection(migrations.ViewerCollection),
+		opts:    opts,
+		metrics: m,
+		seen:    cache.NewLRU(opts.DedupSize, opts.DedupWindow),
+		views:   make(chan View, opts.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues v unless the same viewer already viewed the blog within
+// the dedup window. It never blocks.
+func (r *Recorder) Record(v View) {
+	if r == nil {
+		return
+	}
+	v.Viewer = hashViewer(v.Viewer)
+	// The view is claimed first so concurrent views count once, and the
+	// claim is given up when the view is not queued so the next one counts.
+	key := v.BlogID.Hex() + "\x00" + v.Viewer
+	if r.seen.ContainsOrAdd(key, nil) {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.seen.Remove(key)
+		return
+	}
+	select {
+	case r.views <- v:
+	default:
+		r.seen.Remove(key)
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// Close stops accepting views and writes the buffered ones, waiting until
+// ctx is done at most.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.views)
+	}
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.FlushInterval)
+	defer ticker.Stop()
+	b := newBatch(r.opts.Retention)
+	for {
+		select {
+		case v, ok := <-r.views:
+			if !ok {
+				r.flush(b)
+				return
+			}
+			b.add(v)
+			// Write early rather than let one batch grow without bound.
+			if b.size >= r.opts.BufferSize {
+				r.flush(b)
+				b = newBatch(r.opts.Retention)
+			}
+		case <-ticker.C:
+			r.flush(b)
+			b = newBatch(r.opts.Retention)
+		}
+	}
+}
+
+// flush writes b. Views are best effort: a batch that cannot be written is
+// logged and dropped rather than retried.
+func (r *Recorder) flush(b *batch) {
+	if n := atomic.SwapUint64(&r.dropped, 0); n > 0 {
+		slog.Warn("dropped blog views, the buffer was full", "count", n)
+	}
+	if b.size == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := r.write(ctx, r.counts, "bulk_write", b.countWrites()); err != nil {
+		slog.Error("failed to write blog view counts", "views", b.size, "err", err)
+	}
+	if err := r.write(ctx, r.viewers, "bulk_write", b.viewerWrites()); err != nil {
+		slog.Error("failed to write blog viewers", "views", b.size, "err", err)
+	}
+}
+
+func (r *Recorder) write(ctx context.Context, coll *mongo.Collection, op string, models []mongo.WriteModel) error {
+	if len(models) == 0 {
+		return nil
+	}
+	done := r.metrics.Start(coll.Name(), op)
+	_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	err = ignoreDuplicates(err)
+	done(err)
+	return err
+}
+
+// ignoreDuplicates drops the duplicate key errors concurrent upserts of the
+// same document can fail with; the document exists either way.
+func ignoreDuplicates(err error) error {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if !ok || bwe.WriteConcernError != nil {
+		return err
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != 11000 {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashViewer keeps viewer identities, often IP addresses, out of the
+// database.
+func hashViewer(viewer string) string {
+	sum := sha256.Sum256([]byte(viewer))
+	return hex.EncodeToString(sum[:16])
+}
+
+type countKey struct {
+	blogID primitive.ObjectID
+	period string
+	start  time.Time
+}
+
+type viewerKey struct {
+	blogID primitive.ObjectID
+	hour   time.Time
+	viewer string
+}
+
+// batch aggregates views between two writes.
+type batch struct {
+	retention time.Duration
+	size      int
+	counts    map[countKey]int64
+	viewers   map[viewerKey]bool
+	authors   map[primitive.ObjectID]string
+}
+
+func newBatch(retention time.Duration) *batch {
+	return &batch{
+		retention: retention,
+		counts:    make(map[countKey]int64),
+		viewers:   make(map[viewerKey]bool),
+		authors:   make(map[primitive.ObjectID]string),
+	}
+}
+
+func (b *batch) add(v View) {
+	hour := v.At.UTC().Truncate(time.Hour)
+	b.size++
+	b.counts[countKey{v.BlogID, PeriodHour, hour}]++
+	b.counts[countKey{v.BlogID, PeriodDay, startOfDay(hour)}]++
+	b.viewers[viewerKey{v.BlogID, hour, v.Viewer}] = true
+	b.authors[v.BlogID] = v.AuthorID
+}
+
+func (b *batch) countWrites() []mongo.WriteModel {
+	models := make([]mongo.WriteModel, 0, len(b.counts))
+	for k, n := range b.counts {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"blog_id": k.blogID, "period": k.period, "start": k.start}).
+			// The author is refreshed so buckets follow a blog that
+			// changed hands.
+			SetUpdate(bson.M{
+				"$inc": bson.M{"views": n},
+				"$set": bson.M{"author_id": b.authors[k.blogID]},
+			}).
+			SetUpsert(true))
+	}
+	return models
+}
+
+func (b *batch) viewerWrites() []mongo.WriteModel {
+	models := make([]mongo.WriteModel, 0, len(b.viewers))
+	for k := range b.viewers {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"blog_id": k.blogID, "hour": k.hour, "viewer": k.viewer}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{
+				"author_id":  b.authors[k.blogID],
+				"expires_at": k.hour.Add(b.retention),
+			}}).
+			SetUpsert(true))
+	}
+	return models
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
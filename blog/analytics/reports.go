@@ -0,0 +1,195 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Filter selects the views a report covers: those of one blog, or of every
+// blog by one author, from Start up to but excluding End.
+type Filter struct {
+	BlogID   primitive.ObjectID
+	AuthorID string
+	Start    time.Time
+	End      time.Time
+}
+
+// Bucket holds the views of one hour or day.
+type Bucket struct {
+	Start         time.Time
+	Views         int64
+	UniqueViewers int64
+}
+
+// PostViews is a blog and how often it was viewed.
+type PostViews struct {
+	BlogID primitive.ObjectID
+	Views  int64
+}
+
+// Reports queries what a Recorder wrote. Each query may take at most
+// timeout.
+type Reports struct {
+	counts  *mongo.Collection
+	viewers *mongo.Collection
+	timeout time.Duration
+	metrics *metrics.MongoMetrics
+}
+
+// NewReports returns Reports reading db. m may be nil.
+func NewReports(db *mongo.Database, timeout time.Duration, m *metrics.MongoMetrics) *Reports {
+	return &Reports{
+		counts:  db.Collection(migrations.ViewCountCollection),
+		viewers: db.Collection(migrations.ViewerCollection),
+		timeout: timeout,
+		metrics: m,
+	}
+}
+
+// match returns the query for f on a collection whose time field is field.
+func (f Filter) match(field string) bson.M {
+	m := bson.M{field: bson.M{"$gte": f.Start.UTC(), "$lt": f.End.UTC()}}
+	if !f.BlogID.IsZero() {
+		m["blog_id"] = f.BlogID
+	} else {
+		m["author_id"] = f.AuthorID
+	}
+	return m
+}
+
+// Views returns the views matching f per period, which is PeriodHour or
+// PeriodDay, oldest first. Periods without views are left out. Unique
+// viewers are only known for the retention period of viewer records.
+func (r *Reports) Views(ctx context.Context, f Filter, period string) ([]Bucket, error) {
+	match := f.match("start")
+	match["period"] = period
+	views := map[time.Time]int64{}
+	err := r.aggregate(ctx, r.counts, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$start", "views": bson.M{"$sum": "$views"}}}},
+	}, func(cur *mongo.Cursor) error {
+		var doc struct {
+			Start time.Time `bson:"_id"`
+			Views int64     `bson:"views"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		views[doc.Start.UTC()] = doc.Views
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Viewers are recorded per hour; for days, a viewer seen in several
+	// hours of the same day is counted once.
+	bucket := interface{}("$hour")
+	if period == PeriodDay {
+		bucket = bson.M{"$dateFromParts": bson.M{
+			"year":  bson.M{"$year": "$hour"},
+			"month": bson.M{"$month": "$hour"},
+			"day":   bson.M{"$dayOfMonth": "$hour"},
+		}}
+	}
+	uniques := map[time.Time]int64{}
+	err = r.aggregate(ctx, r.viewers, mongo.Pipeline{
+		{{Key: "$match", Value: f.match("hour")}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"start": bucket, "viewer": "$viewer"}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_id.start", "viewers": bson.M{"$sum": 1}}}},
+	}, func(cur *mongo.Cursor) error {
+		var doc struct {
+			Start   time.Time `bson:"_id"`
+			Viewers int64     `bson:"viewers"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		uniques[doc.Start.UTC()] = doc.Viewers
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]Bucket, 0, len(views))
+	for start, n := range views {
+		buckets = append(buckets, Bucket{Start: start, Views: n, UniqueViewers: uniques[start]})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+// UniqueViewers counts the distinct viewers matching f over the whole
+// range.
+func (r *Reports) UniqueViewers(ctx context.Context, f Filter) (int64, error) {
+	var n int64
+	err := r.aggregate(ctx, r.viewers, mongo.Pipeline{
+		{{Key: "$match", Value: f.match("hour")}},
+		{{Key: "$group", Value: bson.M{"_id": "$viewer"}}},
+		{{Key: "$count", Value: "viewers"}},
+	}, func(cur *mongo.Cursor) error {
+		var doc struct {
+			Viewers int64 `bson:"viewers"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		n = doc.Viewers
+		return nil
+	})
+	return n, err
+}
+
+// TopPosts returns the limit most viewed blogs matching f, most viewed
+// first. Views are counted over whole UTC days.
+func (r *Reports) TopPosts(ctx context.Context, f Filter, limit int) ([]PostViews, error) {
+	match := f.match("start")
+	// Daily buckets are far fewer than hourly ones and enough to rank posts.
+	match["period"] = PeriodDay
+	match["start"] = bson.M{"$gte": startOfDay(f.Start), "$lt": f.End.UTC()}
+	var top []PostViews
+	err := r.aggregate(ctx, r.counts, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$blog_id", "views": bson.M{"$sum": "$views"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "views", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}, func(cur *mongo.Cursor) error {
+		var doc struct {
+			BlogID primitive.ObjectID `bson:"_id"`
+			Views  int64              `bson:"views"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		top = append(top, PostViews{BlogID: doc.BlogID, Views: doc.Views})
+		return nil
+	})
+	return top, err
+}
+
+func (r *Reports) aggregate(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, fn func(*mongo.Cursor) error) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	done := r.metrics.Start(coll.Name(), "aggregate")
+	defer func() { done(err) }()
+	cur, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		if err := fn(cur); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
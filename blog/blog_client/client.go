@@ -2,19 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"os"
 
 	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tenant"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 func main() {
 
 	var opts = grpc.WithInsecure()
 
-	cc, err := grpc.Dial("localhost:50051", opts)
+	tracer, err := tracing.New(tracing.OptionsFromEnv("blog_client"))
+	if err != nil {
+		log.Fatalf("Failed to set up tracing %v", err)
+	}
+	defer tracer.Shutdown(context.Background())
+	tenantID := os.Getenv("BLOG_TENANT_ID")
+	cc, err := grpc.Dial("localhost:50051", opts, tracer.DialOption(),
+		grpc.WithChainUnaryInterceptor(unaryTenant(tenantID)),
+		grpc.WithChainStreamInterceptor(streamTenant(tenantID)),
+	)
 
 	if err != nil {
 		log.Fatalf("Could not connect to server. %v", err)
@@ -42,18 +58,27 @@ func main() {
 	}
 
 	updateBlog(c, id, newBlog)
+	reactToBlog(c, id, "Reader", blogpb.Reaction_LIKE)
+	reactToBlog(c, id, "Reader", blogpb.Reaction_LIKE)
+	listReactions(c, id)
+	attachmentID := uploadAttachment(c, id, "notes.txt", []byte("Notes attached to the blog\n"))
+	downloadAttachment(c, id, attachmentID)
 	readBlog(c, id)
+	relatedBlogs(c, id)
 	deleteBlog(c, id)
 	readBlog(c, id)
 	listBlog(c)
 }
 
 func createBlog(c blogpb.BlogServiceClient, data *blogpb.Blog) string {
-	res, err := c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{
+	// The same key is sent on retries so the server does not create the blog twice.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "idempotency-key", newIdempotencyKey())
+	res, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{
 		Blog: data,
 	})
 	if err != nil {
-		log.Fatalf("Error while creating blog")
+		printFieldViolations(err)
+		log.Fatalf("Error while creating blog %v", err)
 	}
 	fmt.Printf("Blog created : %v\n", res)
 	return res.Blog.GetId()
@@ -79,12 +104,132 @@ func updateBlog(c blogpb.BlogServiceClient, id string, blog *blogpb.Blog) {
 	})
 	if err != nil {
 		fmt.Printf("Error while updating blog %v\n", err)
+		printFieldViolations(err)
 		return
 	}
 
 	fmt.Printf("Updated Blog: %v\n", res.GetBlog())
 }
 
+func relatedBlogs(c blogpb.BlogServiceClient, id string) {
+
+	res, err := c.GetRelatedBlogs(context.Background(), &blogpb.GetRelatedBlogsRequest{
+		BlogId: id,
+		Limit:  3,
+	})
+	if err != nil {
+		fmt.Printf("Error while finding related blogs %v\n", err)
+		return
+	}
+
+	for _, r := range res.GetBlogs() {
+		fmt.Printf("Related blog (score %.2f): %s\n", r.GetScore(), r.GetBlog().GetTitle())
+	}
+}
+
+func reactToBlog(c blogpb.BlogServiceClient, id, user string, reaction blogpb.Reaction) {
+
+	res, err := c.ReactToBlog(context.Background(), &blogpb.ReactToBlogRequest{
+		BlogId:   id,
+		UserId:   user,
+		Reaction: reaction,
+	})
+	if err != nil {
+		fmt.Printf("Error while reacting to blog %v\n", err)
+		printFieldViolations(err)
+		return
+	}
+
+	fmt.Printf("Reactions (changed %v): %v\n", res.GetChanged(), res.GetReactions())
+}
+
+func listReactions(c blogpb.BlogServiceClient, id string) {
+
+	stream, err := c.ListReactions(context.Background(), &blogpb.ListReactionsRequest{BlogId: id})
+	if err != nil {
+		fmt.Printf("error while opening stream %v\n", err)
+		return
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("error while processing stream response %v\n", err)
+			break
+		}
+		fmt.Printf("%s reacted %s at %v\n", res.GetUserId(), res.GetReaction(), res.GetReactedAt().AsTime())
+	}
+}
+
+func uploadAttachment(c blogpb.BlogServiceClient, id, filename string, content []byte) string {
+
+	stream, err := c.UploadAttachment(context.Background())
+	if err != nil {
+		fmt.Printf("error while opening stream %v\n", err)
+		return ""
+	}
+	// Metadata first, then the content in chunks the server accepts.
+	err = stream.Send(&blogpb.UploadAttachmentRequest{
+		Data: &blogpb.UploadAttachmentRequest_Metadata{Metadata: &blogpb.AttachmentMetadata{
+			BlogId:   id,
+			Filename: filename,
+			Size:     int64(len(content)),
+		}},
+	})
+	for len(content) > 0 && err == nil {
+		n := len(content)
+		if n > validation.MaxChunkSize {
+			n = validation.MaxChunkSize
+		}
+		err = stream.Send(&blogpb.UploadAttachmentRequest{
+			Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: content[:n]},
+		})
+		content = content[n:]
+	}
+	// A failed Send is reported by CloseAndRecv.
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		fmt.Printf("Error while uploading attachment %v\n", err)
+		printFieldViolations(err)
+		return ""
+	}
+
+	fmt.Printf("Attachment uploaded: %v\n", res.GetAttachment())
+	return res.GetAttachment().GetId()
+}
+
+func downloadAttachment(c blogpb.BlogServiceClient, id, attachmentID string) {
+
+	stream, err := c.DownloadAttachment(context.Background(), &blogpb.DownloadAttachmentRequest{
+		BlogId:       id,
+		AttachmentId: attachmentID,
+	})
+	if err != nil {
+		fmt.Printf("error while opening stream %v\n", err)
+		return
+	}
+
+	var content []byte
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error while downloading attachment %v\n", err)
+			return
+		}
+		if a := res.GetAttachment(); a != nil {
+			fmt.Printf("Downloading %s (%s, %d bytes)\n", a.GetFilename(), a.GetContentType(), a.GetSize())
+		}
+		content = append(content, res.GetChunk()...)
+	}
+	fmt.Printf("Attachment content: %q\n", content)
+}
+
 func deleteBlog(c blogpb.BlogServiceClient, id string) {
 
 	_, err := c.DeleteBlog(context.Background(), &blogpb.DeleteBlogRequest{
@@ -120,3 +265,39 @@ func listBlog(c blogpb.BlogServiceClient) {
 	}
 	stream.CloseSend()
 }
+
+func printFieldViolations(err error) {
+	for field, msgs := range validation.FieldViolations(err) {
+		for _, msg := range msgs {
+			fmt.Printf("  %s: %s\n", field, msg)
+		}
+	}
+}
+
+// unaryTenant names tenantID as the tenant of every call, for servers with
+// tenancy enabled. An empty tenantID names none.
+func unaryTenant(tenantID string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if tenantID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, tenant.MetadataKey, tenantID)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func streamTenant(tenantID string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if tenantID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, tenant.MetadataKey, tenantID)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Cannot generate idempotency key %v", err)
+	}
+	return hex.EncodeToString(b)
+}
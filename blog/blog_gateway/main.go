@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/gateway"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/lifecycle"
+)
+
+type Config struct {
+	BlogAddress string          `yaml:"blog_address" usage:"host:port of the blog gRPC server"`
+	CAFile      string          `yaml:"ca_file" usage:"CA certificate for a TLS blog server, empty for plaintext"`
+	HTTP        config.HTTP     `yaml:"http"`
+	Shutdown    config.Shutdown `yaml:"shutdown"`
+}
+
+func (c *Config) Validate() error {
+	if c.BlogAddress == "" {
+		return fmt.Errorf("blog_address: is required")
+	}
+	if c.HTTP.Address == "" {
+		return fmt.Errorf("http.address: is required")
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	return c.Shutdown.Validate()
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	cfg := &Config{
+		BlogAddress: "localhost:50051",
+		HTTP:        config.HTTP{Address: "localhost:8080"},
+		Shutdown:    config.DefaultShutdown,
+	}
+	config.MustLoad("BLOG_GATEWAY", cfg)
+
+	cc, err := gateway.Dial(cfg.BlogAddress, cfg.CAFile)
+	if err != nil {
+		log.Fatalf("Could not connect to blog server. %v", err)
+	}
+
+	mux := http.NewServeMux()
+	gateway.New(blogpb.NewBlogServiceClient(cc)).Register(mux)
+
+	runner := lifecycle.New(nil, nil, cfg.Shutdown.DrainTimeout, cfg.Shutdown.CloseTimeout)
+	runner.OnClose("blog server connection", func(context.Context) error { return cc.Close() })
+	if err := runner.AddHTTPServer(cfg.HTTP.Address, mux); err != nil {
+		log.Fatalf("Failed to start HTTP listner. %v", err)
+	}
+
+	fmt.Printf("Starting blog gateway on %s...\n", cfg.HTTP.Address)
+	if err := runner.Run(); err != nil {
+		log.Fatalf("Gateway stopped. %v", err)
+	}
+}
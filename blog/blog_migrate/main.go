@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Config shares the BLOG_ environment prefix with blog_server so both point
+// at the same database without extra setup.
+type Config struct {
+	Mongo   config.Mongo  `yaml:"mongo"`
+	Tenancy TenancyConfig `yaml:"tenancy"`
+	Timeout time.Duration `yaml:"timeout" usage:"how long the command, including waiting for the lock, may take"`
+}
+
+// TenancyConfig is the part of blog_server's tenancy section telling
+// whether the tenants' databases need migrating too.
+type TenancyConfig struct {
+	Enabled bool `yaml:"enabled" usage:"also run the command on the database of every tenant listed in the main database"`
+}
+
+func (c *Config) Validate() error {
+	return c.Mongo.Validate()
+}
+
+const usage = `usage: blog_migrate [flags] up | down [n] | status`
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	cfg := &Config{
+		Mongo: config.Mongo{
+			URI:      "mongodb://localhost:27017",
+			Database: "mydb",
+		},
+		Timeout: 10 * time.Minute,
+	}
+	// Read the mongo section of blog_server's config file and ignore the rest.
+	args := config.MustLoad("BLOG", cfg, config.AllowUnknownFields())
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		log.Fatalf("Error while connecting to Mongodb %v", err)
+	}
+	defer client.Disconnect(context.Background())
+	databases := []string{cfg.Mongo.Database}
+	if cfg.Tenancy.Enabled {
+		tenants, err := migrations.TenantDatabases(ctx, client.Database(cfg.Mongo.Database))
+		if err != nil {
+			log.Fatalf("Failed to list tenants %v", err)
+		}
+		databases = append(databases, tenants...)
+	}
+
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+	case "down":
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("down expects a positive number of steps, got %q", args[1])
+			}
+		}
+	default:
+		log.Fatal(usage)
+	}
+	for _, name := range databases {
+		if len(databases) > 1 {
+			fmt.Printf("Database %s\n", name)
+		}
+		run(ctx, migrations.New(client.Database(name), migrations.All), args[0], steps)
+	}
+}
+
+// run runs command on the database of m.
+func run(ctx context.Context, m *migrations.Migrator, command string, steps int) {
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("Applied %d %s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Printf("Reverted %d %s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed %v", err)
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, st := range statuses {
+			applied := "pending"
+			if st.Applied {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, applied)
+		}
+		w.Flush()
+	}
+}
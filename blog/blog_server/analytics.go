@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/analytics"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultAnalyticsRange = 7 * 24 * time.Hour
+	defaultTopPosts       = 10
+	// Longest ranges a report may cover, bounding the number of buckets.
+	maxHourlyRange = 31 * 24 * time.Hour
+	maxDailyRange  = 366 * 24 * time.Hour
+)
+
+// recordView counts a read of data. Readers that do not name themselves
+// are told apart by address, the same way rate limiting does.
+func (s *server) recordView(ctx context.Context, req *blogpb.ReadBlogRequest, data *BlogItem) {
+	viewer := req.GetViewerId()
+	if viewer != "" {
+		viewer = "viewer:" + viewer
+	} else {
+		viewer = s.peerKey(ctx, "", nil)
+	}
+	s.views.Record(analytics.View{
+		BlogID:   data.ID,
+		AuthorID: data.AuthorID,
+		Viewer:   viewer,
+		At:       time.Now(),
+	})
+}
+
+func (s *server) GetBlogAnalytics(ctx context.Context, req *blogpb.GetBlogAnalyticsRequest) (*blogpb.GetBlogAnalyticsResponse, error) {
+	logging.FromContext(ctx).Debug("reporting blog analytics", "blog_id", req.GetBlogId(), "author_id", req.GetAuthorId())
+	if s.reports == nil {
+		return nil, status.Error(codes.Unimplemented, "Analytics are disabled on this server")
+	}
+	if err := validation.ValidateGetBlogAnalyticsRequest(req); err != nil {
+		return nil, err
+	}
+	period, unit, max := analytics.PeriodDay, 24*time.Hour, maxDailyRange
+	if req.GetGranularity() == blogpb.Granularity_HOUR {
+		period, unit, max = analytics.PeriodHour, time.Hour, maxHourlyRange
+	}
+	f := analytics.Filter{AuthorID: req.GetAuthorId()}
+	f.Start, f.End = analyticsRange(req, unit, time.Now())
+	if f.End.Sub(f.Start) > max {
+		v := &validation.Violations{}
+		v.Add("start_time", "range must be at most %v for %s granularity", max, period)
+		return nil, v.Err()
+	}
+	if req.GetBlogId() != "" {
+		id := validation.ObjectID(req.GetBlogId())
+		if _, err := s.store.Get(ctx, id); err != nil {
+			return nil, storeError(ctx, err, "Failed to report analytics")
+		}
+		f.BlogID = id
+	}
+
+	buckets, err := s.reports.Views(ctx, f, period)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to report analytics")
+	}
+	res := &blogpb.GetBlogAnalyticsResponse{}
+	for _, b := range buckets {
+		res.Views = append(res.Views, &blogpb.ViewBucket{
+			StartTime:     timestamppb.New(b.Start),
+			Views:         b.Views,
+			UniqueViewers: b.UniqueViewers,
+		})
+		res.TotalViews += b.Views
+	}
+	if res.UniqueViewers, err = s.reports.UniqueViewers(ctx, f); err != nil {
+		return nil, storeError(ctx, err, "Failed to report analytics")
+	}
+	if f.BlogID.IsZero() {
+		if res.TopPosts, err = s.topPosts(ctx, f, req.GetTopPosts()); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// topPosts ranks the author's blogs in f and adds their titles. Blogs
+// deleted since they were viewed are listed without one.
+func (s *server) topPosts(ctx context.Context, f analytics.Filter, n int32) ([]*blogpb.PostViews, error) {
+	if n == 0 {
+		n = defaultTopPosts
+	}
+	top, err := s.reports.TopPosts(ctx, f, int(n))
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to report analytics")
+	}
+	posts := make([]*blogpb.PostViews, 0, len(top))
+	for _, p := range top {
+		post := &blogpb.PostViews{BlogId: p.BlogID.Hex(), Views: p.Views}
+		data, err := s.store.Get(ctx, p.BlogID)
+		switch {
+		case err == nil:
+			post.Title = data.Title
+		case err != errBlogNotFound:
+			return nil, storeError(ctx, err, "Failed to report analytics")
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// analyticsRange resolves the range of req, rounded out to whole units.
+func analyticsRange(req *blogpb.GetBlogAnalyticsRequest, unit time.Duration, now time.Time) (time.Time, time.Time) {
+	end := now
+	if req.GetEndTime() != nil {
+		end = req.GetEndTime().AsTime()
+	}
+	start := end.Add(-defaultAnalyticsRange)
+	if req.GetStartTime() != nil {
+		start = req.GetStartTime().AsTime()
+	}
+	// Truncating UTC times by whole hours or days aligns them to UTC
+	// hour or day boundaries.
+	start = start.UTC().Truncate(unit)
+	if t := end.UTC().Truncate(unit); !t.Equal(end) {
+		end = t.Add(unit)
+	}
+	return start, end.UTC()
+}
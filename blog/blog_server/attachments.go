@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/blob"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// downloadChunkSize is the size of the chunks DownloadAttachment sends.
+	downloadChunkSize = 64 << 10
+	// releaseTimeout bounds cleaning up the content of deleted attachments.
+	releaseTimeout = 30 * time.Second
+)
+
+// UploadAttachment stages the content in a temporary file while hashing it,
+// then stores it in the blob store under its SHA-256 unless it is there
+// already, and only then references it from the blog. Content found stored
+// is checked again once referenced, as the blog referencing it before may
+// have been deleted and released it in between.
+func (s *server) UploadAttachment(stream blogpb.BlogService_UploadAttachmentServer) error {
+	ctx := stream.Context()
+	if s.blobs == nil {
+		return status.Error(codes.Unimplemented, "Attachments are disabled on this server")
+	}
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return validation.ValidateAttachmentMetadata(nil)
+	}
+	if err != nil {
+		return err
+	}
+	meta := req.GetMetadata()
+	if err := validation.ValidateAttachmentMetadata(meta); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Debug("uploading attachment", "blog_id", meta.GetBlogId(), "filename", meta.GetFilename())
+	if meta.GetSize() > s.attachments.MaxSize {
+		return status.Errorf(codes.ResourceExhausted, "Attachment exceeds the limit of %d bytes", s.attachments.MaxSize)
+	}
+	id := validation.ObjectID(meta.GetBlogId())
+	// Fail before the content is sent when the blog does not exist.
+	if _, err := s.store.Get(ctx, id); err != nil {
+		return storeError(ctx, err, "Failed to upload attachment")
+	}
+
+	upload, err := s.stage(stream)
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+	if meta.GetSize() > 0 && meta.GetSize() != upload.size {
+		return status.Errorf(codes.InvalidArgument, "Attachment is %d bytes, not the %d announced", upload.size, meta.GetSize())
+	}
+	contentType, err := s.contentType(meta.GetContentType(), upload.head)
+	if err != nil {
+		return err
+	}
+	sum := upload.sum()
+	stored, err := s.blobs.Exists(ctx, sum)
+	if err != nil {
+		return storeError(ctx, err, "Failed to store attachment")
+	}
+	if !stored {
+		if err := s.put(ctx, sum, upload); err != nil {
+			return err
+		}
+	}
+
+	item := &AttachmentItem{
+		ID:          primitive.NewObjectID(),
+		Filename:    meta.GetFilename(),
+		ContentType: contentType,
+		Size:        upload.size,
+		SHA256:      sum,
+		CreatedAt:   now(),
+	}
+	if err := s.store.AddAttachment(ctx, id, item, s.attachments.MaxPerBlog); err != nil {
+		if !stored {
+			s.release(ctx, sum)
+		}
+		if err == errTooManyAttachments {
+			return status.Errorf(codes.FailedPrecondition, "Blog already has the maximum of %d attachments", s.attachments.MaxPerBlog)
+		}
+		return storeError(ctx, err, "Failed to upload attachment")
+	}
+	if stored {
+		// The reference now keeps release from deleting the content, so
+		// it only has to be put back if it went before.
+		if stored, err = s.blobs.Exists(ctx, sum); err == nil && !stored {
+			err = s.put(ctx, sum, upload)
+		}
+		if err != nil {
+			return storeError(ctx, err, "Failed to store attachment")
+		}
+	}
+	s.enqueueThumbnails(ctx, id, *item)
+	return stream.SendAndClose(&blogpb.UploadAttachmentResponse{Attachment: attachmentToPB(item)})
+}
+
+// put stores the staged content of upload under sum.
+func (s *server) put(ctx context.Context, sum string, upload *stagedUpload) error {
+	if _, err := upload.file.Seek(0, io.SeekStart); err != nil {
+		return status.Errorf(codes.Internal, "Failed to store attachment %v", err)
+	}
+	if err := s.blobs.Put(ctx, sum, upload.file); err != nil {
+		return storeError(ctx, err, "Failed to store attachment")
+	}
+	return nil
+}
+
+// stagedUpload is the content of an upload received so far.
+type stagedUpload struct {
+	file *os.File
+	hash hash.Hash
+	size int64
+	// head holds the first bytes, enough to sniff the content type.
+	head []byte
+}
+
+// stage receives the content chunks of stream into a temporary file.
+func (s *server) stage(stream blogpb.BlogService_UploadAttachmentServer) (*stagedUpload, error) {
+	f, err := ioutil.TempFile(s.attachments.TempDir, "upload-")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to stage attachment %v", err)
+	}
+	u := &stagedUpload{file: f, hash: sha256.New()}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			u.Close()
+			return nil, err
+		}
+		if req.GetMetadata() != nil {
+			u.Close()
+			return nil, status.Error(codes.InvalidArgument, "Metadata must only be sent in the first message")
+		}
+		chunk := req.GetChunk()
+		if err := validation.ValidateChunk(chunk); err != nil {
+			u.Close()
+			return nil, err
+		}
+		if u.size+int64(len(chunk)) > s.attachments.MaxSize {
+			u.Close()
+			return nil, status.Errorf(codes.ResourceExhausted, "Attachment exceeds the limit of %d bytes", s.attachments.MaxSize)
+		}
+		if n := 512 - len(u.head); n > 0 {
+			if n > len(chunk) {
+				n = len(chunk)
+			}
+			u.head = append(u.head, chunk[:n]...)
+		}
+		if _, err := io.MultiWriter(u.file, u.hash).Write(chunk); err != nil {
+			u.Close()
+			return nil, status.Errorf(codes.Internal, "Failed to stage attachment %v", err)
+		}
+		u.size += int64(len(chunk))
+	}
+	if u.size == 0 {
+		u.Close()
+		return nil, status.Error(codes.InvalidArgument, "Attachment must not be empty")
+	}
+	return u, nil
+}
+
+// sum returns the hex encoded SHA-256 of the content.
+func (u *stagedUpload) sum() string {
+	return hex.EncodeToString(u.hash.Sum(nil))
+}
+
+// Close removes the temporary file.
+func (u *stagedUpload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// contentType decides the type an upload is stored as. It is sniffed from
+// the content, so clients cannot have, say, a script stored as an image.
+// The declared type is only used when sniffing finds nothing more specific
+// than text or binary data, and never for images, which are always
+// recognised.
+func (s *server) contentType(declared string, head []byte) (string, error) {
+	sniffed := http.DetectContentType(head)
+	contentType := sniffed
+	if declared != "" {
+		sniffedType, _, _ := mime.ParseMediaType(sniffed)
+		declaredType, params, _ := mime.ParseMediaType(declared)
+		switch {
+		case declaredType == sniffedType:
+		case (sniffedType == "text/plain" || sniffedType == "application/octet-stream") &&
+			!strings.HasPrefix(declaredType, "image/"):
+			contentType = mime.FormatMediaType(declaredType, params)
+		default:
+			v := &validation.Violations{}
+			v.Add("metadata.content_type", "content looks like %s, not %s", sniffedType, declaredType)
+			return "", v.Err()
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range s.attachments.AllowedTypes {
+		if mediaType == allowed {
+			return contentType, nil
+		}
+	}
+	return "", status.Errorf(codes.InvalidArgument, "Attachments of type %s are not allowed", mediaType)
+}
+
+func (s *server) DownloadAttachment(req *blogpb.DownloadAttachmentRequest, stream blogpb.BlogService_DownloadAttachmentServer) error {
+	ctx := stream.Context()
+	logging.FromContext(ctx).Debug("downloading attachment", "blog_id", req.GetBlogId(), "attachment_id", req.GetAttachmentId())
+	if s.blobs == nil {
+		return status.Error(codes.Unimplemented, "Attachments are disabled on this server")
+	}
+	if err := validation.ValidateDownloadAttachmentRequest(req); err != nil {
+		return err
+	}
+	id := validation.ObjectID(req.GetBlogId())
+	attachmentID := validation.ObjectID(req.GetAttachmentId())
+	data, err := s.store.Get(ctx, id)
+	if err != nil {
+		return storeError(ctx, err, "Failed to download attachment")
+	}
+	var item *AttachmentItem
+	for i := range data.Attachments {
+		if data.Attachments[i].ID == attachmentID {
+			item = &data.Attachments[i]
+		}
+	}
+	if item == nil {
+		return status.Error(codes.NotFound, "Failed to download attachment, attachment not found")
+	}
+	sum := item.SHA256
+	if w := int(req.GetThumbnailWidth()); w > 0 {
+		sum = ""
+		for _, t := range item.Thumbnails {
+			if t.Width == w {
+				sum = t.SHA256
+			}
+		}
+		if sum == "" {
+			return status.Errorf(codes.NotFound, "Failed to download attachment, no thumbnail %d pixels wide", w)
+		}
+	}
+
+	r, err := s.blobs.Open(ctx, sum)
+	if err == blob.ErrNotFound {
+		return status.Error(codes.DataLoss, "Failed to download attachment, its content is missing")
+	}
+	if err != nil {
+		return storeError(ctx, err, "Failed to download attachment")
+	}
+	defer r.Close()
+	if err := stream.Send(&blogpb.DownloadAttachmentResponse{
+		Data: &blogpb.DownloadAttachmentResponse_Attachment{Attachment: attachmentToPB(item)},
+	}); err != nil {
+		return err
+	}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := stream.Send(&blogpb.DownloadAttachmentResponse{
+				Data: &blogpb.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]},
+			}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return storeError(ctx, err, "Failed to download attachment")
+		}
+	}
+}
+
+// releaseAttachments deletes the content of the attachments of a deleted
+// blog that no other blog references.
+func (s *server) releaseAttachments(ctx context.Context, deleted *BlogItem) {
+	if s.blobs == nil || len(deleted.Attachments) == 0 {
+		return
+	}
+	// The blog is gone whether or not the caller is still waiting.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	seen := make(map[string]bool)
+	for _, a := range deleted.Attachments {
+		sums := []string{a.SHA256}
+		for _, t := range a.Thumbnails {
+			sums = append(sums, t.SHA256)
+		}
+		for _, sum := range sums {
+			if !seen[sum] {
+				seen[sum] = true
+				s.release(ctx, sum)
+			}
+		}
+	}
+}
+
+// release deletes the content with hash sum unless a blog references it,
+// as an attachment or a thumbnail. An upload of the same content racing
+// with it stores the content again once it references it. Failures only
+// leave unreferenced content behind and are logged.
+func (s *server) release(ctx context.Context, sum string) {
+	referenced, err := s.store.AttachmentReferenced(ctx, sum)
+	if err == nil && !referenced {
+		err = s.blobs.Delete(ctx, sum)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to delete unreferenced attachment content", "sha256", sum, "err", err)
+	}
+}
+
+func attachmentToPB(a *AttachmentItem) *blogpb.Attachment {
+	return &blogpb.Attachment{
+		Id:          a.ID.Hex(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Sha256:      a.SHA256,
+		CreatedAt:   timestamppb.New(a.CreatedAt),
+		Thumbnails:  thumbnailsToPB(a.Thumbnails),
+	}
+}
+
+func thumbnailsToPB(items []ThumbnailItem) []*blogpb.Thumbnail {
+	if len(items) == 0 {
+		return nil
+	}
+	thumbs := make([]*blogpb.Thumbnail, len(items))
+	for i, t := range items {
+		thumbs[i] = &blogpb.Thumbnail{
+			Width:       int32(t.Width),
+			Height:      int32(t.Height),
+			ContentType: t.ContentType,
+			Size:        t.Size,
+			Sha256:      t.SHA256,
+		}
+	}
+	return thumbs
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/cache"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/singleflight"
+)
+
+// blogCache holds blogs read from the store. Implementations must be safe
+// for concurrent use. The in-process LRU is the only one today; a cache
+// shared between replicas can be plugged in by implementing this interface.
+type blogCache interface {
+	Get(ctx context.Context, id primitive.ObjectID) (*BlogItem, bool)
+	Add(ctx context.Context, item *BlogItem)
+	Remove(ctx context.Context, id primitive.ObjectID)
+	Stats() cache.Stats
+}
+
+// lruBlogCache is the blogCache kept in the server's memory.
+type lruBlogCache struct {
+	lru *cache.LRU
+	// prefix keeps the blogs of tenants sharing the LRU apart.
+	prefix string
+}
+
+func newLRUBlogCache(size int, ttl time.Duration) *lruBlogCache {
+	return &lruBlogCache{lru: cache.NewLRU(size, ttl)}
+}
+
+// forTenant returns a cache of the blogs of tenant id sharing the space of
+// c with the other tenants.
+func (c *lruBlogCache) forTenant(id string) *lruBlogCache {
+	return &lruBlogCache{lru: c.lru, prefix: id + "/"}
+}
+
+func (c *lruBlogCache) Get(_ context.Context, id primitive.ObjectID) (*BlogItem, bool) {
+	v, ok := c.lru.Get(c.prefix + id.Hex())
+	if !ok {
+		return nil, false
+	}
+	return v.(*BlogItem), true
+}
+
+func (c *lruBlogCache) Add(_ context.Context, item *BlogItem) {
+	c.lru.Add(c.prefix+item.ID.Hex(), item)
+}
+
+func (c *lruBlogCache) Remove(_ context.Context, id primitive.ObjectID) {
+	c.lru.Remove(c.prefix + id.Hex())
+}
+
+func (c *lruBlogCache) Stats() cache.Stats {
+	return c.lru.Stats()
+}
+
+// cachedStore is a blogStore reading blogs through a cache. Concurrent
+// misses for the same blog share a single store read, and updates, deletes
+// and reactions drop the blog from the cache once they reach the store.
+//
+// Writes made by other replicas are not seen until the entry expires, so
+// the cache TTL bounds how stale a read can be.
+type cachedStore struct {
+	blogStore
+	cache blogCache
+	group singleflight.Group
+
+	// mu orders filling the cache against invalidating it. reads holds the
+	// blogs being read from the store; invalidating a blog bumps its
+	// generation so a read that raced with a write does not cache what it
+	// read. Writes to other blogs leave the read alone.
+	mu    sync.Mutex
+	reads map[primitive.ObjectID]*pendingRead
+}
+
+// pendingRead is the generation of a blog being read from the store and
+// the number of reads of it in flight.
+type pendingRead struct {
+	gen     uint64
+	readers int
+}
+
+func newCachedStore(store blogStore, c blogCache) *cachedStore {
+	return &cachedStore{blogStore: store, cache: c, reads: make(map[primitive.ObjectID]*pendingRead)}
+}
+
+func (c *cachedStore) Get(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	if item, ok := c.cache.Get(ctx, id); ok {
+		return copyItem(item), nil
+	}
+	// The shared read must not fail for every waiter because the caller
+	// that started it went away, so it ignores that caller's cancellation
+	// and relies on the store's own timeout instead.
+	readCtx := context.WithoutCancel(ctx)
+	ch := c.group.DoChan(id.Hex(), func() (interface{}, error) {
+		c.mu.Lock()
+		read := c.reads[id]
+		if read == nil {
+			read = &pendingRead{}
+			c.reads[id] = read
+		}
+		read.readers++
+		gen := read.gen
+		c.mu.Unlock()
+
+		item, err := c.blogStore.Get(readCtx, id)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if read.readers--; read.readers == 0 {
+			delete(c.reads, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if read.gen == gen {
+			c.cache.Add(readCtx, item)
+		}
+		return item, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return copyItem(res.Val.(*BlogItem)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *cachedStore) Update(ctx context.Context, item *BlogItem, fields []string) (*BlogItem, error) {
+	updated, err := c.blogStore.Update(ctx, item, fields)
+	// A failed update may still have been applied, so invalidate anyway.
+	c.invalidate(ctx, item.ID)
+	return updated, err
+}
+
+func (c *cachedStore) Delete(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	deleted, err := c.blogStore.Delete(ctx, id)
+	c.invalidate(ctx, id)
+	return deleted, err
+}
+
+func (c *cachedStore) AddAttachment(ctx context.Context, id primitive.ObjectID, a *AttachmentItem, max int) error {
+	err := c.blogStore.AddAttachment(ctx, id, a, max)
+	c.invalidate(ctx, id)
+	return err
+}
+
+func (c *cachedStore) SetThumbnails(ctx context.Context, id, attachmentID primitive.ObjectID, thumbs []ThumbnailItem) error {
+	err := c.blogStore.SetThumbnails(ctx, id, attachmentID, thumbs)
+	c.invalidate(ctx, id)
+	return err
+}
+
+// React invalidates the blog since its reaction counts changed. Concurrent
+// misses still share one read, so a burst of reactions to a popular blog
+// costs at most one read per reaction.
+func (c *cachedStore) React(ctx context.Context, id primitive.ObjectID, userID, reaction string) (map[string]int64, bool, error) {
+	counts, changed, err := c.blogStore.React(ctx, id, userID, reaction)
+	if changed || err != nil {
+		c.invalidate(ctx, id)
+	}
+	return counts, changed, err
+}
+
+func (c *cachedStore) Unreact(ctx context.Context, id primitive.ObjectID, userID, reaction string) (map[string]int64, bool, error) {
+	counts, changed, err := c.blogStore.Unreact(ctx, id, userID, reaction)
+	if changed || err != nil {
+		c.invalidate(ctx, id)
+	}
+	return counts, changed, err
+}
+
+func (c *cachedStore) invalidate(ctx context.Context, id primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if read := c.reads[id]; read != nil {
+		read.gen++
+	}
+	c.cache.Remove(ctx, id)
+	// Later readers must not join a read that started before the write.
+	c.group.Forget(id.Hex())
+}
+
+// copyItem returns a copy of a cached blog so callers cannot modify the
+// cached value.
+func copyItem(item *BlogItem) *BlogItem {
+	cp := *item
+	cp.Tags = append([]string(nil), item.Tags...)
+	cp.Attachments = append([]AttachmentItem(nil), item.Attachments...)
+	for i := range cp.Attachments {
+		cp.Attachments[i].Thumbnails = append([]ThumbnailItem(nil), cp.Attachments[i].Thumbnails...)
+	}
+	if item.Reactions != nil {
+		cp.Reactions = make(map[string]int64, len(item.Reactions))
+		for k, n := range item.Reactions {
+			cp.Reactions[k] = n
+		}
+	}
+	return &cp
+}
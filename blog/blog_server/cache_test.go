@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// slowStore is a blogStore whose Get, while block is set, reads the blog
+// and then waits until the channel it sends on started is closed. Only the
+// methods cachedStore reads through are implemented.
+type slowStore struct {
+	blogStore
+
+	mu    sync.Mutex
+	blogs map[primitive.ObjectID]*BlogItem
+	gets  int
+
+	block   bool
+	started chan chan struct{}
+}
+
+func newSlowStore(blogs ...*BlogItem) *slowStore {
+	s := &slowStore{
+		blogs:   make(map[primitive.ObjectID]*BlogItem),
+		started: make(chan chan struct{}, 10),
+	}
+	for _, b := range blogs {
+		s.blogs[b.ID] = b
+	}
+	return s
+}
+
+func (s *slowStore) Get(_ context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	s.mu.Lock()
+	s.gets++
+	b, ok := s.blogs[id]
+	if ok {
+		b = copyItem(b)
+	}
+	block := s.block
+	s.mu.Unlock()
+	if block {
+		release := make(chan struct{})
+		s.started <- release
+		<-release
+	}
+	if !ok {
+		return nil, errBlogNotFound
+	}
+	return b, nil
+}
+
+func (s *slowStore) Update(_ context.Context, item *BlogItem, _ []string) (*BlogItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blogs[item.ID] = copyItem(item)
+	return item, nil
+}
+
+func (s *slowStore) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+func newTestCachedStore(blogs ...*BlogItem) (*cachedStore, *slowStore) {
+	store := newSlowStore(blogs...)
+	return newCachedStore(store, newLRUBlogCache(100, time.Hour)), store
+}
+
+type getResult struct {
+	item *BlogItem
+	err  error
+}
+
+func getAsync(c *cachedStore, ctx context.Context, id primitive.ObjectID) <-chan getResult {
+	ch := make(chan getResult, 1)
+	go func() {
+		item, err := c.Get(ctx, id)
+		ch <- getResult{item, err}
+	}()
+	return ch
+}
+
+func TestCachedStoreServesHitsFromCache(t *testing.T) {
+	ctx := context.Background()
+	blog := &BlogItem{ID: primitive.NewObjectID(), Title: "first", Tags: []string{"go"}}
+	c, store := newTestCachedStore(blog)
+
+	got, err := c.Get(ctx, blog.ID)
+	if err != nil || got.Title != "first" {
+		t.Fatalf("Get() = %+v, %v, want the blog", got, err)
+	}
+	// Callers get copies and cannot change the cached blog.
+	got.Tags[0] = "changed"
+	again, err := c.Get(ctx, blog.ID)
+	if err != nil || again.Tags[0] != "go" {
+		t.Fatalf("second Get() = %+v, %v, want the unchanged blog", again, err)
+	}
+	if n := store.getCount(); n != 1 {
+		t.Errorf("store read %d times, want 1", n)
+	}
+	if st := c.cache.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Errorf("cache stats = %+v, want one hit and one miss", st)
+	}
+
+	if _, err := c.Update(ctx, &BlogItem{ID: blog.ID, Title: "second"}, nil); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	got, err = c.Get(ctx, blog.ID)
+	if err != nil || got.Title != "second" {
+		t.Errorf("Get() after Update() = %+v, %v, want the updated blog", got, err)
+	}
+}
+
+func TestCachedStoreDoesNotCacheMisses(t *testing.T) {
+	ctx := context.Background()
+	c, store := newTestCachedStore()
+	id := primitive.NewObjectID()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, id); !errors.Is(err, errBlogNotFound) {
+			t.Fatalf("Get() of a missing blog = %v, want errBlogNotFound", err)
+		}
+	}
+	if n := store.getCount(); n != 2 {
+		t.Errorf("store read %d times, want 2", n)
+	}
+}
+
+func TestCachedStoreSharesConcurrentMisses(t *testing.T) {
+	blog := &BlogItem{ID: primitive.NewObjectID(), Title: "first"}
+	c, store := newTestCachedStore(blog)
+	store.block = true
+
+	results := []<-chan getResult{getAsync(c, context.Background(), blog.ID)}
+	release := <-store.started
+	for i := 0; i < 4; i++ {
+		results = append(results, getAsync(c, context.Background(), blog.ID))
+	}
+	// Wait for every caller to miss the cache and join the read.
+	for c.cache.Stats().Misses < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for _, ch := range results {
+		if res := <-ch; res.err != nil || res.item.Title != "first" {
+			t.Errorf("Get() = %+v, %v, want the blog", res.item, res.err)
+		}
+	}
+	if n := store.getCount(); n != 1 {
+		t.Errorf("store read %d times, want 1", n)
+	}
+}
+
+func TestCachedStoreDropsReadRacingWithWrite(t *testing.T) {
+	ctx := context.Background()
+	blog := &BlogItem{ID: primitive.NewObjectID(), Title: "first"}
+	c, store := newTestCachedStore(blog)
+	store.block = true
+
+	// A read of the old blog is in flight when the blog is updated.
+	stale := getAsync(c, ctx, blog.ID)
+	releaseStale := <-store.started
+	if _, err := c.Update(ctx, &BlogItem{ID: blog.ID, Title: "second"}, nil); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	// A read starting after the write does not join the one before it.
+	fresh := getAsync(c, ctx, blog.ID)
+	releaseFresh := <-store.started
+	close(releaseFresh)
+	if res := <-fresh; res.err != nil || res.item.Title != "second" {
+		t.Fatalf("Get() after Update() = %+v, %v, want the updated blog", res.item, res.err)
+	}
+	// The racing read finishes last and must not replace the cached blog.
+	close(releaseStale)
+	if res := <-stale; res.err != nil || res.item.Title != "first" {
+		t.Fatalf("racing Get() = %+v, %v, want the blog it read", res.item, res.err)
+	}
+
+	got, err := c.Get(ctx, blog.ID)
+	if err != nil || got.Title != "second" {
+		t.Errorf("Get() = %+v, %v, want the updated blog", got, err)
+	}
+	if n := store.getCount(); n != 2 {
+		t.Errorf("store read %d times, want 2", n)
+	}
+}
+
+func TestCachedStoreKeepsReadsOfOtherBlogs(t *testing.T) {
+	ctx := context.Background()
+	blog := &BlogItem{ID: primitive.NewObjectID(), Title: "first"}
+	other := &BlogItem{ID: primitive.NewObjectID(), Title: "other"}
+	c, store := newTestCachedStore(blog, other)
+	store.block = true
+
+	read := getAsync(c, ctx, blog.ID)
+	release := <-store.started
+	if _, err := c.Update(ctx, &BlogItem{ID: other.ID, Title: "other changed"}, nil); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	close(release)
+	if res := <-read; res.err != nil {
+		t.Fatalf("Get() = %v", res.err)
+	}
+	if _, err := c.Get(ctx, blog.ID); err != nil {
+		t.Fatalf("second Get() = %v", err)
+	}
+	if n := store.getCount(); n != 1 {
+		t.Errorf("store read %d times, want the first read cached", n)
+	}
+}
+
+func TestCachedStoreCallerCancellation(t *testing.T) {
+	blog := &BlogItem{ID: primitive.NewObjectID(), Title: "first"}
+	c, store := newTestCachedStore(blog)
+	store.block = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := getAsync(c, ctx, blog.ID)
+	release := <-store.started
+	cancel()
+	if res := <-first; !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("cancelled Get() = %v, want context.Canceled", res.err)
+	}
+	// The shared read carries on and fills the cache for later callers.
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := c.cache.Get(context.Background(), blog.ID); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the cancelled caller's read did not fill the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/analytics"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/outbox"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/related"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/thumbnail"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/webhook"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/blob"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tenant"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Config struct {
+	Server        config.Server     `yaml:"server"`
+	Mongo         config.Mongo      `yaml:"mongo"`
+	MongoTimeouts MongoTimeouts     `yaml:"mongo_timeouts"`
+	Cache         CacheConfig       `yaml:"cache"`
+	Analytics     AnalyticsConfig   `yaml:"analytics"`
+	Site          config.Site       `yaml:"site"`
+	Feeds         FeedsConfig       `yaml:"feeds"`
+	Sitemap       SitemapConfig     `yaml:"sitemap"`
+	Attachments   AttachmentsConfig `yaml:"attachments"`
+	Thumbnails    ThumbnailsConfig  `yaml:"thumbnails"`
+	Webhooks      WebhooksConfig    `yaml:"webhooks"`
+	Outbox        OutboxConfig      `yaml:"outbox"`
+	Related       RelatedConfig     `yaml:"related"`
+	Tenancy       TenancyConfig     `yaml:"tenancy"`
+	Idempotency   IdempotencyConfig `yaml:"idempotency"`
+	Shutdown      config.Shutdown   `yaml:"shutdown"`
+	HTTP          config.HTTP       `yaml:"http"`
+	Health        config.Health     `yaml:"health"`
+	Migrations    MigrationsConfig  `yaml:"migrations"`
+	Gateway       GatewayConfig     `yaml:"gateway"`
+	GRPCWeb       config.GRPCWeb    `yaml:"grpc_web"`
+	Metrics       config.Metrics    `yaml:"metrics"`
+	Tracing       config.Tracing    `yaml:"tracing"`
+	Logging       config.Logging    `yaml:"logging"`
+	RateLimit     config.RateLimit  `yaml:"rate_limit"`
+}
+
+// GatewayConfig controls the HTTP/JSON API served on the HTTP listener.
+type GatewayConfig struct {
+	Enabled bool   `yaml:"enabled" usage:"serve the /v1/blogs HTTP/JSON API on the HTTP listener"`
+	CAFile  string `yaml:"ca_file" usage:"CA certificate the gateway trusts when the gRPC server uses TLS"`
+}
+
+type MigrationsConfig struct {
+	Auto    bool          `yaml:"auto" usage:"apply pending schema migrations at startup"`
+	Timeout time.Duration `yaml:"timeout" usage:"how long startup migrations, including waiting for the lock, may take"`
+}
+
+// MongoTimeouts caps how long each kind of blog store operation may take,
+// regardless of the deadline the client asked for.
+type MongoTimeouts struct {
+	Insert time.Duration `yaml:"insert" usage:"maximum time for inserting a blog"`
+	Find   time.Duration `yaml:"find" usage:"maximum time for reading a blog"`
+	Update time.Duration `yaml:"update" usage:"maximum time for updating a blog"`
+	Delete time.Duration `yaml:"delete" usage:"maximum time for deleting a blog"`
+	List   time.Duration `yaml:"list" usage:"maximum time for streaming all blogs"`
+	Report time.Duration `yaml:"report" usage:"maximum time for each query of an analytics report"`
+}
+
+func (t MongoTimeouts) Validate() error {
+	for name, d := range map[string]time.Duration{
+		"insert": t.Insert, "find": t.Find, "update": t.Update, "delete": t.Delete, "list": t.List,
+		"report": t.Report,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("mongo_timeouts.%s: must be positive", name)
+		}
+	}
+	return nil
+}
+
+// CacheConfig sizes the cache ReadBlog reads through.
+type CacheConfig struct {
+	Size int           `yaml:"size" usage:"number of blogs kept in memory for ReadBlog; 0 disables the cache"`
+	TTL  time.Duration `yaml:"ttl" usage:"how long a cached blog is served before it is read again"`
+}
+
+func (c CacheConfig) Validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("cache.size: must not be negative")
+	}
+	if c.Size > 0 && c.TTL <= 0 {
+		return fmt.Errorf("cache.ttl: must be positive")
+	}
+	return nil
+}
+
+// AnalyticsConfig controls how ReadBlog views are counted.
+type AnalyticsConfig struct {
+	Enabled       bool          `yaml:"enabled" usage:"count blog views and serve GetBlogAnalytics"`
+	DedupWindow   time.Duration `yaml:"dedup_window" usage:"repeated views of a blog by the same viewer within this window count once"`
+	DedupSize     int           `yaml:"dedup_size" usage:"number of recent viewer and blog pairs remembered for deduplication"`
+	BufferSize    int           `yaml:"buffer_size" usage:"views buffered before they are written; views beyond it are dropped"`
+	FlushInterval time.Duration `yaml:"flush_interval" usage:"how often buffered views are written"`
+	Retention     time.Duration `yaml:"retention" usage:"how long viewer records used to count unique viewers are kept"`
+}
+
+func (c AnalyticsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.DedupWindow <= 0 {
+		return fmt.Errorf("analytics.dedup_window: must be positive")
+	}
+	if c.DedupSize < 1 {
+		return fmt.Errorf("analytics.dedup_size: must be positive")
+	}
+	if c.BufferSize < 1 {
+		return fmt.Errorf("analytics.buffer_size: must be positive")
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("analytics.flush_interval: must be positive")
+	}
+	if c.Retention < time.Hour {
+		return fmt.Errorf("analytics.retention: must be at least an hour")
+	}
+	return nil
+}
+
+// Options returns the analytics.Options implied by the configuration.
+func (c AnalyticsConfig) Options() analytics.Options {
+	return analytics.Options{
+		DedupWindow:   c.DedupWindow,
+		DedupSize:     c.DedupSize,
+		BufferSize:    c.BufferSize,
+		FlushInterval: c.FlushInterval,
+		Retention:     c.Retention,
+	}
+}
+
+// FeedsConfig controls the RSS and Atom feeds served on the HTTP listener.
+type FeedsConfig struct {
+	Enabled bool `yaml:"enabled" usage:"serve RSS and Atom feeds under /feeds/ on the HTTP listener; must be false with tenancy.enabled"`
+	Limit   int  `yaml:"limit" usage:"number of most recent blogs in each feed"`
+}
+
+func (c FeedsConfig) Validate(h config.HTTP) error {
+	if !c.Enabled {
+		return nil
+	}
+	if h.Address == "" {
+		return fmt.Errorf("feeds.enabled: requires http.address")
+	}
+	if c.Limit < 1 || c.Limit > maxFeedLimit {
+		return fmt.Errorf("feeds.limit: must be between 1 and %d", maxFeedLimit)
+	}
+	return nil
+}
+
+// SitemapConfig controls the sitemap served on the HTTP listener.
+type SitemapConfig struct {
+	Enabled bool `yaml:"enabled" usage:"serve /sitemap.xml listing every blog on the HTTP listener; must be false with tenancy.enabled"`
+}
+
+func (c SitemapConfig) Validate(h config.HTTP) error {
+	if c.Enabled && h.Address == "" {
+		return fmt.Errorf("sitemap.enabled: requires http.address")
+	}
+	return nil
+}
+
+// AttachmentsConfig controls UploadAttachment and where attachments are
+// kept.
+type AttachmentsConfig struct {
+	Enabled      bool     `yaml:"enabled" usage:"accept UploadAttachment and serve DownloadAttachment"`
+	Backend      string   `yaml:"backend" usage:"where attachment content is kept: gridfs or local"`
+	Bucket       string   `yaml:"bucket" usage:"GridFS bucket the gridfs backend keeps attachments in"`
+	Dir          string   `yaml:"dir" usage:"directory the local backend keeps attachments in"`
+	TempDir      string   `yaml:"temp_dir" usage:"directory uploads are staged in until complete, empty for the system default"`
+	MaxSize      int64    `yaml:"max_size" usage:"largest attachment accepted, in bytes"`
+	MaxPerBlog   int      `yaml:"max_per_blog" usage:"most attachments a blog may have"`
+	AllowedTypes []string `yaml:"allowed_types" usage:"media types attachments may have, as detected from their content"`
+}
+
+func (c AttachmentsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Backend {
+	case "gridfs":
+		if c.Bucket == "" {
+			return fmt.Errorf("attachments.bucket: is required for the gridfs backend")
+		}
+	case "local":
+		if c.Dir == "" {
+			return fmt.Errorf("attachments.dir: is required for the local backend")
+		}
+	default:
+		return fmt.Errorf("attachments.backend: must be gridfs or local, got %q", c.Backend)
+	}
+	if c.MaxSize < 1 {
+		return fmt.Errorf("attachments.max_size: must be positive")
+	}
+	if c.MaxPerBlog < 1 {
+		return fmt.Errorf("attachments.max_per_blog: must be positive")
+	}
+	if len(c.AllowedTypes) == 0 {
+		return fmt.Errorf("attachments.allowed_types: must not be empty")
+	}
+	return nil
+}
+
+// BlobStore returns the blob store of the configured backend for the blogs
+// kept in db. The local backend keeps the attachments of each tenant in a
+// directory of its own; tenantID is empty outside multi-tenant servers.
+func (c AttachmentsConfig) BlobStore(db *mongo.Database, tenantID string) (blob.Store, error) {
+	if c.Backend == "local" {
+		return blob.NewLocalStore(filepath.Join(c.Dir, tenantID))
+	}
+	return blob.NewGridFSStore(db, c.Bucket), nil
+}
+
+// ThumbnailsConfig controls the thumbnails generated for image attachments.
+type ThumbnailsConfig struct {
+	Enabled     bool          `yaml:"enabled" usage:"generate thumbnails of PNG, JPEG and GIF attachments"`
+	Widths      []int         `yaml:"widths" usage:"widths of the thumbnails in pixels; images are never scaled up"`
+	Workers     int           `yaml:"workers" usage:"number of thumbnails generated at once"`
+	QueueSize   int           `yaml:"queue_size" usage:"attachments waiting for thumbnails; uploads beyond it get none"`
+	MaxPixels   int           `yaml:"max_pixels" usage:"largest image, in pixels, thumbnails are generated for"`
+	JPEGQuality int           `yaml:"jpeg_quality" usage:"quality from 1 to 100 of JPEG thumbnails"`
+	Timeout     time.Duration `yaml:"timeout" usage:"how long generating the thumbnails of one attachment may take"`
+}
+
+func (c ThumbnailsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Widths) == 0 {
+		return fmt.Errorf("thumbnails.widths: must not be empty")
+	}
+	for _, w := range c.Widths {
+		if w < 1 || w > maxThumbnailWidth {
+			return fmt.Errorf("thumbnails.widths: must be between 1 and %d, got %d", maxThumbnailWidth, w)
+		}
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("thumbnails.workers: must be positive")
+	}
+	if c.QueueSize < 1 {
+		return fmt.Errorf("thumbnails.queue_size: must be positive")
+	}
+	if c.MaxPixels < 1 {
+		return fmt.Errorf("thumbnails.max_pixels: must be positive")
+	}
+	if c.JPEGQuality < 1 || c.JPEGQuality > 100 {
+		return fmt.Errorf("thumbnails.jpeg_quality: must be between 1 and 100")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("thumbnails.timeout: must be positive")
+	}
+	return nil
+}
+
+// maxThumbnailWidth bounds the configured thumbnail widths; wider images are
+// better served as they are.
+const maxThumbnailWidth = 4096
+
+// Options returns the thumbnail.Options implied by the configuration.
+func (c ThumbnailsConfig) Options() thumbnail.Options {
+	return thumbnail.Options{
+		Widths:      c.Widths,
+		MaxPixels:   c.MaxPixels,
+		JPEGQuality: c.JPEGQuality,
+	}
+}
+
+// WebhooksConfig controls the delivery of blog events to webhooks.
+type WebhooksConfig struct {
+	Enabled      bool          `yaml:"enabled" usage:"serve the webhook RPCs and deliver blog events to webhooks"`
+	MaxWebhooks  int           `yaml:"max_webhooks" usage:"most webhooks that may be registered"`
+	Workers      int           `yaml:"workers" usage:"number of deliveries made at once"`
+	QueueSize    int           `yaml:"queue_size" usage:"events and retries waiting for a worker; those beyond it are dropped"`
+	Timeout      time.Duration `yaml:"timeout" usage:"how long each delivery request may take"`
+	MaxAttempts  int           `yaml:"max_attempts" usage:"requests made for a delivery before it fails"`
+	Backoff      time.Duration `yaml:"backoff" usage:"wait before the first retry of a delivery, doubled before each further one"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" usage:"longest wait between retries"`
+	DisableAfter int           `yaml:"disable_after" usage:"failed deliveries in a row after which a webhook is disabled"`
+	Retention    time.Duration `yaml:"retention" usage:"how long the record of each delivery is kept"`
+	AllowPrivate bool          `yaml:"allow_private" usage:"allow webhooks on private, loopback and link-local addresses"`
+}
+
+func (c WebhooksConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxWebhooks < 1 {
+		return fmt.Errorf("webhooks.max_webhooks: must be positive")
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("webhooks.workers: must be positive")
+	}
+	if c.QueueSize < 1 {
+		return fmt.Errorf("webhooks.queue_size: must be positive")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("webhooks.timeout: must be positive")
+	}
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("webhooks.max_attempts: must be positive")
+	}
+	if c.Backoff <= 0 {
+		return fmt.Errorf("webhooks.backoff: must be positive")
+	}
+	if c.MaxBackoff <= 0 {
+		return fmt.Errorf("webhooks.max_backoff: must be positive")
+	}
+	if c.DisableAfter < 1 {
+		return fmt.Errorf("webhooks.disable_after: must be positive")
+	}
+	if c.Retention <= 0 {
+		return fmt.Errorf("webhooks.retention: must be positive")
+	}
+	if c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("webhooks.max_backoff: must not be shorter than webhooks.backoff")
+	}
+	return nil
+}
+
+// Options returns the webhook.Options implied by the configuration.
+func (c WebhooksConfig) Options() webhook.Options {
+	return webhook.Options{
+		Timeout:      c.Timeout,
+		MaxAttempts:  c.MaxAttempts,
+		Backoff:      c.Backoff,
+		MaxBackoff:   c.MaxBackoff,
+		DisableAfter: c.DisableAfter,
+		AllowPrivate: c.AllowPrivate,
+	}
+}
+
+// OutboxConfig controls publishing blog events through a transactional
+// outbox.
+type OutboxConfig struct {
+	Enabled    bool          `yaml:"enabled" usage:"record blog events in an outbox in the transaction of each change; needs a replica set"`
+	Publisher  string        `yaml:"publisher" usage:"where outbox events are published: webhooks or file"`
+	File       string        `yaml:"file" usage:"file the file publisher appends events to, one JSON object per line"`
+	BatchSize  int           `yaml:"batch_size" usage:"outbox entries read at a time"`
+	Interval   time.Duration `yaml:"interval" usage:"how often the outbox is checked for entries written by other replicas"`
+	Timeout    time.Duration `yaml:"timeout" usage:"how long publishing one entry may take"`
+	Backoff    time.Duration `yaml:"backoff" usage:"wait before retrying a failed publish, doubled after each further failure"`
+	MaxBackoff time.Duration `yaml:"max_backoff" usage:"longest wait between retries"`
+	Lease      time.Duration `yaml:"lease" usage:"how long a replica keeps the right to publish without renewing it"`
+	Retention  time.Duration `yaml:"retention" usage:"how long delivered entries are kept"`
+}
+
+func (c OutboxConfig) Validate(w WebhooksConfig, t TenancyConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Publisher {
+	case "webhooks":
+		if !w.Enabled {
+			return fmt.Errorf("outbox.publisher: webhooks requires webhooks.enabled")
+		}
+	case "file":
+		if c.File == "" {
+			return fmt.Errorf("outbox.file: is required with the file publisher")
+		}
+		// Every tenant has an outbox of its own, and their relays would
+		// interleave in the file.
+		if t.Enabled {
+			return fmt.Errorf("outbox.publisher: file is not supported with tenancy.enabled")
+		}
+	default:
+		return fmt.Errorf("outbox.publisher: must be webhooks or file, got %q", c.Publisher)
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("outbox.batch_size: must be positive")
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("outbox.interval: must be positive")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("outbox.timeout: must be positive")
+	}
+	if c.Backoff <= 0 {
+		return fmt.Errorf("outbox.backoff: must be positive")
+	}
+	if c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("outbox.max_backoff: must not be shorter than outbox.backoff")
+	}
+	if c.Lease <= c.Timeout {
+		return fmt.Errorf("outbox.lease: must be longer than outbox.timeout")
+	}
+	if c.Retention <= 0 {
+		return fmt.Errorf("outbox.retention: must be positive")
+	}
+	return nil
+}
+
+// Options returns the outbox.Options implied by the configuration.
+func (c OutboxConfig) Options() outbox.Options {
+	return outbox.Options{
+		BatchSize:  c.BatchSize,
+		Interval:   c.Interval,
+		Timeout:    c.Timeout,
+		Backoff:    c.Backoff,
+		MaxBackoff: c.MaxBackoff,
+		Lease:      c.Lease,
+	}
+}
+
+// RelatedConfig controls finding related blogs.
+type RelatedConfig struct {
+	Enabled         bool          `yaml:"enabled" usage:"serve GetRelatedBlogs from an in-memory index of every blog"`
+	DefaultLimit    int           `yaml:"default_limit" usage:"related blogs returned when a call asks for no number"`
+	RebuildInterval time.Duration `yaml:"rebuild_interval" usage:"how often the index is rebuilt to pick up changes made through other replicas"`
+	RebuildTimeout  time.Duration `yaml:"rebuild_timeout" usage:"maximum time for rebuilding the index, reading every blog included"`
+}
+
+func (c RelatedConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.DefaultLimit < 1 || c.DefaultLimit > validation.MaxRelatedBlogs {
+		return fmt.Errorf("related.default_limit: must be between 1 and %d", validation.MaxRelatedBlogs)
+	}
+	if c.RebuildInterval <= 0 {
+		return fmt.Errorf("related.rebuild_interval: must be positive")
+	}
+	if c.RebuildTimeout <= 0 {
+		return fmt.Errorf("related.rebuild_timeout: must be positive")
+	}
+	return nil
+}
+
+// Options returns the related.Options implied by the configuration.
+func (c RelatedConfig) Options() related.Options {
+	return related.Options{RebuildInterval: c.RebuildInterval, RebuildTimeout: c.RebuildTimeout}
+}
+
+// TenancyConfig lets one server host the blogs of several tenants, each in
+// a database of its own.
+type TenancyConfig struct {
+	Enabled        bool          `yaml:"enabled" usage:"serve several tenants, each with its blogs in a database of its own; requires feeds.enabled and sitemap.enabled to be false"`
+	Source         string        `yaml:"source" usage:"how calls name their tenant: metadata (x-tenant-id) or certificate (client certificate common name)"`
+	DatabasePrefix string        `yaml:"database_prefix" usage:"prefix of tenant database names, followed by the tenant id"`
+	AdminToken     string        `yaml:"admin_token" secret:"true" usage:"token BlogAdminService calls must carry in x-admin-token metadata"`
+	CacheTTL       time.Duration `yaml:"cache_ttl" usage:"how long tenants are cached; suspensions made through other replicas take up to this long to apply"`
+}
+
+func (c TenancyConfig) Validate(s config.Server) error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Source {
+	case "metadata":
+	case "certificate":
+		if !s.TLS.Enabled || s.TLS.ClientCAFile == "" {
+			return fmt.Errorf("tenancy.source: certificate requires server.tls.enabled and server.tls.client_ca_file")
+		}
+	default:
+		return fmt.Errorf("tenancy.source: must be metadata or certificate, got %q", c.Source)
+	}
+	if c.DatabasePrefix == "" {
+		return fmt.Errorf("tenancy.database_prefix: must not be empty")
+	}
+	// MongoDB database names are at most 63 bytes.
+	if len(c.DatabasePrefix)+validation.MaxTenantIDLength > 63 {
+		return fmt.Errorf("tenancy.database_prefix: must be at most %d characters", 63-validation.MaxTenantIDLength)
+	}
+	if strings.ContainsAny(c.DatabasePrefix, "/\\. \"$*<>:|?") {
+		return fmt.Errorf("tenancy.database_prefix: must be valid in a MongoDB database name")
+	}
+	if c.AdminToken == "" {
+		return fmt.Errorf("tenancy.admin_token: is required")
+	}
+	if c.CacheTTL <= 0 {
+		return fmt.Errorf("tenancy.cache_ttl: must be positive")
+	}
+	return nil
+}
+
+// Resolver returns how the tenant of a call is found.
+func (c TenancyConfig) Resolver() tenant.Resolver {
+	if c.Source == "certificate" {
+		return tenant.FromCertificate
+	}
+	return tenant.FromMetadata
+}
+
+type IdempotencyConfig struct {
+	Store string        `yaml:"store" usage:"where idempotency keys are kept: mongo or memory"`
+	TTL   time.Duration `yaml:"ttl" usage:"how long responses are kept for replay to retried calls"`
+	Lease time.Duration `yaml:"lease" usage:"how long a call in progress holds its key before a retry may take it over"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: config.Server{
+			Address: "localhost:50051",
+			TLS: config.TLS{
+				CertFile: "ssl/server.crt",
+				KeyFile:  "ssl/server.pem",
+			},
+		},
+		Mongo: config.Mongo{
+			URI:      "mongodb://localhost:27017",
+			Database: "mydb",
+		},
+		MongoTimeouts: MongoTimeouts{
+			Insert: 5 * time.Second,
+			Find:   5 * time.Second,
+			Update: 5 * time.Second,
+			Delete: 5 * time.Second,
+			List:   time.Minute,
+			Report: 30 * time.Second,
+		},
+		Cache: CacheConfig{
+			Size: 10000,
+			TTL:  5 * time.Minute,
+		},
+		Analytics: AnalyticsConfig{
+			Enabled:       true,
+			DedupWindow:   30 * time.Minute,
+			DedupSize:     100000,
+			BufferSize:    10000,
+			FlushInterval: 5 * time.Second,
+			Retention:     90 * 24 * time.Hour,
+		},
+		Site: config.DefaultSite,
+		Feeds: FeedsConfig{
+			Enabled: true,
+			Limit:   20,
+		},
+		Sitemap: SitemapConfig{Enabled: true},
+		Attachments: AttachmentsConfig{
+			Enabled:    true,
+			Backend:    "gridfs",
+			Bucket:     "attachments",
+			Dir:        "attachments",
+			MaxSize:    10 << 20,
+			MaxPerBlog: 20,
+			AllowedTypes: []string{
+				"image/png", "image/jpeg", "image/gif", "image/webp",
+				"application/pdf", "application/zip",
+				"text/plain", "text/markdown", "text/csv",
+			},
+		},
+		Thumbnails: ThumbnailsConfig{
+			Enabled:     true,
+			Widths:      []int{160, 320, 640},
+			Workers:     2,
+			QueueSize:   100,
+			MaxPixels:   40000000,
+			JPEGQuality: 85,
+			Timeout:     time.Minute,
+		},
+		Webhooks: WebhooksConfig{
+			Enabled:      true,
+			MaxWebhooks:  20,
+			Workers:      4,
+			QueueSize:    1000,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  6,
+			Backoff:      time.Second,
+			MaxBackoff:   5 * time.Minute,
+			DisableAfter: 5,
+			Retention:    30 * 24 * time.Hour,
+		},
+		Outbox: OutboxConfig{
+			Publisher:  "webhooks",
+			BatchSize:  100,
+			Interval:   time.Second,
+			Timeout:    10 * time.Second,
+			Backoff:    time.Second,
+			MaxBackoff: time.Minute,
+			Lease:      30 * time.Second,
+			Retention:  7 * 24 * time.Hour,
+		},
+		Related: RelatedConfig{
+			Enabled:         true,
+			DefaultLimit:    5,
+			RebuildInterval: time.Hour,
+			RebuildTimeout:  10 * time.Minute,
+		},
+		Tenancy: TenancyConfig{
+			Source:         "metadata",
+			DatabasePrefix: "blog_",
+			CacheTTL:       10 * time.Second,
+		},
+		Idempotency: IdempotencyConfig{
+			Store: "mongo",
+			TTL:   24 * time.Hour,
+			Lease: time.Minute,
+		},
+		Shutdown: config.DefaultShutdown,
+		HTTP:     config.HTTP{Address: "localhost:8081"},
+		Health:   config.DefaultHealth,
+		Migrations: MigrationsConfig{
+			Auto:    true,
+			Timeout: 5 * time.Minute,
+		},
+		Gateway: GatewayConfig{
+			Enabled: true,
+			CAFile:  "ssl/ca.crt",
+		},
+		GRPCWeb: config.DefaultGRPCWeb,
+		Metrics: config.DefaultMetrics,
+		Tracing: config.DefaultTracing,
+		Logging: config.DefaultLogging,
+		RateLimit: config.RateLimit{
+			Enabled: true,
+			Key:     "peer",
+			Methods: []string{"*=50:100", "/blog.BlogService/CreateBlog=1:10"},
+			Streams: []string{"/blog.BlogService/ListBlog=4", "/blog.BlogService/UploadAttachment=2"},
+			// The HTTP gateway calls the server over loopback.
+			TrustedProxies: []string{"127.0.0.1", "::1"},
+		},
+	}
+}
+
+func (c *Config) Validate() error {
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	if err := c.Mongo.Validate(); err != nil {
+		return err
+	}
+	if err := c.MongoTimeouts.Validate(); err != nil {
+		return err
+	}
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+	if err := c.Analytics.Validate(); err != nil {
+		return err
+	}
+	if err := c.Site.Validate(); err != nil {
+		return err
+	}
+	if err := c.Feeds.Validate(c.HTTP); err != nil {
+		return err
+	}
+	if err := c.Sitemap.Validate(c.HTTP); err != nil {
+		return err
+	}
+	if err := c.Attachments.Validate(); err != nil {
+		return err
+	}
+	if err := c.Thumbnails.Validate(); err != nil {
+		return err
+	}
+	if err := c.Webhooks.Validate(); err != nil {
+		return err
+	}
+	if err := c.Outbox.Validate(c.Webhooks, c.Tenancy); err != nil {
+		return err
+	}
+	if err := c.Related.Validate(); err != nil {
+		return err
+	}
+	if err := c.Tenancy.Validate(c.Server); err != nil {
+		return err
+	}
+	if c.Tenancy.Enabled {
+		// Feeds and sitemaps are plain HTTP requests, which name no tenant.
+		if c.Feeds.Enabled {
+			return fmt.Errorf("feeds.enabled: is not supported with tenancy.enabled, set it to false")
+		}
+		if c.Sitemap.Enabled {
+			return fmt.Errorf("sitemap.enabled: is not supported with tenancy.enabled, set it to false")
+		}
+	}
+	if err := c.Shutdown.Validate(); err != nil {
+		return err
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	if err := c.Health.Validate(); err != nil {
+		return err
+	}
+	if c.Migrations.Timeout <= 0 {
+		return fmt.Errorf("migrations.timeout: must be positive")
+	}
+	if err := c.GRPCWeb.Validate(c.HTTP, c.Server.TLS); err != nil {
+		return err
+	}
+	if err := c.Metrics.Validate(c.HTTP); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if c.Gateway.Enabled && c.HTTP.Address == "" {
+		return fmt.Errorf("gateway.enabled: requires http.address")
+	}
+	if c.Gateway.Enabled && c.Server.TLS.ClientCAFile != "" {
+		// The gateway has no client certificate to present.
+		return fmt.Errorf("gateway.enabled: is not supported with server.tls.client_ca_file")
+	}
+	if c.Idempotency.Store != "mongo" && c.Idempotency.Store != "memory" {
+		return fmt.Errorf("idempotency.store: must be mongo or memory, got %q", c.Idempotency.Store)
+	}
+	if c.Idempotency.TTL <= 0 {
+		return fmt.Errorf("idempotency.ttl: must be positive")
+	}
+	if c.Idempotency.Lease <= 0 || c.Idempotency.Lease > c.Idempotency.TTL {
+		return fmt.Errorf("idempotency.lease: must be positive and at most idempotency.ttl")
+	}
+	return nil
+}
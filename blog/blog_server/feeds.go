@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/feed"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+)
+
+// maxFeedLimit bounds feeds.limit, as every request reads that many blogs.
+const maxFeedLimit = 100
+
+// feedHandler serves the most recent blogs as RSS and Atom feeds:
+//
+//	/feeds/{rss,atom}.xml
+//	/feeds/authors/{authorId}/{rss,atom}.xml
+//	/feeds/tags/{tag}/{rss,atom}.xml
+//
+// Feeds carry an ETag, so readers polling them are answered with 304 Not
+// Modified while nothing has changed. They have no Last-Modified date: the
+// newest update among the blogs listed goes back in time when that blog is
+// deleted, and readers would then miss the change.
+type feedHandler struct {
+	store blogStore
+	site  config.Site
+	limit int
+}
+
+func newFeedHandler(store blogStore, site config.Site, limit int) *feedHandler {
+	return &feedHandler{store: store, site: site, limit: limit}
+}
+
+func (h *feedHandler) Register(mux *http.ServeMux) {
+	mux.Handle("/feeds/", h)
+}
+
+func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !readOnly(w, r) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/feeds/"), "/")
+	var authorID, tag, title string
+	switch {
+	case len(parts) == 1:
+		title = h.site.Title
+	case len(parts) == 3 && parts[0] == "authors" && parts[1] != "":
+		authorID = parts[1]
+		title = fmt.Sprintf("%s: posts by %s", h.site.Title, authorID)
+	case len(parts) == 3 && parts[0] == "tags" && validation.ValidateTag(parts[1]) == nil:
+		tag = parts[1]
+		title = fmt.Sprintf("%s: posts tagged %s", h.site.Title, tag)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	var render func(*feed.Feed) ([]byte, error)
+	var contentType string
+	switch parts[len(parts)-1] {
+	case "rss.xml":
+		render, contentType = (*feed.Feed).RSS, feed.RSSContentType
+	case "atom.xml":
+		render, contentType = (*feed.Feed).Atom, feed.AtomContentType
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	items, err := h.store.Recent(r.Context(), authorID, tag, h.limit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to read feed", "path", r.URL.Path, "err", err)
+		http.Error(w, "failed to read feed", http.StatusInternalServerError)
+		return
+	}
+	f := h.feed(items)
+	f.Title = title
+	f.Self = h.site.BaseURL + r.URL.EscapedPath()
+	body, err := render(f)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to render feed", "path", r.URL.Path, "err", err)
+		http.Error(w, "failed to render feed", http.StatusInternalServerError)
+		return
+	}
+	serveDocument(w, r, contentType, time.Time{}, body)
+}
+
+// readOnly rejects requests other than GET and HEAD, reporting whether r
+// may be served.
+func readOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// serveDocument writes a generated document with an ETag derived from its
+// content and modified as its Last-Modified date, unless modified is zero.
+// Requests repeating either get 304 Not Modified instead.
+func serveDocument(w http.ResponseWriter, r *http.Request, contentType string, modified time.Time, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
+
+// feed converts items, the way ListBlog returns them, into feed entries
+// linking to the blogs' pages on the site.
+func (h *feedHandler) feed(items []*BlogItem) *feed.Feed {
+	f := &feed.Feed{
+		Description: h.site.Description,
+		Link:        h.site.BaseURL + "/",
+	}
+	host := feed.Host(h.site.BaseURL)
+	for _, data := range items {
+		blog := dataToBlog(data)
+		updated := blog.GetUpdatedAt().AsTime()
+		if updated.After(f.Updated) {
+			f.Updated = updated
+		}
+		f.Items = append(f.Items, feed.Item{
+			ID:         feed.TagURI(host, blog.GetCreatedAt().AsTime(), "blog/"+blog.GetId()),
+			Title:      blog.GetTitle(),
+			Link:       h.site.BlogURL(blog.GetSlug()),
+			Author:     blog.GetAuthorId(),
+			Content:    blog.GetContent(),
+			Categories: blog.GetTags(),
+			Published:  blog.GetCreatedAt().AsTime(),
+			Updated:    updated,
+		})
+	}
+	return f
+}
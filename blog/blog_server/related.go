@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/related"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) GetRelatedBlogs(ctx context.Context, req *blogpb.GetRelatedBlogsRequest) (*blogpb.GetRelatedBlogsResponse, error) {
+	logging.FromContext(ctx).Debug("finding related blogs", "blog_id", req.GetBlogId())
+	if s.related == nil {
+		return nil, status.Error(codes.Unimplemented, "Related blogs are disabled on this server")
+	}
+	if err := validation.ValidateGetRelatedBlogsRequest(req); err != nil {
+		return nil, err
+	}
+	id := validation.ObjectID(req.GetBlogId())
+	if !s.related.Ready() {
+		return nil, status.Error(codes.Unavailable, "Related blogs are still being indexed")
+	}
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = s.relatedConfig.DefaultLimit
+	}
+	// Read even when indexed, as it may have been deleted through another
+	// replica since the index was built.
+	data, err := s.store.Get(ctx, id)
+	if err == errBlogNotFound {
+		s.related.Remove(id.Hex())
+	}
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to find related blogs")
+	}
+	matches, ok := s.related.Similar(id.Hex(), limit)
+	if !ok {
+		// Created through another replica since the index was built.
+		s.related.Add(blogDocument(data))
+		matches, _ = s.related.Similar(id.Hex(), limit)
+	}
+	res := &blogpb.GetRelatedBlogsResponse{}
+	for _, m := range matches {
+		other, _ := primitive.ObjectIDFromHex(m.ID)
+		data, err := s.store.Get(ctx, other)
+		if err == errBlogNotFound {
+			// Deleted through another replica since the index was built.
+			s.related.Remove(m.ID)
+			continue
+		}
+		if err != nil {
+			return nil, storeError(ctx, err, "Failed to find related blogs")
+		}
+		res.Blogs = append(res.Blogs, &blogpb.RelatedBlog{Blog: dataToBlog(data), Score: m.Score})
+	}
+	return res, nil
+}
+
+// scanBlogs is the related.Scanner reading every blog in the store. It is
+// bounded by the rebuild timeout rather than mongo_timeouts.list.
+func (s *server) scanBlogs(ctx context.Context, fn func(related.Document) error) error {
+	return s.store.Scan(ctx, func(data *BlogItem) error {
+		return fn(blogDocument(data))
+	})
+}
+
+func blogDocument(data *BlogItem) related.Document {
+	return related.Document{
+		ID:      data.ID.Hex(),
+		Title:   data.Title,
+		Content: data.Content,
+		Tags:    data.Tags,
+	}
+}
@@ -2,211 +2,613 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
 	"net"
-	"os"
-	"os/signal"
+	"net/http"
+	"time"
 
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/analytics"
 	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/gateway"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/outbox"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/related"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/sitemap"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/webhook"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/blob"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/grpcweb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/healthcheck"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/idempotency"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/lifecycle"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/ratelimit"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tenant"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/workerpool"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type BlogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id"`
-	Title    string             `bson:"title"`
-	Content  string             `bson:"content"`
-}
-
 type server struct {
+	store blogStore
+	// views records ReadBlog calls and reports answers GetBlogAnalytics.
+	// Both are nil when analytics are disabled.
+	views   *analytics.Recorder
+	reports *analytics.Reports
+	// blobs keeps the content of attachments, limited by attachments. It
+	// is nil when attachments are disabled.
+	blobs       blob.Store
+	attachments AttachmentsConfig
+	// thumbnails generates the thumbnails of image attachments in the
+	// background. It is nil when thumbnails are disabled.
+	thumbnails      *workerpool.Pool
+	thumbnailConfig ThumbnailsConfig
+	// webhooks keeps the webhooks dispatcher delivers events to. Both are
+	// nil when webhooks are disabled.
+	webhooks      webhook.Store
+	dispatcher    *webhook.Dispatcher
+	webhookConfig WebhooksConfig
+	// relay publishes the events the store records in the outbox. It is nil
+	// when the outbox is disabled.
+	relay        *outbox.Relay
+	outboxConfig OutboxConfig
+	// related finds similar blogs. It is nil when disabled.
+	related       *related.Index
+	relatedConfig RelatedConfig
+	// peerKey tells apart readers that do not name themselves.
+	peerKey ratelimit.KeyFunc
 }
 
-func (*server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
-	fmt.Println("Creating blog.")
+func (s *server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
+	logging.FromContext(ctx).Debug("creating blog")
+	if err := validation.ValidateCreateBlogRequest(req); err != nil {
+		return nil, err
+	}
 	blog := req.GetBlog()
 	data := &BlogItem{
 		AuthorID: blog.AuthorId,
 		Title:    blog.Title,
 		Content:  blog.GetContent(),
+		Tags:     blog.GetTags(),
 	}
 
-	res, err := collection.InsertOne(context.Background(), data)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Internal error %v", err),
-		)
-	}
-	oid, ok := res.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, status.Errorf(codes.Internal,
-			fmt.Sprintf("Cannot convert to OID %v", ok),
-		)
-	}
-	blog.Id = oid.Hex()
+	if _, err := s.store.Create(ctx, data); err != nil {
+		return nil, storeError(ctx, err, "Internal error")
+	}
+	s.related.Add(blogDocument(data))
+	s.publish(ctx, webhook.BlogCreated, data)
 	return &blogpb.CreateBlogResponse{
-		Blog: blog,
+		Blog: dataToBlog(data),
 	}, nil
 }
 
-func (*server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
-	fmt.Println("Reading blog.")
-	id, err := primitive.ObjectIDFromHex(req.GetId())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Unable to parse object id from hex %v", err))
+func (s *server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
+	logging.FromContext(ctx).Debug("reading blog", "blog_id", req.GetId())
+	if err := validation.ValidateReadBlogRequest(req); err != nil {
+		return nil, err
 	}
-	filter := bson.M{"_id": id}
-	res := collection.FindOne(context.Background(), filter)
-	data := &BlogItem{}
-	if err := res.Decode(data); err != nil {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Not found blog with id %v", id))
+	id := validation.ObjectID(req.GetId())
+	data, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to read blog")
 	}
+	s.recordView(ctx, req, data)
 
 	return &blogpb.ReadBlogResponse{
 		Blog: dataToBlog(data),
 	}, nil
 }
 
-func (*server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
-	fmt.Println("Updating blog request")
+func (s *server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
+	logging.FromContext(ctx).Debug("updating blog", "blog_id", req.GetBlog().GetId())
+	if err := validation.ValidateUpdateBlogRequest(req); err != nil {
+		return nil, err
+	}
 	blog := req.GetBlog()
-	id, err := primitive.ObjectIDFromHex(blog.GetId())
+	id := validation.ObjectID(blog.GetId())
+	data := &BlogItem{
+		ID:       id,
+		AuthorID: blog.AuthorId,
+		Title:    blog.Title,
+		Content:  blog.Content,
+		Tags:     blog.GetTags(),
+	}
+	updated, err := s.store.Update(ctx, data, req.GetUpdateMask().GetPaths())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Unable to parse object id from hex %v\n", err))
-	}
-	data := &BlogItem{}
-	filter := bson.M{"_id": id}
-	res := collection.FindOne(context.Background(), filter)
-	if err := res.Decode(data); err != nil {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Not found blog with id %v\n", id))
-	}
-	data.AuthorID = blog.AuthorId
-	data.Title = blog.Title
-	data.Content = blog.Content
-	_, updateErr := collection.ReplaceOne(context.Background(), filter, data)
-	if updateErr != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Failed to update blog %v\n", updateErr),
-		)
+		return nil, storeError(ctx, err, "Failed to update blog")
 	}
+	s.related.Add(blogDocument(updated))
+	s.publish(ctx, webhook.BlogUpdated, updated)
 	return &blogpb.UpdateBlogResponse{
-		Blog: dataToBlog(data),
+		Blog: dataToBlog(updated),
 	}, nil
 }
 
-func (*server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
-	fmt.Println("Deleting blog...")
-	id, err := primitive.ObjectIDFromHex(req.GetBlogId())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Unable to parse object id from hex %v\n", err))
+func (s *server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
+	logging.FromContext(ctx).Debug("deleting blog", "blog_id", req.GetBlogId())
+	if err := validation.ValidateDeleteBlogRequest(req); err != nil {
+		return nil, err
 	}
-	filter := bson.M{"_id": id}
-	res, delErr := collection.DeleteOne(context.Background(), filter)
-	if delErr != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Failed to delete blog %v\n", delErr),
-		)
-	}
-
-	if res.DeletedCount == 0 {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Not found blog with id %v\n", id))
+	id := validation.ObjectID(req.GetBlogId())
+	deleted, err := s.store.Delete(ctx, id)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to delete blog")
 	}
+	s.releaseAttachments(ctx, deleted)
+	s.related.Remove(id.Hex())
+	s.publish(ctx, webhook.BlogDeleted, deleted)
 
 	return &blogpb.DeleteBlogResponse{
 		BlogId: id.Hex(),
 	}, nil
 }
 
-func (*server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
-	fmt.Println("Streaming blog data")
-	cur, err := collection.Find(context.Background(), primitive.D{{}})
+func (s *server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
+	logging.FromContext(stream.Context()).Debug("streaming blogs")
+	ctx := stream.Context()
+	err := s.store.List(ctx, func(data *BlogItem) error {
+		return stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlog(data)})
+	})
 	if err != nil {
-		return status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Unexpected error while quering db %v\n", err),
-		)
-	}
-	defer cur.Close(context.Background())
-	for cur.Next(context.Background()) {
-		data := &BlogItem{}
-		e := cur.Decode(data)
-		if e != nil {
-			return status.Errorf(
-				codes.Internal,
-				fmt.Sprintf("Error while decoding data from db %v\n", e),
-			)
+		if _, ok := status.FromError(err); ok {
+			// Send failed, the client has gone away.
+			return err
 		}
-		stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlog(data)})
+		return storeError(ctx, err, "Unexpected error while processing data from db")
+	}
+	return nil
+}
+
+func (s *server) ReactToBlog(ctx context.Context, req *blogpb.ReactToBlogRequest) (*blogpb.ReactToBlogResponse, error) {
+	logging.FromContext(ctx).Debug("reacting to blog", "blog_id", req.GetBlogId(), "reaction", req.GetReaction().String())
+	if err := validation.ValidateReactToBlogRequest(req); err != nil {
+		return nil, err
+	}
+	id := validation.ObjectID(req.GetBlogId())
+	counts, changed, err := s.store.React(ctx, id, req.GetUserId(), req.GetReaction().String())
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to react to blog")
 	}
+	return &blogpb.ReactToBlogResponse{
+		Reactions: reactionCounts(counts),
+		Changed:   changed,
+	}, nil
+}
 
-	if err := cur.Err(); err != nil {
-		return status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Unexpected error while processing data from db %v\n", err),
-		)
+func (s *server) RemoveReaction(ctx context.Context, req *blogpb.RemoveReactionRequest) (*blogpb.RemoveReactionResponse, error) {
+	logging.FromContext(ctx).Debug("removing reaction", "blog_id", req.GetBlogId(), "reaction", req.GetReaction().String())
+	if err := validation.ValidateRemoveReactionRequest(req); err != nil {
+		return nil, err
+	}
+	id := validation.ObjectID(req.GetBlogId())
+	counts, changed, err := s.store.Unreact(ctx, id, req.GetUserId(), req.GetReaction().String())
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to remove reaction")
 	}
+	return &blogpb.RemoveReactionResponse{
+		Reactions: reactionCounts(counts),
+		Changed:   changed,
+	}, nil
+}
 
+func (s *server) ListReactions(req *blogpb.ListReactionsRequest, stream blogpb.BlogService_ListReactionsServer) error {
+	ctx := stream.Context()
+	logging.FromContext(ctx).Debug("streaming reactions", "blog_id", req.GetBlogId())
+	if err := validation.ValidateListReactionsRequest(req); err != nil {
+		return err
+	}
+	id := validation.ObjectID(req.GetBlogId())
+	// Reactions of a blog that does not exist are NotFound rather than an
+	// empty stream.
+	if _, err := s.store.Get(ctx, id); err != nil {
+		return storeError(ctx, err, "Failed to list reactions")
+	}
+	kind := ""
+	if req.GetReaction() != blogpb.Reaction_REACTION_UNSPECIFIED {
+		kind = req.GetReaction().String()
+	}
+	err := s.store.ListReactions(ctx, id, kind, func(r *ReactionItem) error {
+		return stream.Send(&blogpb.ListReactionsResponse{
+			UserId:    r.UserID,
+			Reaction:  blogpb.Reaction(blogpb.Reaction_value[r.Reaction]),
+			ReactedAt: timestamppb.New(r.ReactedAt),
+		})
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			// Send failed, the client has gone away.
+			return err
+		}
+		return storeError(ctx, err, "Unexpected error while processing data from db")
+	}
 	return nil
 }
 
+// Close stops the background work of s, writing the views it buffered.
+func (s *server) Close(ctx context.Context) error {
+	// The relay hands events to the dispatcher, so it stops first.
+	if err := s.relay.Close(ctx); err != nil {
+		return err
+	}
+	if err := s.dispatcher.Close(ctx); err != nil {
+		return err
+	}
+	if err := s.related.Close(ctx); err != nil {
+		return err
+	}
+	if s.views == nil {
+		return nil
+	}
+	return s.views.Close(ctx)
+}
+
+// storeError converts an error from the blog store into a status error.
+// Running out of time or being cancelled, whether by the client or by the
+// store's own timeout cap, is reported as DeadlineExceeded or Canceled
+// rather than Internal.
+func storeError(ctx context.Context, err error, msg string) error {
+	switch {
+	case err == errBlogNotFound:
+		return status.Errorf(codes.NotFound, "%s, blog not found", msg)
+	case ctx.Err() == context.Canceled, errors.Is(err, context.Canceled):
+		return status.Errorf(codes.Canceled, "%s, request cancelled", msg)
+	case ctx.Err() == context.DeadlineExceeded, errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return status.Errorf(codes.DeadlineExceeded, "%s, deadline exceeded %v", msg, err)
+	}
+	return status.Errorf(codes.Internal, "%s %v", msg, err)
+}
+
 func dataToBlog(data *BlogItem) *blogpb.Blog {
 	return &blogpb.Blog{
-		Id:       data.ID.Hex(),
-		AuthorId: data.AuthorID,
-		Title:    data.Title,
-		Content:  data.Content,
+		Id:          data.ID.Hex(),
+		AuthorId:    data.AuthorID,
+		Title:       data.Title,
+		Content:     data.Content,
+		Slug:        data.Slug,
+		Reactions:   reactionCounts(data.Reactions),
+		Tags:        data.Tags,
+		CreatedAt:   timestamppb.New(data.CreatedAt()),
+		UpdatedAt:   timestamppb.New(data.LastModified()),
+		Attachments: attachmentsToPB(data.Attachments),
+	}
+}
+
+func attachmentsToPB(items []AttachmentItem) []*blogpb.Attachment {
+	var out []*blogpb.Attachment
+	for i := range items {
+		out = append(out, attachmentToPB(&items[i]))
+	}
+	return out
+}
+
+// reactionCounts converts stored counts into the order of the Reaction
+// enum, leaving out reactions nobody has left.
+func reactionCounts(counts map[string]int64) []*blogpb.ReactionCount {
+	var out []*blogpb.ReactionCount
+	for i := int32(1); i < int32(len(blogpb.Reaction_name)); i++ {
+		r := blogpb.Reaction(i)
+		if n := counts[r.String()]; n > 0 {
+			out = append(out, &blogpb.ReactionCount{Reaction: r, Count: n})
+		}
+	}
+	return out
+}
+
+// serverFactory builds the server of the blogs kept in one database. The
+// servers it builds share the blog cache, metrics and background workers.
+type serverFactory struct {
+	cfg     *Config
+	metrics *metrics.MongoMetrics
+	tracer  *tracing.Tracer
+	// cache, thumbnails and webhooks are nil when disabled.
+	cache      *lruBlogCache
+	thumbnails *workerpool.Pool
+	webhooks   *workerpool.Pool
+	// outboxFile is the publisher of the outbox when it publishes to a
+	// file.
+	outboxFile *outbox.File
+}
+
+// newServer returns the server of the blogs in db, those of tenant tenantID
+// on a multi-tenant server.
+func (f *serverFactory) newServer(db *mongo.Database, tenantID string) (*server, error) {
+	mongoStore := newMongoStore(
+		db.Collection(migrations.BlogCollection),
+		db.Collection(migrations.ReactionCollection),
+		f.cfg.MongoTimeouts, f.metrics, f.tracer,
+	)
+	var entries *outbox.MongoStore
+	if f.cfg.Outbox.Enabled {
+		entries = outbox.NewMongoStore(db, f.cfg.MongoTimeouts.Update, f.cfg.Outbox.Retention, f.metrics)
+		mongoStore.outbox = entries
 	}
+	var store blogStore = mongoStore
+	if f.cache != nil {
+		store = newCachedStore(store, f.cache.forTenant(tenantID))
+	}
+	srv := &server{
+		store:           store,
+		attachments:     f.cfg.Attachments,
+		thumbnails:      f.thumbnails,
+		thumbnailConfig: f.cfg.Thumbnails,
+		webhookConfig:   f.cfg.Webhooks,
+		outboxConfig:    f.cfg.Outbox,
+		relatedConfig:   f.cfg.Related,
+		peerKey:         f.cfg.RateLimit.PeerKey(),
+	}
+	if f.cfg.Attachments.Enabled {
+		var err error
+		if srv.blobs, err = f.cfg.Attachments.BlobStore(db, tenantID); err != nil {
+			return nil, err
+		}
+	}
+	if f.cfg.Analytics.Enabled {
+		srv.views = analytics.NewRecorder(db, f.cfg.Analytics.Options(), f.metrics)
+		srv.reports = analytics.NewReports(db, f.cfg.MongoTimeouts.Report, f.metrics)
+	}
+	if f.webhooks != nil {
+		srv.webhooks = webhook.NewMongoStore(db, f.cfg.MongoTimeouts.Update, f.cfg.Webhooks.Retention, f.metrics)
+		srv.dispatcher = webhook.NewDispatcher(srv.webhooks, f.webhooks, f.cfg.Webhooks.Options())
+	}
+	if entries != nil {
+		var pub outbox.Publisher = f.outboxFile
+		if f.cfg.Outbox.Publisher == "webhooks" {
+			pub = webhookPublisher{srv.dispatcher}
+		}
+		srv.relay = outbox.NewRelay(entries, pub, f.cfg.Outbox.Options())
+	}
+	if f.cfg.Related.Enabled {
+		srv.related = related.New(srv.scanBlogs, f.cfg.Related.Options())
+	}
+	return srv, nil
 }
 
-var collection *mongo.Collection
+// migrateTenants brings the database of every tenant up to date, so those
+// created before an upgrade get the migrations it adds.
+func migrateTenants(registry *tenantRegistry, client *mongo.Client, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tenants, err := registry.List(ctx)
+	if err != nil {
+		logging.Fatal("failed to list tenants", "err", err)
+	}
+	for _, t := range tenants {
+		if _, err := migrations.New(client.Database(t.Database), migrations.All).Up(ctx); err != nil {
+			logging.Fatal("failed to migrate tenant database", "tenant_id", t.ID, "err", err)
+		}
+	}
+}
 
 func main() {
 
 	//logs error line number incase of app crash
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	cfg := defaultConfig()
+	config.MustLoad("BLOG", cfg)
+	logger, logLevel, err := logging.Setup(cfg.Logging.Options())
+	if err != nil {
+		log.Fatalf("Failed to set up logging %v", err)
+	}
+
 	//Connect to mongodb
-	fmt.Println("Connecting to Mongodb")
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	logger.Info("connecting to Mongodb", "database", cfg.Mongo.Database)
+	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		logging.Fatal("error while connecting to Mongodb", "err", err)
+	}
+	if err := client.Connect(context.TODO()); err != nil {
+		logging.Fatal("error while connecting to Mongodb", "err", err)
+	}
+	db := client.Database(cfg.Mongo.Database)
+	if cfg.Migrations.Auto {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Migrations.Timeout)
+		applied, err := migrations.New(db, migrations.All).Up(ctx)
+		cancel()
+		if err != nil {
+			logging.Fatal("failed to migrate database", "err", err)
+		}
+		logger.Info("applied migrations", "count", len(applied))
+	}
+	tracer, err := tracing.New(cfg.Tracing.Options("blog_server"))
 	if err != nil {
-		log.Fatalf("Error while connecting to Mongodb %v", err)
+		logging.Fatal("failed to set up tracing", "err", err)
+	}
+	reg := metrics.NewRegistry()
+	var mongoMetrics *metrics.MongoMetrics
+	if cfg.Metrics.Enabled {
+		mongoMetrics = metrics.NewMongoMetrics(reg)
+	}
+	factory := &serverFactory{cfg: cfg, metrics: mongoMetrics, tracer: tracer}
+	if cfg.Cache.Size > 0 {
+		factory.cache = newLRUBlogCache(cfg.Cache.Size, cfg.Cache.TTL)
+		if cfg.Metrics.Enabled {
+			metrics.RegisterCache(reg, "blog", factory.cache.Stats)
+		}
+	}
+	if cfg.Attachments.Enabled && cfg.Thumbnails.Enabled {
+		factory.thumbnails = workerpool.New(cfg.Thumbnails.Workers, cfg.Thumbnails.QueueSize)
+	}
+	if cfg.Webhooks.Enabled {
+		factory.webhooks = workerpool.New(cfg.Webhooks.Workers, cfg.Webhooks.QueueSize)
+	}
+	if cfg.Outbox.Enabled && cfg.Outbox.Publisher == "file" {
+		if factory.outboxFile, err = outbox.OpenFile(cfg.Outbox.File); err != nil {
+			logging.Fatal("failed to open outbox file", "err", err)
+		}
+	}
+	// A single-tenant server keeps its blogs in the main database; a
+	// multi-tenant one routes each call to the server of its tenant.
+	var srv *server
+	var router *tenantRouter
+	var registry *tenantRegistry
+	var blogService blogpb.BlogServiceServer
+	if cfg.Tenancy.Enabled {
+		registry = newTenantRegistry(db.Collection(migrations.TenantCollection), cfg.MongoTimeouts.Find, cfg.Tenancy.CacheTTL)
+		if cfg.Migrations.Auto {
+			migrateTenants(registry, client, cfg.Migrations.Timeout)
+		}
+		router = newTenantRouter(registry, func(t *TenantItem) (*server, error) {
+			return factory.newServer(client.Database(t.Database), t.ID)
+		})
+		if err := router.Start(context.Background()); err != nil {
+			logging.Fatal("failed to start tenant servers", "err", err)
+		}
+		blogService = router
+	} else {
+		if srv, err = factory.newServer(db, ""); err != nil {
+			logging.Fatal("failed to set up blog server", "err", err)
+		}
+		blogService = srv
 	}
-	client.Connect(context.TODO())
-	collection = client.Database("mydb").Collection("blog")
 
-	lis, err := net.Listen("tcp", "localhost:50051")
+	var idempotencyStore idempotency.Store
+	if cfg.Idempotency.Store == "memory" {
+		idempotencyStore = idempotency.NewMemoryStore()
+	} else {
+		mongoStore := idempotency.NewMongoStore(db.Collection("idempotency_keys"))
+		if err := mongoStore.EnsureIndexes(context.TODO()); err != nil {
+			logger.Error("failed to create idempotency key index", "err", err)
+		}
+		idempotencyStore = mongoStore
+	}
+
+	lis, err := net.Listen("tcp", cfg.Server.Address)
 	if err != nil {
-		log.Fatalf("Failed to start listner. %v", err)
+		logging.Fatal("failed to start listener", "err", err)
+	}
+	opts, err := cfg.Server.ServerOptions()
+	if err != nil {
+		logging.Fatal("failed loading ssl certificates", "err", err)
+	}
+	// Tracing wraps every interceptor and metrics come first, so replayed
+	// idempotent calls are traced and counted too.
+	unary := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(logger),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		logging.StreamServerInterceptor(logger),
+	}
+	var serverMetrics *metrics.ServerMetrics
+	if cfg.Metrics.Enabled {
+		serverMetrics = metrics.NewServerMetrics(reg)
+		unary = append(unary, serverMetrics.UnaryServerInterceptor())
+		stream = append(stream, serverMetrics.StreamServerInterceptor())
+	}
+	if cfg.Tenancy.Enabled {
+		resolve := cfg.Tenancy.Resolver()
+		unary = append(unary, tenant.UnaryServerInterceptor(resolve, registry.Authorize, "/blog.BlogService/"))
+		stream = append(stream, tenant.StreamServerInterceptor(resolve, registry.Authorize, "/blog.BlogService/"))
+	}
+	if cfg.RateLimit.Enabled {
+		limiter := ratelimit.New(cfg.RateLimit.Options())
+		unary = append(unary, limiter.UnaryServerInterceptor())
+		stream = append(stream, limiter.StreamServerInterceptor())
+	}
+	unary = append(unary, idempotency.ScopedUnaryServerInterceptor(idempotencyStore, cfg.Idempotency.TTL, cfg.Idempotency.Lease, tenantScope,
+		"/blog.BlogService/CreateBlog",
+		"/blog.BlogService/UpdateBlog",
+		"/blog.BlogService/DeleteBlog",
+	))
+	opts = append(opts, tracer.ServerOption(), grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s := grpc.NewServer(opts...)
+	blogpb.RegisterBlogServiceServer(s, blogService)
+	if cfg.Tenancy.Enabled {
+		blogpb.RegisterBlogAdminServiceServer(s, &tenantAdmin{
+			registry: registry,
+			router:   router,
+			token:    cfg.Tenancy.AdminToken,
+			prefix:   cfg.Tenancy.DatabasePrefix,
+			migrate: func(ctx context.Context, database string) error {
+				ctx, cancel := context.WithTimeout(ctx, cfg.Migrations.Timeout)
+				defer cancel()
+				_, err := migrations.New(client.Database(database), migrations.All).Up(ctx)
+				return err
+			},
+		})
 	}
-	s := grpc.NewServer()
-	blogpb.RegisterBlogServiceServer(s, &server{})
 	reflection.Register(s)
+	if serverMetrics != nil {
+		serverMetrics.InitializeMetrics(s)
+	}
+	checker := healthcheck.Register(s)
+	checker.AddProbe("mongodb", func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	})
+
+	runner := lifecycle.New(s, lis, cfg.Shutdown.DrainTimeout, cfg.Shutdown.CloseTimeout)
+	// Registered first so it is closed last and flushes the spans of
+	// everything shut down before it.
+	runner.OnClose("tracer", tracer.Shutdown)
+	runner.SetHealth(checker)
+	runner.Go(func(ctx context.Context) {
+		checker.Run(ctx, cfg.Health.Interval, cfg.Health.Timeout)
+	})
+	runner.OnClose("Mongodb connection", client.Disconnect)
+	// The worker pools are closed after the servers queuing work on them
+	// and before Mongo.
+	if factory.thumbnails != nil {
+		runner.OnClose("thumbnail workers", factory.thumbnails.Close)
+	}
+	if factory.webhooks != nil {
+		runner.OnClose("webhook workers", factory.webhooks.Close)
+	}
+	if factory.outboxFile != nil {
+		runner.OnClose("outbox file", factory.outboxFile.Close)
+	}
+	// Closed after in-flight calls have drained.
+	if router != nil {
+		runner.OnClose("tenant servers", router.Close)
+	} else {
+		runner.OnClose("blog server", srv.Close)
+	}
+	if cfg.HTTP.Address != "" {
+		mux := http.NewServeMux()
+		checker.RegisterHTTP(mux)
+		logging.RegisterHTTP(mux, logLevel, cfg.Logging.LevelToken)
+		if cfg.Metrics.Enabled {
+			metrics.RegisterHTTP(mux, reg)
+		}
+		if cfg.Gateway.Enabled {
+			caFile := ""
+			if cfg.Server.TLS.Enabled {
+				caFile = cfg.Gateway.CAFile
+			}
+			cc, err := gateway.Dial(config.DialAddress(cfg.Server.Address), caFile)
+			if err != nil {
+				logging.Fatal("failed to connect gateway to blog server", "err", err)
+			}
+			runner.OnClose("gateway connection", func(context.Context) error { return cc.Close() })
+			gateway.New(blogpb.NewBlogServiceClient(cc)).Register(mux)
+		}
+		if cfg.Feeds.Enabled {
+			newFeedHandler(srv.store, cfg.Site, cfg.Feeds.Limit).Register(mux)
+		}
+		if cfg.Sitemap.Enabled {
+			src := sitemap.NewMongoSource(db, cfg.MongoTimeouts.List, mongoMetrics)
+			newSitemapHandler(sitemap.New(src, cfg.Site)).Register(mux)
+		}
+		var handler http.Handler = mux
+		if cfg.GRPCWeb.Enabled {
+			handler = grpcweb.New(s, cfg.GRPCWeb.CORSOptions()).Wrap(mux)
+		}
+		if err := runner.AddHTTPServer(cfg.HTTP.Address, handler); err != nil {
+			logging.Fatal("failed to start HTTP listener", "err", err)
+		}
+	}
 
-	go func() {
-		fmt.Println("Starting blog server...")
-		if err := s.Serve(lis); err != nil {
-			log.Fatalf("Failed to start server. %v", err)
-		}
-	}()
-	// Wait for control C to exit
-	ch := make(chan os.Signal)
-	signal.Notify(ch, os.Interrupt)
-	<-ch
-	fmt.Println("Stopping the server")
-	s.Stop()
-	fmt.Println("Closing listner")
-	lis.Close()
-	fmt.Println("Closing Mongodb connection")
-	client.Disconnect(context.TODO())
-	fmt.Println("Server stopped gracefully.")
+	logger.Info("starting blog server", "address", cfg.Server.Address, "http_address", cfg.HTTP.Address)
+	if err := runner.Run(); err != nil {
+		logging.Fatal("failed to start server", "err", err)
+	}
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/sitemap"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+)
+
+// sitemapHandler serves /sitemap.xml, which is a sitemap index when the
+// blogs do not fit one sitemap, and the pages it lists.
+type sitemapHandler struct {
+	sitemap *sitemap.Sitemap
+}
+
+func newSitemapHandler(s *sitemap.Sitemap) *sitemapHandler {
+	return &sitemapHandler{sitemap: s}
+}
+
+func (h *sitemapHandler) Register(mux *http.ServeMux) {
+	mux.Handle(sitemap.Path, h)
+	mux.Handle("/sitemaps/", h)
+}
+
+func (h *sitemapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !readOnly(w, r) {
+		return
+	}
+	ctx := r.Context()
+	// page 0 stands for the index.
+	page := 0
+	if r.URL.Path != sitemap.Path {
+		n, ok := sitemap.ParsePagePath(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		page = n
+	}
+	pages, err := h.sitemap.Pages(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to count sitemap pages", "err", err)
+		http.Error(w, "failed to read sitemap", http.StatusInternalServerError)
+		return
+	}
+	if page > pages {
+		http.NotFound(w, r)
+		return
+	}
+	if page == 0 && pages == 1 {
+		page = 1
+	}
+
+	var body bytes.Buffer
+	var modified time.Time
+	if page == 0 {
+		err = h.sitemap.WriteIndex(&body, pages)
+	} else {
+		modified, err = h.sitemap.WritePage(ctx, &body, page)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to write sitemap", "path", r.URL.Path, "err", err)
+		http.Error(w, "failed to read sitemap", http.StatusInternalServerError)
+		return
+	}
+	serveDocument(w, r, sitemap.ContentType, modified, body.Bytes())
+}
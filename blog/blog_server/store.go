@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/outbox"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/slug"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/webhook"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type BlogItem struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	AuthorID string             `bson:"author_id"`
+	Title    string             `bson:"title"`
+	Content  string             `bson:"content"`
+	Slug     string             `bson:"slug,omitempty"`
+	// Reactions counts the reactions left on the blog by reaction name.
+	Reactions map[string]int64 `bson:"reactions,omitempty"`
+	Tags      []string         `bson:"tags,omitempty"`
+	// UpdatedAt is when the blog was last created or updated. Blogs stored
+	// before it was introduced do not have it.
+	UpdatedAt time.Time `bson:"updated_at,omitempty"`
+	// Attachments lists the files uploaded to the blog, oldest first.
+	Attachments []AttachmentItem `bson:"attachments,omitempty"`
+}
+
+// AttachmentItem references a file uploaded to a blog. The content is kept
+// in the blob store under its SHA-256, so blogs sharing a file share one
+// copy of it.
+type AttachmentItem struct {
+	ID          primitive.ObjectID `bson:"id"`
+	Filename    string             `bson:"filename"`
+	ContentType string             `bson:"content_type"`
+	Size        int64              `bson:"size"`
+	SHA256      string             `bson:"sha256"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	Thumbnails  []ThumbnailItem    `bson:"thumbnails,omitempty"`
+}
+
+// ThumbnailItem references a scaled down copy of an image attachment, kept
+// in the blob store like the attachment.
+type ThumbnailItem struct {
+	Width       int    `bson:"width"`
+	Height      int    `bson:"height"`
+	ContentType string `bson:"content_type"`
+	Size        int64  `bson:"size"`
+	SHA256      string `bson:"sha256"`
+}
+
+// CreatedAt is when the blog was created, taken from its id.
+func (b *BlogItem) CreatedAt() time.Time {
+	return b.ID.Timestamp()
+}
+
+// LastModified is when the blog last changed, as far as is known.
+func (b *BlogItem) LastModified() time.Time {
+	if b.UpdatedAt.IsZero() {
+		return b.CreatedAt()
+	}
+	return b.UpdatedAt
+}
+
+// ReactionItem records that a user left a reaction on a blog.
+type ReactionItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID    primitive.ObjectID `bson:"blog_id"`
+	UserID    string             `bson:"user_id"`
+	Reaction  string             `bson:"reaction"`
+	ReactedAt time.Time          `bson:"reacted_at"`
+}
+
+var (
+	errBlogNotFound       = errors.New("blog not found")
+	errTooManyAttachments = errors.New("too many attachments")
+)
+
+// blogStore persists blogs. Every method honours the deadline and
+// cancellation of ctx.
+type blogStore interface {
+	Create(ctx context.Context, item *BlogItem) (primitive.ObjectID, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*BlogItem, error)
+	// Update overwrites the given fields of an existing blog, named as in
+	// validation.UpdatableBlogFields, with those of item, or all of them
+	// when fields is empty. It returns the stored result.
+	Update(ctx context.Context, item *BlogItem, fields []string) (*BlogItem, error)
+	// Delete removes a blog and returns it as it was.
+	Delete(ctx context.Context, id primitive.ObjectID) (*BlogItem, error)
+	// List calls fn for every blog until fn returns an error or the blogs
+	// are exhausted.
+	List(ctx context.Context, fn func(*BlogItem) error) error
+	// Scan is List bounded by ctx alone, for background work reading
+	// more blogs than a call may.
+	Scan(ctx context.Context, fn func(*BlogItem) error) error
+	// Recent returns the limit most recently created blogs, newest first,
+	// restricted to those by authorID and those tagged tag unless they are
+	// empty.
+	Recent(ctx context.Context, authorID, tag string, limit int) ([]*BlogItem, error)
+	// React records that userID left reaction on blog id and returns the
+	// blog's reaction counts. changed is false when the user had already
+	// left that reaction.
+	React(ctx context.Context, id primitive.ObjectID, userID, reaction string) (counts map[string]int64, changed bool, err error)
+	// Unreact removes a reaction left by React. changed is false when there
+	// was none.
+	Unreact(ctx context.Context, id primitive.ObjectID, userID, reaction string) (counts map[string]int64, changed bool, err error)
+	// AddAttachment adds a to the attachments of blog id unless the blog
+	// already has max of them, in which case it fails with
+	// errTooManyAttachments.
+	AddAttachment(ctx context.Context, id primitive.ObjectID, a *AttachmentItem, max int) error
+	// SetThumbnails records the thumbnails of an attachment. It fails with
+	// errBlogNotFound when the blog or the attachment no longer exists.
+	SetThumbnails(ctx context.Context, id, attachmentID primitive.ObjectID, thumbs []ThumbnailItem) error
+	// AttachmentReferenced reports whether any blog has an attachment or
+	// thumbnail with the given content hash.
+	AttachmentReferenced(ctx context.Context, sha256 string) (bool, error)
+	// ListReactions calls fn for every reaction left on blog id, oldest
+	// first, restricted to one kind unless reaction is empty.
+	ListReactions(ctx context.Context, id primitive.ObjectID, reaction string, fn func(*ReactionItem) error) error
+}
+
+// mongoStore is the blogStore backed by the blog and reaction collections.
+// Each operation runs under the caller's context further capped by its
+// configured timeout, is traced as a client span and has its latency and
+// failures recorded in metrics. Both metrics and tracer may be nil.
+//
+// With an outbox, creating, updating and deleting a blog also records the
+// event in it, in the same transaction. Transactions need a replica set.
+type mongoStore struct {
+	collection *mongo.Collection
+	reactions  *mongo.Collection
+	timeouts   MongoTimeouts
+	metrics    *metrics.MongoMetrics
+	tracer     *tracing.Tracer
+	outbox     *outbox.MongoStore
+}
+
+func newMongoStore(collection, reactions *mongo.Collection, timeouts MongoTimeouts, m *metrics.MongoMetrics, t *tracing.Tracer) *mongoStore {
+	return &mongoStore{collection: collection, reactions: reactions, timeouts: timeouts, metrics: m, tracer: t}
+}
+
+// observe starts timing and tracing operation op on the blog collection.
+// The returned function must be called with the operation's result.
+func (m *mongoStore) observe(ctx context.Context, op string) (context.Context, func(error)) {
+	return m.observeOn(ctx, m.collection, op)
+}
+
+// observeOn is observe for operations on collection c.
+func (m *mongoStore) observeOn(ctx context.Context, c *mongo.Collection, op string) (context.Context, func(error)) {
+	coll := c.Name()
+	ctx, span := m.tracer.Start(ctx, op+" "+c.Database().Name()+"."+coll, trace.SpanKindClient,
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.name", c.Database().Name()),
+		attribute.String("db.mongodb.collection", coll),
+		attribute.String("db.operation", op),
+	)
+	done := m.metrics.Start(coll, op)
+	return ctx, func(err error) {
+		done(err)
+		if err != mongo.ErrNoDocuments {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}
+}
+
+func (m *mongoStore) Create(ctx context.Context, item *BlogItem) (primitive.ObjectID, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Insert)
+	defer cancel()
+	item.ID = primitive.NewObjectID()
+	item.Slug = slug.Make(item.Title, item.ID)
+	item.UpdatedAt = now()
+	err := m.transact(ctx, func(ctx context.Context) error {
+		ctx, done := m.observe(ctx, "insert")
+		_, err := m.collection.InsertOne(ctx, item)
+		done(err)
+		if err != nil {
+			return err
+		}
+		return m.record(ctx, webhook.BlogCreated, item)
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return item.ID, nil
+}
+
+func (m *mongoStore) Get(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Find)
+	defer cancel()
+	data := &BlogItem{}
+	ctx, done := m.observe(ctx, "find")
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(data)
+	done(err)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errBlogNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) Update(ctx context.Context, item *BlogItem, fields []string) (*BlogItem, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Update)
+	defer cancel()
+	values := bson.M{
+		"author_id": item.AuthorID,
+		"title":     item.Title,
+		"content":   item.Content,
+		"tags":      item.Tags,
+	}
+	set := bson.M{"updated_at": now()}
+	if len(fields) == 0 {
+		fields = validation.UpdatableBlogFields
+	}
+	for _, f := range fields {
+		set[f] = values[f]
+	}
+	var updated *BlogItem
+	err := m.transact(ctx, func(ctx context.Context) error {
+		updated = &BlogItem{}
+		ctx, done := m.observe(ctx, "update")
+		err := m.collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": item.ID},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(updated)
+		done(err)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errBlogNotFound
+			}
+			return err
+		}
+		return m.record(ctx, webhook.BlogUpdated, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (m *mongoStore) Delete(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Delete)
+	defer cancel()
+	var deleted *BlogItem
+	err := m.transact(ctx, func(ctx context.Context) error {
+		deleted = &BlogItem{}
+		ctx, done := m.observe(ctx, "delete")
+		err := m.collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(deleted)
+		done(err)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errBlogNotFound
+			}
+			return err
+		}
+		return m.record(ctx, webhook.BlogDeleted, deleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The blog is gone either way, so a failure here only leaves reactions
+	// nobody can list behind.
+	ctx, done := m.observeOn(ctx, m.reactions, "delete")
+	_, err = m.reactions.DeleteMany(ctx, bson.M{"blog_id": id})
+	done(err)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to delete reactions of deleted blog", "blog_id", id.Hex(), "err", err)
+	}
+	return deleted, nil
+}
+
+// transact runs fn in a transaction when the store has an outbox, so the
+// entry fn records commits or aborts with the change it describes. fn may
+// be run more than once should the transaction hit a transient error.
+func (m *mongoStore) transact(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.outbox == nil {
+		return fn(ctx)
+	}
+	sess, err := m.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// record adds an event of eventType about blog to the outbox, if the store
+// has one. It must run within transact.
+func (m *mongoStore) record(ctx context.Context, eventType string, blog *BlogItem) error {
+	if m.outbox == nil {
+		return nil
+	}
+	payload, err := marshalBlog(blog)
+	if err != nil {
+		return err
+	}
+	return m.outbox.Add(ctx, &outbox.Entry{
+		ID:        primitive.NewObjectID(),
+		Type:      eventType,
+		Key:       blog.ID.Hex(),
+		Payload:   payload,
+		CreatedAt: now(),
+	})
+}
+
+func (m *mongoStore) AddAttachment(ctx context.Context, id primitive.ObjectID, a *AttachmentItem, max int) error {
+	ctx, cancel := withCap(ctx, m.timeouts.Update)
+	defer cancel()
+	ctx, done := m.observe(ctx, "update")
+	// The push only matches while the blog has fewer than max attachments,
+	// so concurrent uploads cannot exceed it.
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": id, fmt.Sprintf("attachments.%d", max-1): bson.M{"$exists": false}},
+		bson.M{
+			"$push": bson.M{"attachments": a},
+			"$set":  bson.M{"updated_at": now()},
+		},
+	)
+	done(err)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := m.Get(ctx, id); err != nil {
+			return err
+		}
+		return errTooManyAttachments
+	}
+	return nil
+}
+
+func (m *mongoStore) SetThumbnails(ctx context.Context, id, attachmentID primitive.ObjectID, thumbs []ThumbnailItem) error {
+	ctx, cancel := withCap(ctx, m.timeouts.Update)
+	defer cancel()
+	ctx, done := m.observe(ctx, "update")
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "attachments.id": attachmentID},
+		bson.M{"$set": bson.M{"attachments.$.thumbnails": thumbs}},
+	)
+	done(err)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errBlogNotFound
+	}
+	return nil
+}
+
+func (m *mongoStore) AttachmentReferenced(ctx context.Context, sha256 string) (bool, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Find)
+	defer cancel()
+	ctx, done := m.observe(ctx, "count")
+	n, err := m.collection.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"attachments.sha256": sha256},
+		bson.M{"attachments.thumbnails.sha256": sha256},
+	}}, options.Count().SetLimit(1))
+	done(err)
+	return n > 0, err
+}
+
+func (m *mongoStore) List(ctx context.Context, fn func(*BlogItem) error) error {
+	ctx, cancel := withCap(ctx, m.timeouts.List)
+	defer cancel()
+	return m.Scan(ctx, fn)
+}
+
+func (m *mongoStore) Scan(ctx context.Context, fn func(*BlogItem) error) (err error) {
+	// The whole scan is timed, including the time fn takes to send each blog.
+	ctx, done := m.observe(ctx, "list")
+	defer func() { done(err) }()
+	cur, err := m.collection.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	// Close with a fresh context so the server side cursor is released even
+	// when ctx has already been cancelled.
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		data := &BlogItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) Recent(ctx context.Context, authorID, tag string, limit int) ([]*BlogItem, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.List)
+	defer cancel()
+	filter := bson.M{}
+	if authorID != "" {
+		filter["author_id"] = authorID
+	}
+	if tag != "" {
+		filter["tags"] = tag
+	}
+	ctx, done := m.observe(ctx, "find")
+	var items []*BlogItem
+	cur, err := m.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err == nil {
+		err = cur.All(ctx, &items)
+	}
+	done(err)
+	return items, err
+}
+
+// The reaction documents and the counters on the blog are written
+// separately, as standalone Mongo deployments have no transactions. The
+// unique index on the reactions decides which of many concurrent clicks
+// counts, and the counter is then moved with an atomic $inc. Should the
+// counter update fail, the reaction change is undone so the call can be
+// retried without the two drifting apart.
+
+func (m *mongoStore) React(ctx context.Context, id primitive.ObjectID, userID, reaction string) (map[string]int64, bool, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Update)
+	defer cancel()
+	item := &ReactionItem{
+		ID:        primitive.NewObjectID(),
+		BlogID:    id,
+		UserID:    userID,
+		Reaction:  reaction,
+		ReactedAt: time.Now().UTC(),
+	}
+	ictx, done := m.observeOn(ctx, m.reactions, "insert")
+	_, err := m.reactions.InsertOne(ictx, item)
+	done(err)
+	if mongo.IsDuplicateKeyError(err) {
+		counts, err := m.reactionCounts(ctx, id)
+		return counts, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	counts, err := m.addReaction(ctx, id, reaction, 1)
+	if err != nil {
+		m.undo(ctx, "delete", func(ctx context.Context) error {
+			_, err := m.reactions.DeleteOne(ctx, bson.M{"_id": item.ID})
+			return err
+		})
+		return nil, false, err
+	}
+	return counts, true, nil
+}
+
+func (m *mongoStore) Unreact(ctx context.Context, id primitive.ObjectID, userID, reaction string) (map[string]int64, bool, error) {
+	ctx, cancel := withCap(ctx, m.timeouts.Update)
+	defer cancel()
+	removed := &ReactionItem{}
+	dctx, done := m.observeOn(ctx, m.reactions, "delete")
+	err := m.reactions.FindOneAndDelete(dctx, bson.M{
+		"blog_id":  id,
+		"user_id":  userID,
+		"reaction": reaction,
+	}).Decode(removed)
+	done(err)
+	if err == mongo.ErrNoDocuments {
+		counts, err := m.reactionCounts(ctx, id)
+		return counts, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	counts, err := m.addReaction(ctx, id, reaction, -1)
+	if err != nil && err != errBlogNotFound {
+		m.undo(ctx, "insert", func(ctx context.Context) error {
+			_, err := m.reactions.InsertOne(ctx, removed)
+			return err
+		})
+		return nil, false, err
+	}
+	return counts, true, err
+}
+
+// addReaction atomically moves the counter of reaction on blog id by delta
+// and returns the updated counts.
+func (m *mongoStore) addReaction(ctx context.Context, id primitive.ObjectID, reaction string, delta int64) (map[string]int64, error) {
+	var doc struct {
+		Reactions map[string]int64 `bson:"reactions"`
+	}
+	ctx, done := m.observe(ctx, "update")
+	err := m.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"reactions." + reaction: delta}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"reactions": 1}),
+	).Decode(&doc)
+	done(err)
+	if err == mongo.ErrNoDocuments {
+		return nil, errBlogNotFound
+	}
+	return doc.Reactions, err
+}
+
+func (m *mongoStore) reactionCounts(ctx context.Context, id primitive.ObjectID) (map[string]int64, error) {
+	var doc struct {
+		Reactions map[string]int64 `bson:"reactions"`
+	}
+	ctx, done := m.observe(ctx, "find")
+	err := m.collection.FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"reactions": 1}),
+	).Decode(&doc)
+	done(err)
+	if err == mongo.ErrNoDocuments {
+		return nil, errBlogNotFound
+	}
+	return doc.Reactions, err
+}
+
+// undo runs a compensating write on the reaction collection. It gets its
+// own time budget since the operation it undoes may have failed by running
+// out of the caller's.
+func (m *mongoStore) undo(ctx context.Context, op string, fn func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.timeouts.Update)
+	defer cancel()
+	ctx, done := m.observeOn(ctx, m.reactions, op)
+	err := fn(ctx)
+	done(err)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to undo reaction change, counts may be off by one", "op", op, "err", err)
+	}
+}
+
+func (m *mongoStore) ListReactions(ctx context.Context, id primitive.ObjectID, reaction string, fn func(*ReactionItem) error) (err error) {
+	ctx, cancel := withCap(ctx, m.timeouts.List)
+	defer cancel()
+	ctx, done := m.observeOn(ctx, m.reactions, "list")
+	defer func() { done(err) }()
+	filter := bson.M{"blog_id": id}
+	if reaction != "" {
+		filter["reaction"] = reaction
+	}
+	cur, err := m.reactions.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "reacted_at", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		item := &ReactionItem{}
+		if err := cur.Decode(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// now returns the current time at the millisecond precision Mongo stores,
+// so a blog compares equal before and after a round trip.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// withCap derives a context that ends at the earlier of ctx's own deadline
+// and max from now. A zero max leaves ctx unchanged.
+func withCap(ctx context.Context, max time.Duration) (context.Context, context.CancelFunc) {
+	if max <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, max)
+}
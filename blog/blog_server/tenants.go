@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/cache"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminTokenMetadata is the metadata key BlogAdminService calls carry the
+// admin token in.
+const adminTokenMetadata = "x-admin-token"
+
+// tenantCacheSize bounds the tenants the registry keeps in memory.
+const tenantCacheSize = 1024
+
+var (
+	errTenantNotFound = errors.New("tenant not found")
+	errTenantExists   = errors.New("tenant already exists")
+)
+
+// TenantItem is a tenant as stored in the tenants collection.
+type TenantItem struct {
+	ID        string    `bson:"_id"`
+	Database  string    `bson:"database"`
+	Suspended bool      `bson:"suspended"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// tenantRegistry keeps the tenants of a multi-tenant server. Tenants are
+// cached for a short while, so a tenant suspended through another replica
+// is refused here once its entry expires.
+type tenantRegistry struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+	cache      *cache.LRU
+}
+
+func newTenantRegistry(c *mongo.Collection, timeout, ttl time.Duration) *tenantRegistry {
+	return &tenantRegistry{collection: c, timeout: timeout, cache: cache.NewLRU(tenantCacheSize, ttl)}
+}
+
+// Get returns tenant id or errTenantNotFound.
+func (r *tenantRegistry) Get(ctx context.Context, id string) (*TenantItem, error) {
+	if v, ok := r.cache.Get(id); ok {
+		return v.(*TenantItem), nil
+	}
+	ctx, cancel := withCap(ctx, r.timeout)
+	defer cancel()
+	item := &TenantItem{}
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(item)
+	if err == mongo.ErrNoDocuments {
+		return nil, errTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Add(id, item)
+	return item, nil
+}
+
+// Create stores a new tenant, failing with errTenantExists when its id is
+// taken.
+func (r *tenantRegistry) Create(ctx context.Context, item *TenantItem) error {
+	ctx, cancel := withCap(ctx, r.timeout)
+	defer cancel()
+	_, err := r.collection.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return errTenantExists
+	}
+	return err
+}
+
+// List returns every tenant ordered by id.
+func (r *tenantRegistry) List(ctx context.Context) ([]*TenantItem, error) {
+	ctx, cancel := withCap(ctx, r.timeout)
+	defer cancel()
+	cur, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var items []*TenantItem
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// SetSuspended suspends or resumes tenant id and returns it.
+func (r *tenantRegistry) SetSuspended(ctx context.Context, id string, suspended bool) (*TenantItem, error) {
+	ctx, cancel := withCap(ctx, r.timeout)
+	defer cancel()
+	item := &TenantItem{}
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"suspended": suspended, "updated_at": now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(item)
+	r.cache.Remove(id)
+	if err == mongo.ErrNoDocuments {
+		return nil, errTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Authorize is the tenant.Authorizer letting calls through for tenants that
+// exist and are not suspended.
+func (r *tenantRegistry) Authorize(ctx context.Context, id string) error {
+	item, err := r.Get(ctx, id)
+	switch {
+	case err == errTenantNotFound:
+		return status.Errorf(codes.PermissionDenied, "Unknown tenant %q", id)
+	case err != nil:
+		return storeError(ctx, err, "Failed to look up tenant")
+	case item.Suspended:
+		return status.Errorf(codes.PermissionDenied, "Tenant %q is suspended", id)
+	}
+	return nil
+}
+
+// tenantRouter serves BlogService for every tenant, handing each call to a
+// server of the tenant it is made for. Each tenant's server reads and
+// writes only the tenant's database and blob store, so no call can reach
+// the blogs of another tenant.
+//
+// The servers run background work, such as relaying the outbox and keeping
+// the related index, that must not wait for a tenant's first call, so they
+// are built for every active tenant by Start and as tenants are created or
+// resumed. Tenants created through another replica get theirs on their
+// first call here.
+type tenantRouter struct {
+	registry  *tenantRegistry
+	newServer func(t *TenantItem) (*server, error)
+
+	mu      sync.Mutex
+	servers map[string]*server
+}
+
+func newTenantRouter(registry *tenantRegistry, newServer func(t *TenantItem) (*server, error)) *tenantRouter {
+	return &tenantRouter{registry: registry, newServer: newServer, servers: make(map[string]*server)}
+}
+
+// server returns the server of the tenant the call in ctx is made for,
+// which the tenant interceptor has already authorized.
+func (r *tenantRouter) server(ctx context.Context) (*server, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Call names no tenant")
+	}
+	r.mu.Lock()
+	s, ok := r.servers[id]
+	r.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	t, err := r.registry.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to look up tenant")
+	}
+	if s, err = r.Add(t); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to set up tenant %v", err)
+	}
+	return s, nil
+}
+
+// Start builds the servers of every tenant that is not suspended.
+func (r *tenantRouter) Start(ctx context.Context) error {
+	tenants, err := r.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tenants {
+		if t.Suspended {
+			continue
+		}
+		if _, err := r.Add(t); err != nil {
+			return fmt.Errorf("tenant %s: %v", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// Add returns the server of tenant t, building it unless it exists.
+func (r *tenantRouter) Add(t *TenantItem) (*server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.servers[t.ID]; ok {
+		return s, nil
+	}
+	s, err := r.newServer(t)
+	if err != nil {
+		return nil, err
+	}
+	r.servers[t.ID] = s
+	return s, nil
+}
+
+// Close stops the background work of the servers of every tenant.
+func (r *tenantRouter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for _, s := range r.servers {
+		if err := s.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (r *tenantRouter) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateBlog(ctx, req)
+}
+
+func (r *tenantRouter) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ReadBlog(ctx, req)
+}
+
+func (r *tenantRouter) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.UpdateBlog(ctx, req)
+}
+
+func (r *tenantRouter) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.DeleteBlog(ctx, req)
+}
+
+func (r *tenantRouter) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
+	s, err := r.server(stream.Context())
+	if err != nil {
+		return err
+	}
+	return s.ListBlog(req, stream)
+}
+
+func (r *tenantRouter) ReactToBlog(ctx context.Context, req *blogpb.ReactToBlogRequest) (*blogpb.ReactToBlogResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ReactToBlog(ctx, req)
+}
+
+func (r *tenantRouter) RemoveReaction(ctx context.Context, req *blogpb.RemoveReactionRequest) (*blogpb.RemoveReactionResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoveReaction(ctx, req)
+}
+
+func (r *tenantRouter) ListReactions(req *blogpb.ListReactionsRequest, stream blogpb.BlogService_ListReactionsServer) error {
+	s, err := r.server(stream.Context())
+	if err != nil {
+		return err
+	}
+	return s.ListReactions(req, stream)
+}
+
+func (r *tenantRouter) GetBlogAnalytics(ctx context.Context, req *blogpb.GetBlogAnalyticsRequest) (*blogpb.GetBlogAnalyticsResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBlogAnalytics(ctx, req)
+}
+
+func (r *tenantRouter) UploadAttachment(stream blogpb.BlogService_UploadAttachmentServer) error {
+	s, err := r.server(stream.Context())
+	if err != nil {
+		return err
+	}
+	return s.UploadAttachment(stream)
+}
+
+func (r *tenantRouter) DownloadAttachment(req *blogpb.DownloadAttachmentRequest, stream blogpb.BlogService_DownloadAttachmentServer) error {
+	s, err := r.server(stream.Context())
+	if err != nil {
+		return err
+	}
+	return s.DownloadAttachment(req, stream)
+}
+
+func (r *tenantRouter) RegisterWebhook(ctx context.Context, req *blogpb.RegisterWebhookRequest) (*blogpb.RegisterWebhookResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.RegisterWebhook(ctx, req)
+}
+
+func (r *tenantRouter) ListWebhooks(ctx context.Context, req *blogpb.ListWebhooksRequest) (*blogpb.ListWebhooksResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListWebhooks(ctx, req)
+}
+
+func (r *tenantRouter) DeleteWebhook(ctx context.Context, req *blogpb.DeleteWebhookRequest) (*blogpb.DeleteWebhookResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.DeleteWebhook(ctx, req)
+}
+
+func (r *tenantRouter) GetRelatedBlogs(ctx context.Context, req *blogpb.GetRelatedBlogsRequest) (*blogpb.GetRelatedBlogsResponse, error) {
+	s, err := r.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRelatedBlogs(ctx, req)
+}
+
+// tenantAdmin serves BlogAdminService.
+type tenantAdmin struct {
+	registry *tenantRegistry
+	// router gets the servers of tenants created or resumed.
+	router *tenantRouter
+	token  string
+	// prefix is prepended to tenant ids to name their databases.
+	prefix string
+	// migrate brings the named database up to date.
+	migrate func(ctx context.Context, database string) error
+}
+
+// authorize checks the admin token of the call in ctx.
+func (a *tenantAdmin) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get(adminTokenMetadata)
+	if len(vals) == 0 || vals[0] == "" {
+		return status.Errorf(codes.Unauthenticated, "Call carries no %s", adminTokenMetadata)
+	}
+	if subtle.ConstantTimeCompare([]byte(vals[0]), []byte(a.token)) != 1 {
+		return status.Errorf(codes.PermissionDenied, "Invalid %s", adminTokenMetadata)
+	}
+	return nil
+}
+
+// CreateTenant sets up the tenant's database before recording the tenant,
+// so calls for a tenant never find its database without indexes.
+func (a *tenantAdmin) CreateTenant(ctx context.Context, req *blogpb.CreateTenantRequest) (*blogpb.CreateTenantResponse, error) {
+	logging.FromContext(ctx).Info("creating tenant", "tenant_id", req.GetTenantId())
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateCreateTenantRequest(req); err != nil {
+		return nil, err
+	}
+	t := now()
+	item := &TenantItem{
+		ID:        req.GetTenantId(),
+		Database:  a.prefix + req.GetTenantId(),
+		CreatedAt: t,
+		UpdatedAt: t,
+	}
+	if err := a.migrate(ctx, item.Database); err != nil {
+		return nil, storeError(ctx, err, "Failed to set up tenant database")
+	}
+	if err := a.registry.Create(ctx, item); err != nil {
+		if err == errTenantExists {
+			return nil, status.Errorf(codes.AlreadyExists, "Tenant %q already exists", item.ID)
+		}
+		return nil, storeError(ctx, err, "Failed to create tenant")
+	}
+	a.start(ctx, item)
+	return &blogpb.CreateTenantResponse{Tenant: tenantToPB(item)}, nil
+}
+
+func (a *tenantAdmin) ListTenants(ctx context.Context, req *blogpb.ListTenantsRequest) (*blogpb.ListTenantsResponse, error) {
+	logging.FromContext(ctx).Debug("listing tenants")
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	items, err := a.registry.List(ctx)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to list tenants")
+	}
+	res := &blogpb.ListTenantsResponse{}
+	for _, item := range items {
+		res.Tenants = append(res.Tenants, tenantToPB(item))
+	}
+	return res, nil
+}
+
+func (a *tenantAdmin) SuspendTenant(ctx context.Context, req *blogpb.SuspendTenantRequest) (*blogpb.SuspendTenantResponse, error) {
+	logging.FromContext(ctx).Info("suspending tenant", "tenant_id", req.GetTenantId())
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateSuspendTenantRequest(req); err != nil {
+		return nil, err
+	}
+	item, err := a.setSuspended(ctx, req.GetTenantId(), true)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.SuspendTenantResponse{Tenant: tenantToPB(item)}, nil
+}
+
+func (a *tenantAdmin) ResumeTenant(ctx context.Context, req *blogpb.ResumeTenantRequest) (*blogpb.ResumeTenantResponse, error) {
+	logging.FromContext(ctx).Info("resuming tenant", "tenant_id", req.GetTenantId())
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateResumeTenantRequest(req); err != nil {
+		return nil, err
+	}
+	item, err := a.setSuspended(ctx, req.GetTenantId(), false)
+	if err != nil {
+		return nil, err
+	}
+	a.start(ctx, item)
+	return &blogpb.ResumeTenantResponse{Tenant: tenantToPB(item)}, nil
+}
+
+// start builds the server of tenant t. The tenant is set up either way, and
+// a failure is retried on its first call.
+func (a *tenantAdmin) start(ctx context.Context, t *TenantItem) {
+	if _, err := a.router.Add(t); err != nil {
+		logging.FromContext(ctx).Warn("failed to start tenant server", "tenant_id", t.ID, "err", err)
+	}
+}
+
+func (a *tenantAdmin) setSuspended(ctx context.Context, id string, suspended bool) (*TenantItem, error) {
+	item, err := a.registry.SetSuspended(ctx, id, suspended)
+	if err == errTenantNotFound {
+		return nil, status.Errorf(codes.NotFound, "Tenant %q not found", id)
+	}
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to update tenant")
+	}
+	return item, nil
+}
+
+// tenantScope keys idempotency records by tenant, so one tenant can never
+// have a response stored for another replayed to it.
+func tenantScope(ctx context.Context) string {
+	id, _ := tenant.FromContext(ctx)
+	return id
+}
+
+func tenantToPB(t *TenantItem) *blogpb.Tenant {
+	return &blogpb.Tenant{
+		Id:        t.ID,
+		Database:  t.Database,
+		Suspended: t.Suspended,
+		CreatedAt: timestamppb.New(t.CreatedAt),
+		UpdatedAt: timestamppb.New(t.UpdatedAt),
+	}
+}
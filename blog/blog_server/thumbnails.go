@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/thumbnail"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// enqueueThumbnails queues generating the thumbnails of a new image
+// attachment. Uploads never wait for it; when the queue is full the
+// attachment simply has no thumbnails.
+func (s *server) enqueueThumbnails(ctx context.Context, blogID primitive.ObjectID, a AttachmentItem) {
+	if s.thumbnails == nil {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(a.ContentType)
+	if !thumbnail.Supported(mediaType) {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	queued := s.thumbnails.Submit(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, s.thumbnailConfig.Timeout)
+		defer cancel()
+		if err := s.generateThumbnails(ctx, blogID, a); err != nil {
+			logger.Warn("failed to generate thumbnails", "blog_id", blogID.Hex(), "attachment_id", a.ID.Hex(), "err", err)
+		}
+	})
+	if !queued {
+		logger.Warn("thumbnail queue full, skipping attachment", "blog_id", blogID.Hex(), "attachment_id", a.ID.Hex())
+	}
+}
+
+// generateThumbnails scales the attachment down to each configured width,
+// stores the thumbnails in the blob store like attachments and records them
+// on the attachment.
+func (s *server) generateThumbnails(ctx context.Context, blogID primitive.ObjectID, a AttachmentItem) error {
+	r, err := s.blobs.Open(ctx, a.SHA256)
+	if err != nil {
+		return err
+	}
+	thumbs, err := thumbnail.Generate(r, s.thumbnailConfig.Options())
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if len(thumbs) == 0 {
+		return nil
+	}
+
+	items := make([]ThumbnailItem, 0, len(thumbs))
+	var added []string
+	for _, t := range thumbs {
+		sum := sha256.Sum256(t.Data)
+		item := ThumbnailItem{
+			Width:       t.Width,
+			Height:      t.Height,
+			ContentType: t.ContentType,
+			Size:        int64(len(t.Data)),
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+		stored, err := s.blobs.Exists(ctx, item.SHA256)
+		if err == nil && !stored {
+			if err = s.blobs.Put(ctx, item.SHA256, bytes.NewReader(t.Data)); err == nil {
+				added = append(added, item.SHA256)
+			}
+		}
+		if err != nil {
+			s.releaseThumbnails(ctx, added)
+			return err
+		}
+		items = append(items, item)
+	}
+	if err := s.store.SetThumbnails(ctx, blogID, a.ID, items); err != nil {
+		// The blog or the attachment may have been deleted meanwhile.
+		s.releaseThumbnails(ctx, added)
+		if err == errBlogNotFound {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseThumbnails deletes the content of thumbnails that were stored but
+// could not be recorded.
+func (s *server) releaseThumbnails(ctx context.Context, sums []string) {
+	if len(sums) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	for _, sum := range sums {
+		s.release(ctx, sum)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/outbox"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/validation"
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/webhook"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// webhookEvents maps the events of the API to those of package webhook.
+var webhookEvents = map[blogpb.WebhookEvent]string{
+	blogpb.WebhookEvent_BLOG_CREATED: webhook.BlogCreated,
+	blogpb.WebhookEvent_BLOG_UPDATED: webhook.BlogUpdated,
+	blogpb.WebhookEvent_BLOG_DELETED: webhook.BlogDeleted,
+}
+
+func (s *server) RegisterWebhook(ctx context.Context, req *blogpb.RegisterWebhookRequest) (*blogpb.RegisterWebhookResponse, error) {
+	logging.FromContext(ctx).Debug("registering webhook", "url", req.GetUrl())
+	if s.webhooks == nil {
+		return nil, status.Error(codes.Unimplemented, "Webhooks are disabled on this server")
+	}
+	if err := validation.ValidateRegisterWebhookRequest(req); err != nil {
+		return nil, err
+	}
+	secret := req.GetSecret()
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to generate webhook secret %v", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+	w := &webhook.Webhook{
+		ID:        primitive.NewObjectID(),
+		URL:       req.GetUrl(),
+		Secret:    secret,
+		CreatedAt: now(),
+	}
+	for _, e := range req.GetEvents() {
+		w.Events = append(w.Events, webhookEvents[e])
+	}
+	if err := s.webhooks.Create(ctx, w, s.webhookConfig.MaxWebhooks); err != nil {
+		if err == webhook.ErrLimitReached {
+			return nil, status.Errorf(codes.FailedPrecondition, "There are already the maximum of %d webhooks", s.webhookConfig.MaxWebhooks)
+		}
+		return nil, storeError(ctx, err, "Failed to register webhook")
+	}
+	return &blogpb.RegisterWebhookResponse{Webhook: webhookToPB(w), Secret: secret}, nil
+}
+
+func (s *server) ListWebhooks(ctx context.Context, req *blogpb.ListWebhooksRequest) (*blogpb.ListWebhooksResponse, error) {
+	logging.FromContext(ctx).Debug("listing webhooks")
+	if s.webhooks == nil {
+		return nil, status.Error(codes.Unimplemented, "Webhooks are disabled on this server")
+	}
+	hooks, err := s.webhooks.List(ctx)
+	if err != nil {
+		return nil, storeError(ctx, err, "Failed to list webhooks")
+	}
+	res := &blogpb.ListWebhooksResponse{}
+	for _, w := range hooks {
+		res.Webhooks = append(res.Webhooks, webhookToPB(w))
+	}
+	return res, nil
+}
+
+func (s *server) DeleteWebhook(ctx context.Context, req *blogpb.DeleteWebhookRequest) (*blogpb.DeleteWebhookResponse, error) {
+	logging.FromContext(ctx).Debug("deleting webhook", "webhook_id", req.GetWebhookId())
+	if s.webhooks == nil {
+		return nil, status.Error(codes.Unimplemented, "Webhooks are disabled on this server")
+	}
+	if err := validation.ValidateDeleteWebhookRequest(req); err != nil {
+		return nil, err
+	}
+	id := validation.ObjectID(req.GetWebhookId())
+	if err := s.webhooks.Delete(ctx, id); err != nil {
+		if err == webhook.ErrNotFound {
+			return nil, status.Error(codes.NotFound, "Failed to delete webhook, webhook not found")
+		}
+		return nil, storeError(ctx, err, "Failed to delete webhook")
+	}
+	return &blogpb.DeleteWebhookResponse{WebhookId: id.Hex()}, nil
+}
+
+// publish hands an event about data to the webhooks. Delivery happens in
+// the background and never fails the call that caused the event. With the
+// outbox the store has already recorded the event, and the relay is only
+// woken to publish it.
+func (s *server) publish(ctx context.Context, eventType string, data *BlogItem) {
+	if s.relay != nil {
+		s.relay.Notify()
+		if s.outboxConfig.Publisher == "webhooks" {
+			return
+		}
+	}
+	if s.dispatcher == nil {
+		return
+	}
+	blog, err := marshalBlog(data)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to encode webhook event", "event_type", eventType, "blog_id", data.ID.Hex(), "err", err)
+		return
+	}
+	s.dispatcher.Publish(&webhook.Event{
+		ID:         primitive.NewObjectID().Hex(),
+		Type:       eventType,
+		OccurredAt: time.Now(),
+		Blog:       blog,
+	})
+}
+
+// marshalBlog encodes data the way the HTTP API does.
+func marshalBlog(data *BlogItem) ([]byte, error) {
+	return protojson.Marshal(dataToBlog(data))
+}
+
+// webhookPublisher publishes outbox entries to the webhooks. The entry id
+// becomes the event id, so receivers can tell an entry published again
+// from a new event. An entry counts as published once its deliveries are
+// recorded, not when they are made.
+type webhookPublisher struct {
+	dispatcher *webhook.Dispatcher
+}
+
+func (p webhookPublisher) Publish(ctx context.Context, e *outbox.Entry) error {
+	return p.dispatcher.Enqueue(ctx, &webhook.Event{
+		ID:         e.ID.Hex(),
+		Type:       e.Type,
+		OccurredAt: e.CreatedAt,
+		Blog:       e.Payload,
+	})
+}
+
+func webhookToPB(w *webhook.Webhook) *blogpb.Webhook {
+	pb := &blogpb.Webhook{
+		Id:                  w.ID.Hex(),
+		Url:                 w.URL,
+		Disabled:            w.Disabled,
+		ConsecutiveFailures: int32(w.ConsecutiveFailures),
+		LastError:           w.LastError,
+		CreatedAt:           timestamppb.New(w.CreatedAt),
+	}
+	for _, e := range w.Events {
+		for k, v := range webhookEvents {
+			if v == e {
+				pb.Events = append(pb.Events, k)
+			}
+		}
+	}
+	if !w.DisabledAt.IsZero() {
+		pb.DisabledAt = timestamppb.New(w.DisabledAt)
+	}
+	return pb
+}
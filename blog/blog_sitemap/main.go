@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/sitemap"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Config shares the BLOG_ environment prefix with blog_server so both link
+// to the same site without extra setup.
+type Config struct {
+	Mongo   config.Mongo  `yaml:"mongo"`
+	Site    config.Site   `yaml:"site"`
+	Output  string        `yaml:"output" usage:"directory the sitemap is written to, to be served as the root of the site"`
+	Timeout time.Duration `yaml:"timeout" usage:"how long writing the sitemap may take"`
+}
+
+func (c *Config) Validate() error {
+	if err := c.Mongo.Validate(); err != nil {
+		return err
+	}
+	if err := c.Site.Validate(); err != nil {
+		return err
+	}
+	if c.Output == "" {
+		return fmt.Errorf("output: is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout: must be positive")
+	}
+	return nil
+}
+
+const usage = `usage: blog_sitemap [flags]`
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	cfg := &Config{
+		Mongo: config.Mongo{
+			URI:      "mongodb://localhost:27017",
+			Database: "mydb",
+		},
+		Site:    config.DefaultSite,
+		Output:  ".",
+		Timeout: 10 * time.Minute,
+	}
+	// Read the mongo and site sections of blog_server's config file and
+	// ignore the rest.
+	if args := config.MustLoad("BLOG", cfg, config.AllowUnknownFields()); len(args) != 0 {
+		log.Fatal(usage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		log.Fatalf("Error while connecting to Mongodb %v", err)
+	}
+	defer client.Disconnect(context.Background())
+	src := sitemap.NewMongoSource(client.Database(cfg.Mongo.Database), cfg.Timeout, nil)
+	s := sitemap.New(src, cfg.Site)
+
+	pages, err := s.Pages(ctx)
+	if err != nil {
+		log.Fatalf("Failed to count blogs %v", err)
+	}
+	if pages == 1 {
+		err = writeFile(filepath.Join(cfg.Output, sitemap.Path), func(f *bufio.Writer) error {
+			_, err := s.WritePage(ctx, f, 1)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Failed to write sitemap %v", err)
+		}
+		fmt.Printf("Wrote %s\n", sitemap.Path)
+		removeStalePages(cfg.Output, 1)
+		return
+	}
+	// Pages first, so the index never lists a page that is not there yet.
+	for n := 1; n <= pages; n++ {
+		err := writeFile(filepath.Join(cfg.Output, sitemap.PagePath(n)), func(f *bufio.Writer) error {
+			_, err := s.WritePage(ctx, f, n)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Failed to write sitemap page %d %v", n, err)
+		}
+	}
+	err = writeFile(filepath.Join(cfg.Output, sitemap.Path), func(f *bufio.Writer) error {
+		return s.WriteIndex(f, pages)
+	})
+	if err != nil {
+		log.Fatalf("Failed to write sitemap index %v", err)
+	}
+	fmt.Printf("Wrote %s and %d pages\n", sitemap.Path, pages)
+	removeStalePages(cfg.Output, pages+1)
+}
+
+// writeFile replaces path with what write writes, so a site serving it
+// never sees a partial file.
+func writeFile(path string, write func(*bufio.Writer) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// removeStalePages deletes the pages from page from on, left over from
+// earlier runs when there were more blogs.
+func removeStalePages(dir string, from int) {
+	for n := from; ; n++ {
+		err := os.Remove(filepath.Join(dir, sitemap.PagePath(n)))
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Failed to remove stale sitemap page %d %v", n, err)
+		}
+		fmt.Printf("Removed stale page %s\n", sitemap.PagePath(n))
+	}
+}
@@ -0,0 +1,79 @@
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Atom renders f as an Atom 1.0 document. The feed's own URL serves as its
+// id. Every entry has an author, so the feed needs none.
+func (f *Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		ID:      f.Self,
+		Title:   f.Title,
+		Updated: atomDate(f.Updated),
+		Links: []atomLink{
+			{Href: f.Self, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate"},
+		},
+	}
+	for _, it := range f.Items {
+		e := atomEntry{
+			ID:        it.ID,
+			Title:     it.Title,
+			Link:      atomLink{Href: it.Link, Rel: "alternate"},
+			Published: atomDate(it.Published),
+			Updated:   atomDate(it.Updated),
+			Author:    atomPerson{Name: it.Author},
+			Content:   atomText{Type: "text", Value: it.Content},
+		}
+		for _, c := range it.Categories {
+			e.Categories = append(e.Categories, atomCategory{Term: c})
+		}
+		doc.Entries = append(doc.Entries, e)
+	}
+	return marshal(doc)
+}
+
+func atomDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
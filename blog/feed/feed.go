@@ -0,0 +1,66 @@
+// Package feed renders syndication feeds of blogs as RSS 2.0 and Atom 1.0.
+// Text is escaped by encoding/xml, so titles and content are carried
+// verbatim whatever characters they contain.
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Content types of the two formats.
+const (
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+	AtomContentType = "application/atom+xml; charset=utf-8"
+)
+
+// Feed is a list of items in a format independent form.
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the page the feed belongs to and Self the feed's own URL.
+	Link string
+	Self string
+	// Updated is when any item last changed.
+	Updated time.Time
+	Items   []Item
+}
+
+// Item is one entry of a feed.
+type Item struct {
+	// ID identifies the item permanently, across title and URL changes.
+	ID         string
+	Title      string
+	Link       string
+	Author     string
+	Content    string
+	Categories []string
+	Published  time.Time
+	Updated    time.Time
+}
+
+// TagURI returns a tag URI (RFC 4151) such as
+// tag:blog.example.com,2021-07-19:blog/60f50e50db60a7737b8b7c44, suitable as
+// a permanent item id. authority is usually the host of the site.
+func TagURI(authority string, date time.Time, specific string) string {
+	return fmt.Sprintf("tag:%s,%s:%s", authority, date.UTC().Format("2006-01-02"), specific)
+}
+
+// Host returns the host of rawURL, for use with TagURI.
+func Host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,233 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tricky holds text encoding/xml must escape: markup, entities, quotes, a
+// CDATA terminator and non-ASCII characters.
+const tricky = `<script>alert("x & y")</script> ]]> 'quoted' &amp; café ☕`
+
+func testFeed() *Feed {
+	published := time.Date(2021, 7, 19, 10, 0, 0, 0, time.UTC)
+	return &Feed{
+		Title:       "Posts & <news>",
+		Description: tricky,
+		Link:        "https://blog.example.com/",
+		Self:        "https://blog.example.com/feeds/rss.xml?a=1&b=2",
+		Updated:     published.Add(time.Hour),
+		Items: []Item{
+			{
+				ID:         TagURI("blog.example.com", published, "blog/60f50e50db60a7737b8b7c44"),
+				Title:      tricky,
+				Link:       "https://blog.example.com/blogs/hello?x=<1>&y=2",
+				Author:     `Ann "the <writer>"`,
+				Content:    "line one\nline two " + tricky,
+				Categories: []string{"go&rust", "<xml>"},
+				Published:  published,
+				Updated:    published.Add(time.Hour),
+			},
+			{
+				ID:        TagURI("blog.example.com", published, "blog/60f50e50db60a7737b8b7c45"),
+				Title:     "Plain",
+				Link:      "https://blog.example.com/blogs/plain",
+				Author:    "Bob",
+				Content:   "Plain content",
+				Published: published,
+				Updated:   published,
+			},
+		},
+	}
+}
+
+// checkEscaped fails when the raw markup of tricky text leaks into doc.
+func checkEscaped(t *testing.T, doc []byte) {
+	t.Helper()
+	for _, raw := range []string{"<script>", "<news>", "<writer>", "<xml>", "<1>", " & "} {
+		if bytes.Contains(doc, []byte(raw)) {
+			t.Errorf("document contains unescaped %q:\n%s", raw, doc)
+		}
+	}
+}
+
+// Documents are parsed back with namespace-qualified names, the way a
+// reader sees them.
+type parsedRSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string `xml:"title"`
+		Description   string `xml:"description"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		// Links holds both the RSS link and the atom:link to the feed,
+		// as a name without a namespace matches either.
+		Links []struct {
+			XMLName xml.Name
+			Href    string `xml:"href,attr"`
+			Rel     string `xml:"rel,attr"`
+			Value   string `xml:",chardata"`
+		} `xml:"link"`
+		Items []struct {
+			Title string `xml:"title"`
+			Link  string `xml:"link"`
+			GUID  struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				Value       string `xml:",chardata"`
+			} `xml:"guid"`
+			PubDate     string   `xml:"pubDate"`
+			Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			Categories  []string `xml:"category"`
+			Description string   `xml:"description"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func TestRSSRoundTrip(t *testing.T) {
+	f := testFeed()
+	doc, err := f.RSS()
+	if err != nil {
+		t.Fatalf("RSS() = %v", err)
+	}
+	checkEscaped(t, doc)
+
+	var got parsedRSS
+	if err := xml.Unmarshal(doc, &got); err != nil {
+		t.Fatalf("cannot parse the document: %v\n%s", err, doc)
+	}
+	if got.Version != "2.0" {
+		t.Errorf("version = %q, want 2.0", got.Version)
+	}
+	ch := got.Channel
+	if ch.Title != f.Title || ch.Description != f.Description {
+		t.Errorf("channel = %q, %q, want %q, %q", ch.Title, ch.Description, f.Title, f.Description)
+	}
+	var link, self string
+	for _, l := range ch.Links {
+		switch {
+		case l.XMLName.Space == "" && l.Value != "":
+			link = l.Value
+		case l.XMLName.Space == "http://www.w3.org/2005/Atom" && l.Rel == "self":
+			self = l.Href
+		}
+	}
+	if link != f.Link || self != f.Self {
+		t.Errorf("link = %q and atom:link = %q, want %q and %q", link, self, f.Link, f.Self)
+	}
+	if d, err := time.Parse(time.RFC1123Z, ch.LastBuildDate); err != nil || !d.Equal(f.Updated) {
+		t.Errorf("lastBuildDate = %q, want %v", ch.LastBuildDate, f.Updated)
+	}
+	if len(ch.Items) != len(f.Items) {
+		t.Fatalf("got %d items, want %d", len(ch.Items), len(f.Items))
+	}
+	for i, want := range f.Items {
+		it := ch.Items[i]
+		if it.Title != want.Title || it.Link != want.Link || it.Creator != want.Author || it.Description != want.Content {
+			t.Errorf("item %d = %q, %q, %q, %q, want %q, %q, %q, %q", i,
+				it.Title, it.Link, it.Creator, it.Description, want.Title, want.Link, want.Author, want.Content)
+		}
+		if it.GUID.Value != want.ID || it.GUID.IsPermaLink != "false" {
+			t.Errorf("item %d guid = %q isPermaLink %q, want %q false", i, it.GUID.Value, it.GUID.IsPermaLink, want.ID)
+		}
+		if strings.Join(it.Categories, ",") != strings.Join(want.Categories, ",") {
+			t.Errorf("item %d categories = %q, want %q", i, it.Categories, want.Categories)
+		}
+		if d, err := time.Parse(time.RFC1123Z, it.PubDate); err != nil || !d.Equal(want.Published) {
+			t.Errorf("item %d pubDate = %q, want %v", i, it.PubDate, want.Published)
+		}
+	}
+}
+
+type parsedAtom struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"http://www.w3.org/2005/Atom id"`
+	Title   string   `xml:"http://www.w3.org/2005/Atom title"`
+	Updated string   `xml:"http://www.w3.org/2005/Atom updated"`
+	Links   []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"http://www.w3.org/2005/Atom link"`
+	Entries []struct {
+		ID    string `xml:"http://www.w3.org/2005/Atom id"`
+		Title string `xml:"http://www.w3.org/2005/Atom title"`
+		Link  struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.w3.org/2005/Atom link"`
+		Published string `xml:"http://www.w3.org/2005/Atom published"`
+		Updated   string `xml:"http://www.w3.org/2005/Atom updated"`
+		Author    struct {
+			Name string `xml:"http://www.w3.org/2005/Atom name"`
+		} `xml:"http://www.w3.org/2005/Atom author"`
+		Categories []struct {
+			Term string `xml:"term,attr"`
+		} `xml:"http://www.w3.org/2005/Atom category"`
+		Content struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"http://www.w3.org/2005/Atom content"`
+	} `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+func TestAtomRoundTrip(t *testing.T) {
+	f := testFeed()
+	doc, err := f.Atom()
+	if err != nil {
+		t.Fatalf("Atom() = %v", err)
+	}
+	checkEscaped(t, doc)
+
+	var got parsedAtom
+	if err := xml.Unmarshal(doc, &got); err != nil {
+		t.Fatalf("cannot parse the document: %v\n%s", err, doc)
+	}
+	if got.ID != f.Self || got.Title != f.Title {
+		t.Errorf("feed = %q, %q, want %q, %q", got.ID, got.Title, f.Self, f.Title)
+	}
+	if got.Updated != "2021-07-19T11:00:00Z" {
+		t.Errorf("updated = %q, want 2021-07-19T11:00:00Z", got.Updated)
+	}
+	links := make(map[string]string)
+	for _, l := range got.Links {
+		links[l.Rel] = l.Href
+	}
+	if links["self"] != f.Self || links["alternate"] != f.Link {
+		t.Errorf("links = %v, want self %q and alternate %q", links, f.Self, f.Link)
+	}
+	if len(got.Entries) != len(f.Items) {
+		t.Fatalf("got %d entries, want %d", len(got.Entries), len(f.Items))
+	}
+	for i, want := range f.Items {
+		e := got.Entries[i]
+		if e.ID != want.ID || e.Title != want.Title || e.Link.Href != want.Link || e.Author.Name != want.Author {
+			t.Errorf("entry %d = %q, %q, %q, %q, want %q, %q, %q, %q", i,
+				e.ID, e.Title, e.Link.Href, e.Author.Name, want.ID, want.Title, want.Link, want.Author)
+		}
+		if e.Content.Type != "text" || e.Content.Value != want.Content {
+			t.Errorf("entry %d content = %q (%s), want %q (text)", i, e.Content.Value, e.Content.Type, want.Content)
+		}
+		var terms []string
+		for _, c := range e.Categories {
+			terms = append(terms, c.Term)
+		}
+		if strings.Join(terms, ",") != strings.Join(want.Categories, ",") {
+			t.Errorf("entry %d categories = %q, want %q", i, terms, want.Categories)
+		}
+		if d, err := time.Parse(time.RFC3339, e.Published); err != nil || !d.Equal(want.Published) {
+			t.Errorf("entry %d published = %q, want %v", i, e.Published, want.Published)
+		}
+		if d, err := time.Parse(time.RFC3339, e.Updated); err != nil || !d.Equal(want.Updated) {
+			t.Errorf("entry %d updated = %q, want %v", i, e.Updated, want.Updated)
+		}
+	}
+}
+
+func TestTagURI(t *testing.T) {
+	at := time.Date(2021, 7, 19, 23, 30, 0, 0, time.FixedZone("", -5*3600))
+	want := "tag:blog.example.com,2021-07-20:blog/1"
+	if got := TagURI(Host("https://blog.example.com:8443/x"), at, "blog/1"); got != want {
+		t.Errorf("TagURI() = %q, want %q", got, want)
+	}
+}
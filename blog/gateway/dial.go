@@ -0,0 +1,20 @@
+package gateway
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Dial connects to the BlogService at address. When caFile is set the
+// connection uses TLS and trusts the certificates signed by it.
+func Dial(address, caFile string) (*grpc.ClientConn, error) {
+	opt := grpc.WithInsecure()
+	if caFile != "" {
+		creds, err := credentials.NewClientTLSFromFile(caFile, "")
+		if err != nil {
+			return nil, err
+		}
+		opt = grpc.WithTransportCredentials(creds)
+	}
+	return grpc.Dial(address, opt)
+}
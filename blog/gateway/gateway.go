@@ -0,0 +1,453 @@
+// Package gateway exposes BlogService as an HTTP/JSON API:
+//
+//	POST   /v1/blogs                                    CreateBlog, body is a Blog
+//	GET    /v1/blogs                                    ListBlog, streamed as newline delimited JSON
+//	GET    /v1/blogs/{id}?viewerId=ann                  ReadBlog; viewerId is optional
+//	PATCH  /v1/blogs/{id}                               UpdateBlog, body holds the fields to change
+//	DELETE /v1/blogs/{id}                               DeleteBlog
+//	POST   /v1/blogs/{id}/reactions                     ReactToBlog, body holds userId and reaction
+//	GET    /v1/blogs/{id}/reactions?reaction=LIKE       ListReactions, streamed; reaction is optional
+//	DELETE /v1/blogs/{id}/reactions/{userId}/{reaction} RemoveReaction
+//	GET    /v1/blogs/{id}/analytics                     GetBlogAnalytics for a blog
+//	GET    /v1/blogs/{id}/related?limit=5               GetRelatedBlogs; limit is optional
+//	GET    /v1/authors/{authorId}/analytics             GetBlogAnalytics for an author
+//
+// Analytics take granularity, startTime, endTime and, for authors, topPosts
+// query parameters.
+//
+// Messages are encoded with protojson and errors as google.rpc.Status. The
+// mapping is also described by Routes and served as /openapi.json.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/ratelimit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maxBodyBytes bounds request bodies; blog content is limited well below it.
+const maxBodyBytes = 1 << 20
+
+// forwardedHeaders are copied from the HTTP request into gRPC metadata.
+var forwardedHeaders = []string{"Idempotency-Key", "X-Request-Id", "Authorization", "X-Api-Key", "X-Tenant-Id"}
+
+var marshaler = protojson.MarshalOptions{}
+
+type Handler struct {
+	client blogpb.BlogServiceClient
+}
+
+// New returns an http.Handler serving /v1/blogs on top of client.
+func New(client blogpb.BlogServiceClient) *Handler {
+	return &Handler{client: client}
+}
+
+// Register mounts the gateway routes and /openapi.json on mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.Handle("/v1/blogs", h)
+	mux.Handle("/v1/blogs/", h)
+	mux.Handle("/v1/authors/", h)
+	mux.Handle("/openapi.json", OpenAPIHandler())
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := outgoingContext(r)
+	if strings.HasPrefix(r.URL.Path, "/v1/authors/") {
+		h.serveAuthor(ctx, w, r)
+		return
+	}
+	if r.URL.Path == "/v1/blogs" {
+		switch r.Method {
+		case http.MethodPost:
+			h.create(ctx, w, r)
+		case http.MethodGet:
+			h.list(ctx, w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/blogs/"), "/")
+	for _, p := range parts {
+		if p == "" {
+			writeError(w, status.Errorf(codes.NotFound, "No route for %s", r.URL.Path))
+			return
+		}
+	}
+	id := parts[0]
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.read(ctx, w, r, id)
+		case http.MethodPatch:
+			h.update(ctx, w, r, id)
+		case http.MethodDelete:
+			h.delete(ctx, w, id)
+		default:
+			methodNotAllowed(w, "GET, PATCH, DELETE")
+		}
+	case len(parts) == 2 && parts[1] == "reactions":
+		switch r.Method {
+		case http.MethodPost:
+			h.react(ctx, w, r, id)
+		case http.MethodGet:
+			h.listReactions(ctx, w, r, id)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case len(parts) == 4 && parts[1] == "reactions":
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, "DELETE")
+			return
+		}
+		h.removeReaction(ctx, w, id, parts[2], parts[3])
+	case len(parts) == 2 && parts[1] == "analytics":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		h.analytics(ctx, w, r, &blogpb.GetBlogAnalyticsRequest{BlogId: id}, analyticsQuery[:3])
+	case len(parts) == 2 && parts[1] == "related":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		h.related(ctx, w, r, id)
+	default:
+		writeError(w, status.Errorf(codes.NotFound, "No route for %s", r.URL.Path))
+	}
+}
+
+// serveAuthor serves /v1/authors/{authorId}/analytics.
+func (h *Handler) serveAuthor(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/authors/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "analytics" {
+		writeError(w, status.Errorf(codes.NotFound, "No route for %s", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	h.analytics(ctx, w, r, &blogpb.GetBlogAnalyticsRequest{AuthorId: parts[0]}, analyticsQuery)
+}
+
+func (h *Handler) create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	blog := &blogpb.Blog{}
+	if err := readBody(r, blog); err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.client.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/blogs/"+res.GetBlog().GetId())
+	writeMessage(w, http.StatusCreated, res.GetBlog())
+}
+
+func (h *Handler) read(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	req := &blogpb.ReadBlogRequest{Id: id}
+	if err := readQuery(r.URL.Query(), req, []string{"viewerId"}); err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.client.ReadBlog(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res.GetBlog())
+}
+
+// update changes the fields present in the body, and only those: they make
+// up the update mask, so a field given as null is cleared. Tags in the body
+// replace the stored ones.
+func (h *Handler) update(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	b, err := readRawBody(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	patch := &blogpb.Blog{}
+	if err := unmarshalBody(b, patch); err != nil {
+		writeError(w, err)
+		return
+	}
+	if patch.GetId() != "" && patch.GetId() != id {
+		writeError(w, status.Error(codes.InvalidArgument, "Blog id in body does not match the URL"))
+		return
+	}
+	mask, err := presentFields(b, patch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(mask.GetPaths()) == 0 {
+		writeError(w, status.Error(codes.InvalidArgument, "Body sets no field to change"))
+		return
+	}
+	patch.Id = id
+	res, err := h.client.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: patch, UpdateMask: mask})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res.GetBlog())
+}
+
+// presentFields returns a mask of the fields of m, other than its id, set in
+// the JSON object b, null ones included. Paths are sorted, so retries of a
+// call carry the same request.
+func presentFields(b []byte, m proto.Message) (*fieldmaskpb.FieldMask, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid JSON body %v", err)
+	}
+	fields := m.ProtoReflect().Descriptor().Fields()
+	mask := &fieldmaskpb.FieldMask{}
+	for key := range keys {
+		fd := fields.ByJSONName(key)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(key))
+		}
+		// protojson has already refused unknown fields.
+		if fd != nil && fd.Name() != "id" {
+			mask.Paths = append(mask.Paths, string(fd.Name()))
+		}
+	}
+	sort.Strings(mask.Paths)
+	return mask, nil
+}
+
+func (h *Handler) delete(ctx context.Context, w http.ResponseWriter, id string) {
+	res, err := h.client.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: id})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (h *Handler) react(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	req := &blogpb.ReactToBlogRequest{}
+	if err := readBody(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.GetBlogId() != "" && req.GetBlogId() != id {
+		writeError(w, status.Error(codes.InvalidArgument, "Blog id in body does not match the URL"))
+		return
+	}
+	req.BlogId = id
+	res, err := h.client.ReactToBlog(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (h *Handler) removeReaction(ctx context.Context, w http.ResponseWriter, id, userID, reaction string) {
+	kind, err := parseReaction(reaction)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.client.RemoveReaction(ctx, &blogpb.RemoveReactionRequest{
+		BlogId:   id,
+		UserId:   userID,
+		Reaction: kind,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+// listReactions streams one JSON encoded ListReactionsResponse per line,
+// like list.
+func (h *Handler) listReactions(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	req := &blogpb.ListReactionsRequest{BlogId: id}
+	if err := readQuery(r.URL.Query(), req, []string{"reaction"}); err != nil {
+		writeError(w, err)
+		return
+	}
+	stream, err := h.client.ListReactions(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeStream(w, func() (proto.Message, error) {
+		return stream.Recv()
+	})
+}
+
+func (h *Handler) analytics(ctx context.Context, w http.ResponseWriter, r *http.Request, req *blogpb.GetBlogAnalyticsRequest, query []string) {
+	if err := readQuery(r.URL.Query(), req, query); err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.client.GetBlogAnalytics(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (h *Handler) related(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	req := &blogpb.GetRelatedBlogsRequest{BlogId: id}
+	if err := readQuery(r.URL.Query(), req, []string{"limit"}); err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.client.GetRelatedBlogs(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+// parseReaction accepts a Reaction by name in any case.
+func parseReaction(v string) (blogpb.Reaction, error) {
+	n, ok := blogpb.Reaction_value[strings.ToUpper(v)]
+	if !ok || n == 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Unknown reaction %q", v)
+	}
+	return blogpb.Reaction(n), nil
+}
+
+// list streams one JSON encoded Blog per line.
+func (h *Handler) list(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	stream, err := h.client.ListBlog(ctx, &blogpb.ListBlogRequest{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeStream(w, func() (proto.Message, error) {
+		res, err := stream.Recv()
+		return res.GetBlog(), err
+	})
+}
+
+// writeStream writes the messages returned by recv as newline delimited
+// JSON until it returns io.EOF. Errors before the first message are
+// returned as a normal error response; errors after that are written as a
+// final {"error": ...} line since the status has already been sent.
+func writeStream(w http.ResponseWriter, recv func() (proto.Message, error)) {
+	flusher, _ := w.(http.Flusher)
+	started := false
+	for {
+		res, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !started {
+				writeError(w, err)
+				return
+			}
+			b, _ := marshaler.Marshal(status.Convert(err).Proto())
+			w.Write([]byte(`{"error":`))
+			w.Write(b)
+			w.Write([]byte("}\n"))
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		b, err := marshaler.Marshal(res)
+		if err != nil {
+			return
+		}
+		w.Write(b)
+		w.Write([]byte("\n"))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// outgoingContext carries the request's cancellation and selected headers
+// over to the gRPC call.
+func outgoingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, name := range forwardedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			md.Set(strings.ToLower(name), v)
+		}
+	}
+	// Lets the server rate limit the HTTP client rather than the gateway.
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set(ratelimit.ForwardedForKey, host)
+	}
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+func readBody(r *http.Request, m proto.Message) error {
+	b, err := readRawBody(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalBody(b, m)
+}
+
+func readRawBody(r *http.Request) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Cannot read request body %v", err)
+	}
+	if len(b) > maxBodyBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Request body larger than %d bytes", maxBodyBytes)
+	}
+	return b, nil
+}
+
+func unmarshalBody(b []byte, m proto.Message) error {
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid JSON body %v", err)
+	}
+	return nil
+}
+
+func writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	b, err := marshaler.Marshal(m)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "Cannot encode response %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "Method not allowed"))
+}
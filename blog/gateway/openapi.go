@@ -0,0 +1,255 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// The document is derived from the compiled blog.proto descriptor and the
+// Routes table each time it is built, so it cannot drift from the proto.
+
+type object = map[string]interface{}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// OpenAPI returns the OpenAPI 3 document for the HTTP API as JSON.
+func OpenAPI() ([]byte, error) {
+	file := blogpb.File_blog_blogpb_blog_proto
+	svc := file.Services().ByName("BlogService")
+	if svc == nil {
+		return nil, fmt.Errorf("openapi: BlogService not found in %s", file.Path())
+	}
+
+	schemas := object{}
+	paths := object{}
+	for _, r := range Routes {
+		method := svc.Methods().ByName(protoreflect.Name(r.RPC))
+		if method == nil {
+			return nil, fmt.Errorf("openapi: route %s %s refers to unknown rpc %s", r.Method, r.Path, r.RPC)
+		}
+		opID := r.Operation
+		if opID == "" {
+			opID = r.RPC
+		}
+		op := object{
+			"operationId": opID,
+			"summary":     r.Summary,
+			"tags":        []string{string(svc.Name())},
+			"responses":   routeResponses(r),
+		}
+		if params := parameters(r); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if r.Body != "" {
+			op["requestBody"] = object{
+				"required": true,
+				"content": object{
+					"application/json": object{"schema": ref(r.Body)},
+				},
+			}
+			if err := addSchema(schemas, r.Body); err != nil {
+				return nil, err
+			}
+		}
+		if err := addSchema(schemas, r.Response); err != nil {
+			return nil, err
+		}
+		item, _ := paths[r.Path].(object)
+		if item == nil {
+			item = object{}
+			paths[r.Path] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+	schemas["google.rpc.Status"] = statusSchema()
+
+	doc := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   string(svc.FullName()),
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": schemas,
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// OpenAPIHandler serves the document built by OpenAPI.
+func OpenAPIHandler() http.Handler {
+	b, err := OpenAPI()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+func routeResponses(r Route) object {
+	content := object{"application/json": object{"schema": ref(r.Response)}}
+	if r.Stream {
+		content = object{"application/x-ndjson": object{"schema": ref(r.Response)}}
+	}
+	errorContent := object{"application/json": object{"schema": ref("google.rpc.Status")}}
+	return object{
+		strconv.Itoa(r.Status): object{
+			"description": "OK",
+			"content":     content,
+		},
+		"default": object{
+			"description": "A google.rpc.Status describing the error. The HTTP status follows the gRPC code.",
+			"content":     errorContent,
+		},
+	}
+}
+
+func parameters(r Route) []object {
+	var params []object
+	for _, m := range pathParam.FindAllStringSubmatch(r.Path, -1) {
+		params = append(params, object{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   object{"type": "string"},
+		})
+	}
+	for _, q := range r.Query {
+		params = append(params, object{
+			"name":     q,
+			"in":       "query",
+			"required": false,
+			"schema":   object{"type": "string"},
+		})
+	}
+	return params
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// addSchema adds the schema for message name and every message and enum it
+// refers to.
+func addSchema(schemas object, name string) error {
+	if _, ok := schemas[name]; ok {
+		return nil
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("openapi: %v", err)
+	}
+	switch d := d.(type) {
+	case protoreflect.EnumDescriptor:
+		var values []string
+		for i := 0; i < d.Values().Len(); i++ {
+			values = append(values, string(d.Values().Get(i).Name()))
+		}
+		schemas[name] = object{"type": "string", "enum": values}
+		return nil
+	case protoreflect.MessageDescriptor:
+		props := object{}
+		schemas[name] = object{"type": "object", "properties": props}
+		fields := d.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			f := fields.Get(i)
+			schema, deps := fieldSchema(f)
+			props[f.JSONName()] = schema
+			for _, dep := range deps {
+				if err := addSchema(schemas, dep); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("openapi: %s is not a message or enum", name)
+}
+
+// fieldSchema maps a field to its protojson representation and returns the
+// messages and enums it depends on.
+func fieldSchema(f protoreflect.FieldDescriptor) (object, []string) {
+	if f.IsMap() {
+		value, deps := singularSchema(f.MapValue())
+		return object{"type": "object", "additionalProperties": value}, deps
+	}
+	schema, deps := singularSchema(f)
+	if f.IsList() {
+		return object{"type": "array", "items": schema}, deps
+	}
+	return schema, deps
+}
+
+func singularSchema(f protoreflect.FieldDescriptor) (object, []string) {
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return object{"type": "boolean"}, nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return object{"type": "integer", "format": "int32"}, nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return object{"type": "integer", "format": "int64", "minimum": 0}, nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// protojson writes 64 bit integers as strings.
+		return object{"type": "string", "format": "int64"}, nil
+	case protoreflect.FloatKind:
+		return object{"type": "number", "format": "float"}, nil
+	case protoreflect.DoubleKind:
+		return object{"type": "number", "format": "double"}, nil
+	case protoreflect.StringKind:
+		return object{"type": "string"}, nil
+	case protoreflect.BytesKind:
+		return object{"type": "string", "format": "byte"}, nil
+	case protoreflect.EnumKind:
+		name := string(f.Enum().FullName())
+		return ref(name), []string{name}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if s, ok := wellKnown[f.Message().FullName()]; ok {
+			return s, nil
+		}
+		name := string(f.Message().FullName())
+		return ref(name), []string{name}
+	}
+	return object{}, nil
+}
+
+// wellKnown holds the special JSON forms of well-known types.
+var wellKnown = map[protoreflect.FullName]object{
+	"google.protobuf.Timestamp":   {"type": "string", "format": "date-time"},
+	"google.protobuf.Duration":    {"type": "string", "pattern": `^-?\d+(\.\d+)?s$`},
+	"google.protobuf.StringValue": {"type": "string"},
+	"google.protobuf.BoolValue":   {"type": "boolean"},
+	"google.protobuf.Int32Value":  {"type": "integer", "format": "int32"},
+	"google.protobuf.Int64Value":  {"type": "string", "format": "int64"},
+	"google.protobuf.DoubleValue": {"type": "number", "format": "double"},
+	"google.protobuf.Struct":      {"type": "object"},
+	"google.protobuf.Any":         {"type": "object", "properties": object{"@type": object{"type": "string"}}},
+}
+
+func statusSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"code":    object{"type": "integer", "format": "int32", "description": "gRPC status code"},
+			"message": object{"type": "string"},
+			"details": object{
+				"type":  "array",
+				"items": wellKnown["google.protobuf.Any"],
+				"description": "Typed error details such as google.rpc.BadRequest with field violations " +
+					"or google.rpc.RetryInfo.",
+			},
+		},
+	}
+}
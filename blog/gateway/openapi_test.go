@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// unrouted lists the BlogService RPCs deliberately left out of the HTTP
+// API. Any other RPC must have a route.
+var unrouted = map[string]string{
+	"UploadAttachment":   "client streams raw bytes",
+	"DownloadAttachment": "streams raw bytes",
+	"RegisterWebhook":    "webhooks are managed over gRPC",
+	"ListWebhooks":       "webhooks are managed over gRPC",
+	"DeleteWebhook":      "webhooks are managed over gRPC",
+}
+
+type document struct {
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Enum       []string                   `json:"enum"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadDocument(t *testing.T) *document {
+	t.Helper()
+	b, err := OpenAPI()
+	if err != nil {
+		t.Fatalf("OpenAPI() = %v", err)
+	}
+	doc := &document{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		t.Fatalf("cannot decode the document: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPICoversEveryRPC(t *testing.T) {
+	doc := loadDocument(t)
+	routed := make(map[string]bool)
+	for _, r := range Routes {
+		op, ok := doc.Paths[r.Path][strings.ToLower(r.Method)]
+		if !ok {
+			t.Errorf("%s %s is missing from the document", r.Method, r.Path)
+			continue
+		}
+		want := r.Operation
+		if want == "" {
+			want = r.RPC
+		}
+		if op.OperationID != want {
+			t.Errorf("%s %s has operationId %q, want %q", r.Method, r.Path, op.OperationID, want)
+		}
+		routed[r.RPC] = true
+	}
+	methods := blogpb.File_blog_blogpb_blog_proto.Services().ByName("BlogService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		name := string(methods.Get(i).Name())
+		_, skipped := unrouted[name]
+		switch {
+		case routed[name] && skipped:
+			t.Errorf("%s is routed but listed as unrouted", name)
+		case !routed[name] && !skipped:
+			t.Errorf("rpc %s is missing from the document", name)
+		}
+	}
+	for name := range unrouted {
+		if methods.ByName(protoreflect.Name(name)) == nil {
+			t.Errorf("unrouted lists %s, which is not a BlogService rpc", name)
+		}
+	}
+}
+
+func TestOpenAPICoversEveryField(t *testing.T) {
+	doc := loadDocument(t)
+	for name, schema := range doc.Components.Schemas {
+		if name == "google.rpc.Status" {
+			continue
+		}
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			t.Errorf("schema %s names no proto type: %v", name, err)
+			continue
+		}
+		switch d := d.(type) {
+		case protoreflect.MessageDescriptor:
+			fields := d.Fields()
+			for i := 0; i < fields.Len(); i++ {
+				if _, ok := schema.Properties[fields.Get(i).JSONName()]; !ok {
+					t.Errorf("field %s is missing from schema %s", fields.Get(i).FullName(), name)
+				}
+			}
+			if len(schema.Properties) != fields.Len() {
+				t.Errorf("schema %s has %d properties, want %d", name, len(schema.Properties), fields.Len())
+			}
+		case protoreflect.EnumDescriptor:
+			if len(schema.Enum) != d.Values().Len() {
+				t.Errorf("schema %s has %d values, want %d", name, len(schema.Enum), d.Values().Len())
+			}
+		}
+	}
+	for _, r := range Routes {
+		for _, m := range []string{r.Body, r.Response} {
+			if _, ok := doc.Components.Schemas[m]; m != "" && !ok {
+				t.Errorf("%s %s: schema %s is missing", r.Method, r.Path, m)
+			}
+		}
+	}
+}
+
+// TestRoutesQueryFields checks that every query parameter names a field of
+// the RPC's request, as readQuery expects.
+func TestRoutesQueryFields(t *testing.T) {
+	svc := blogpb.File_blog_blogpb_blog_proto.Services().ByName("BlogService")
+	for _, r := range Routes {
+		input := svc.Methods().ByName(protoreflect.Name(r.RPC)).Input()
+		for _, q := range r.Query {
+			if input.Fields().ByJSONName(q) == nil {
+				t.Errorf("%s %s: query parameter %s is not a field of %s", r.Method, r.Path, q, input.FullName())
+			}
+		}
+	}
+}
+
+// recorder is a BlogService that implements nothing but records which RPCs
+// reach it.
+type recorder struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (rec *recorder) record(method string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.methods = append(rec.methods, method)
+}
+
+func (rec *recorder) take() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	m := rec.methods
+	rec.methods = nil
+	return m
+}
+
+func newTestHandler(t *testing.T) (*Handler, *recorder) {
+	t.Helper()
+	rec := &recorder{}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			rec.record(info.FullMethod)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			rec.record(info.FullMethod)
+			return handler(srv, ss)
+		}),
+	)
+	blogpb.RegisterBlogServiceServer(s, &blogpb.UnimplementedBlogServiceServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("cannot dial the test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return New(blogpb.NewBlogServiceClient(conn)), rec
+}
+
+// Valid values for the path and query parameters of Routes.
+var testParams = map[string]string{
+	"id":          "5f0c4b5d9d8e7f6a5b4c3d2e",
+	"authorId":    "ann",
+	"userId":      "bob",
+	"reaction":    "LIKE",
+	"viewerId":    "carol",
+	"granularity": "DAY",
+	"startTime":   "2021-01-01T00:00:00Z",
+	"endTime":     "2021-01-02T00:00:00Z",
+	"topPosts":    "3",
+	"limit":       "5",
+}
+
+// TestServeHTTPFollowsRoutes checks that ServeHTTP calls the RPC each route
+// names, and that the methods it allows on a path are those of Routes.
+func TestServeHTTPFollowsRoutes(t *testing.T) {
+	h, rec := newTestHandler(t)
+	allowed := make(map[string][]string)
+	for _, r := range Routes {
+		path := pathParam.ReplaceAllStringFunc(r.Path, func(p string) string {
+			return testParams[strings.Trim(p, "{}")]
+		})
+		allowed[path] = append(allowed[path], r.Method)
+		var query []string
+		for _, q := range r.Query {
+			query = append(query, q+"="+testParams[q])
+		}
+		if len(query) > 0 {
+			path += "?" + strings.Join(query, "&")
+		}
+		body := ""
+		switch r.Body {
+		case "":
+		case "blog.Blog":
+			body = `{"title":"Hello"}`
+		default:
+			body = `{}`
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(r.Method, path, strings.NewReader(body)))
+		want := "/blog.BlogService/" + r.RPC
+		if got := rec.take(); len(got) != 1 || got[0] != want {
+			t.Errorf("%s %s called %v, want %s (status %d: %s)", r.Method, path, got, want, w.Code, w.Body)
+		}
+	}
+
+	for path, methods := range allowed {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT %s = %d, want %d", path, w.Code, http.StatusMethodNotAllowed)
+			continue
+		}
+		got := strings.Split(w.Header().Get("Allow"), ", ")
+		sort.Strings(got)
+		sort.Strings(methods)
+		if strings.Join(got, ",") != strings.Join(methods, ",") {
+			t.Errorf("PUT %s allows %v, Routes has %v", path, got, methods)
+		}
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Errorf("disallowed methods called %v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// readQuery sets the fields of m named, by their JSON names, in names from
+// the query parameters of the same name. Enums are accepted by name in any
+// case and timestamps in RFC 3339.
+func readQuery(query url.Values, m proto.Message, names []string) error {
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for _, name := range names {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		f := fields.ByJSONName(name)
+		if f == nil {
+			return status.Errorf(codes.Internal, "Query parameter %s matches no field of %s", name, msg.Descriptor().FullName())
+		}
+		value, err := queryValue(f, v)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid query parameter %s: %v", name, err)
+		}
+		msg.Set(f, value)
+	}
+	return nil
+}
+
+func queryValue(f protoreflect.FieldDescriptor, v string) (protoreflect.Value, error) {
+	switch f.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v), nil
+	case protoreflect.Int32Kind:
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfInt32(int32(n)), nil
+	case protoreflect.EnumKind:
+		ev := f.Enum().Values().ByName(protoreflect.Name(strings.ToUpper(v)))
+		if ev == nil || ev.Number() == 0 {
+			return protoreflect.Value{}, fmt.Errorf("unknown value %q", v)
+		}
+		return protoreflect.ValueOfEnum(ev.Number()), nil
+	case protoreflect.MessageKind:
+		if f.Message().FullName() == "google.protobuf.Timestamp" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field type %v", f.Kind())
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import "net/http"
+
+// Route describes how one BlogService RPC is exposed over HTTP. The table
+// drives the generated OpenAPI document; ServeHTTP implements the same
+// mapping.
+type Route struct {
+	Method string
+	Path   string
+	RPC    string
+	// Operation names the route in the OpenAPI document when RPC is served
+	// by more than one route. It defaults to RPC.
+	Operation string
+	Summary   string
+	// Body is the message the request body decodes into, empty for none.
+	Body string
+	// Response is the message written on success.
+	Response string
+	Status   int
+	// Stream marks responses written as newline delimited JSON.
+	Stream bool
+	// Query lists the optional query parameters, named after request
+	// fields.
+	Query []string
+}
+
+var Routes = []Route{
+	{
+		Method: http.MethodPost, Path: "/v1/blogs", RPC: "CreateBlog",
+		Summary: "Create a blog",
+		Body:    "blog.Blog", Response: "blog.Blog", Status: http.StatusCreated,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/blogs", RPC: "ListBlog",
+		Summary:  "Stream every blog, one JSON object per line",
+		Response: "blog.Blog", Status: http.StatusOK, Stream: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/blogs/{id}", RPC: "ReadBlog",
+		Summary:  "Read a blog",
+		Response: "blog.Blog", Status: http.StatusOK,
+		Query: []string{"viewerId"},
+	},
+	{
+		Method: http.MethodPatch, Path: "/v1/blogs/{id}", RPC: "UpdateBlog",
+		Summary: "Change some fields of a blog",
+		Body:    "blog.Blog", Response: "blog.Blog", Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/blogs/{id}", RPC: "DeleteBlog",
+		Summary:  "Delete a blog",
+		Response: "blog.DeleteBlogResponse", Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/blogs/{id}/reactions", RPC: "ReactToBlog",
+		Summary: "React to a blog; reacting twice with the same reaction changes nothing",
+		Body:    "blog.ReactToBlogRequest", Response: "blog.ReactToBlogResponse", Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/blogs/{id}/reactions", RPC: "ListReactions",
+		Summary:  "Stream who reacted to a blog, oldest first, one JSON object per line",
+		Response: "blog.ListReactionsResponse", Status: http.StatusOK, Stream: true,
+		Query: []string{"reaction"},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/blogs/{id}/reactions/{userId}/{reaction}", RPC: "RemoveReaction",
+		Summary:  "Remove a reaction a user left on a blog",
+		Response: "blog.RemoveReactionResponse", Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/blogs/{id}/analytics", RPC: "GetBlogAnalytics",
+		Operation: "GetBlogAnalyticsByBlog",
+		Summary:   "Views of a blog over time",
+		Response:  "blog.GetBlogAnalyticsResponse", Status: http.StatusOK,
+		Query: analyticsQuery[:3],
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/blogs/{id}/related", RPC: "GetRelatedBlogs",
+		Summary:  "Blogs similar to a blog, most similar first",
+		Response: "blog.GetRelatedBlogsResponse", Status: http.StatusOK,
+		Query: []string{"limit"},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/authors/{authorId}/analytics", RPC: "GetBlogAnalytics",
+		Operation: "GetBlogAnalyticsByAuthor",
+		Summary:   "Views of all blogs of an author over time and their most viewed blogs",
+		Response:  "blog.GetBlogAnalyticsResponse", Status: http.StatusOK,
+		Query: analyticsQuery,
+	},
+}
+
+var analyticsQuery = []string{"granularity", "startTime", "endTime", "topPosts"}
@@ -0,0 +1,369 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/slug"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlogCollection is the collection holding BlogItem documents.
+const BlogCollection = "blog"
+
+// ReactionCollection holds one document per reaction a user left on a blog.
+const ReactionCollection = "blog_reactions"
+
+// Collections written by package analytics: hourly and daily view counts
+// per blog, and which viewers read a blog in each hour.
+const (
+	ViewCountCollection = "blog_view_counts"
+	ViewerCollection    = "blog_viewers"
+)
+
+// Collections written by package webhook: the webhooks of a database and
+// the record of their deliveries.
+const (
+	WebhookCollection         = "blog_webhooks"
+	WebhookDeliveryCollection = "blog_webhook_deliveries"
+)
+
+// Collections written by package outbox: the events waiting to be
+// published, and the counter ordering them with the lease of the relay
+// publishing them.
+const (
+	OutboxCollection      = "blog_outbox"
+	OutboxStateCollection = "blog_outbox_state"
+)
+
+// TenantCollection lists the tenants of a multi-tenant server. It lives in
+// the server's main database; each tenant's blogs live in a database of
+// their own.
+const TenantCollection = "tenants"
+
+// TenantDatabases returns the databases of the tenants listed in db, the
+// main database of a multi-tenant server, ordered by tenant id.
+func TenantDatabases(ctx context.Context, db *mongo.Database) ([]string, error) {
+	cur, err := db.Collection(TenantCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetProjection(bson.M{"database": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var tenants []struct {
+		Database string `bson:"database"`
+	}
+	if err := cur.All(ctx, &tenants); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tenants))
+	for i, t := range tenants {
+		names[i] = t.Database
+	}
+	return names, nil
+}
+
+// All is every blog database migration. Append new migrations with the next
+// version number; never renumber or edit one that has shipped.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "create_blog_author_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(BlogCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "author_id", Value: 1}},
+				Options: options.Index().SetName("author_id_1"),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(BlogCollection).Indexes().DropOne(ctx, "author_id_1")
+			return err
+		},
+	},
+	{
+		Version: 2,
+		Name:    "backfill_blog_slugs",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			coll := db.Collection(BlogCollection)
+			cur, err := coll.Find(ctx, bson.M{"slug": bson.M{"$exists": false}})
+			if err != nil {
+				return err
+			}
+			defer cur.Close(ctx)
+			for cur.Next(ctx) {
+				var doc struct {
+					ID    primitive.ObjectID `bson:"_id"`
+					Title string             `bson:"title"`
+				}
+				if err := cur.Decode(&doc); err != nil {
+					return err
+				}
+				if _, err := coll.UpdateOne(ctx,
+					bson.M{"_id": doc.ID},
+					bson.M{"$set": bson.M{"slug": slug.Make(doc.Title, doc.ID)}},
+				); err != nil {
+					return err
+				}
+			}
+			if err := cur.Err(); err != nil {
+				return err
+			}
+			_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "slug", Value: 1}},
+				Options: options.Index().SetName("slug_1").SetUnique(true).SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			coll := db.Collection(BlogCollection)
+			if _, err := coll.Indexes().DropOne(ctx, "slug_1"); err != nil {
+				return err
+			}
+			_, err := coll.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"slug": ""}})
+			return err
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_blog_reaction_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// The unique index is what allows one reaction of each kind per
+			// user, however many clicks arrive at once.
+			_, err := db.Collection(ReactionCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "reaction", Value: 1}},
+					Options: options.Index().SetName("blog_id_1_user_id_1_reaction_1").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "reacted_at", Value: 1}},
+					Options: options.Index().SetName("blog_id_1_reacted_at_1"),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection(ReactionCollection).Drop(ctx); err != nil {
+				return err
+			}
+			_, err := db.Collection(BlogCollection).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"reactions": ""}})
+			return err
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_blog_analytics_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(ViewCountCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "period", Value: 1}, {Key: "start", Value: 1}},
+					Options: options.Index().SetName("blog_id_1_period_1_start_1").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "author_id", Value: 1}, {Key: "period", Value: 1}, {Key: "start", Value: 1}},
+					Options: options.Index().SetName("author_id_1_period_1_start_1"),
+				},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection(ViewerCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "hour", Value: 1}, {Key: "viewer", Value: 1}},
+					Options: options.Index().SetName("blog_id_1_hour_1_viewer_1").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "author_id", Value: 1}, {Key: "hour", Value: 1}},
+					Options: options.Index().SetName("author_id_1_hour_1"),
+				},
+				{
+					// Each document carries its own expiry, so the retention
+					// can be changed without a migration.
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection(ViewCountCollection).Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection(ViewerCollection).Drop(ctx)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_blog_tags_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Serves the per tag feeds, which list the newest blogs first.
+			_, err := db.Collection(BlogCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "tags", Value: 1}, {Key: "_id", Value: -1}},
+				Options: options.Index().SetName("tags_1__id_-1"),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(BlogCollection).Indexes().DropOne(ctx, "tags_1__id_-1")
+			return err
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_blog_attachment_hash_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Finds whether any blog still references attachment content
+			// before it is deleted.
+			_, err := db.Collection(BlogCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "attachments.sha256", Value: 1}},
+				Options: options.Index().SetName("attachments.sha256_1").SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(BlogCollection).Indexes().DropOne(ctx, "attachments.sha256_1")
+			return err
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_blog_thumbnail_hash_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// The same check for thumbnails, which are kept like attachments.
+			_, err := db.Collection(BlogCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "attachments.thumbnails.sha256", Value: 1}},
+				Options: options.Index().SetName("attachments.thumbnails.sha256_1").SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(BlogCollection).Indexes().DropOne(ctx, "attachments.thumbnails.sha256_1")
+			return err
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_webhook_delivery_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(WebhookDeliveryCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					// Lists the latest deliveries to a webhook.
+					Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}},
+					Options: options.Index().SetName("webhook_id_1__id_-1"),
+				},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(WebhookDeliveryCollection).Drop(ctx)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_outbox_collections",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Transactions on Mongo before 4.4 cannot create collections, so
+			// the one written only within them is created up front.
+			err := db.CreateCollection(ctx, OutboxStateCollection)
+			if e, ok := err.(mongo.CommandError); ok && e.Name == "NamespaceExists" {
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection(OutboxCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					// Finds the pending entries in order.
+					Keys:    bson.D{{Key: "delivered", Value: 1}, {Key: "seq", Value: 1}},
+					Options: options.Index().SetName("delivered_1_seq_1"),
+				},
+				{
+					// Removes delivered entries after their retention.
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection(OutboxCollection).Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection(OutboxStateCollection).Drop(ctx)
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_webhook_slot_and_pending_delivery_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Webhooks registered so far take the first slots, oldest first.
+			webhooks := db.Collection(WebhookCollection)
+			cur, err := webhooks.Find(ctx, bson.M{}, options.Find().
+				SetSort(bson.D{{Key: "_id", Value: 1}}).
+				SetProjection(bson.M{"_id": 1}))
+			if err != nil {
+				return err
+			}
+			var ids []struct {
+				ID interface{} `bson:"_id"`
+			}
+			if err := cur.All(ctx, &ids); err != nil {
+				return err
+			}
+			for i, doc := range ids {
+				if _, err := webhooks.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"slot": i}}); err != nil {
+					return err
+				}
+			}
+			// Keeps concurrent registrations from exceeding the limit.
+			_, err = webhooks.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "slot", Value: 1}},
+				Options: options.Index().SetName("slot_1").SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+			// Finds the pending deliveries left behind by a dispatcher.
+			_, err = db.Collection(WebhookDeliveryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "next_attempt_at", Value: 1}},
+				Options: options.Index().SetName("next_attempt_at_1_pending").
+					SetPartialFilterExpression(bson.M{"status": "pending"}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection(WebhookDeliveryCollection).Indexes().DropOne(ctx, "next_attempt_at_1_pending"); err != nil {
+				return err
+			}
+			webhooks := db.Collection(WebhookCollection)
+			if _, err := webhooks.Indexes().DropOne(ctx, "slot_1"); err != nil {
+				return err
+			}
+			_, err := webhooks.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"slot": ""}})
+			return err
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_webhook_delivery_event_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Finds the delivery of an event to a webhook when it is
+			// enqueued. Not unique: events published at least once may
+			// have been delivered twice before.
+			_, err := db.Collection(WebhookDeliveryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "webhook_id", Value: 1}},
+				Options: options.Index().SetName("event_id_1_webhook_id_1"),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(WebhookDeliveryCollection).Indexes().DropOne(ctx, "event_id_1_webhook_id_1")
+			return err
+		},
+	},
+}
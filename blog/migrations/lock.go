@@ -0,0 +1,144 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	lockID = "migrations"
+	// lockLease is how long a lock survives without being renewed, so a
+	// crashed migrator does not block others forever.
+	lockLease = 2 * time.Minute
+	// lockRetry is how often a waiting process retries the lock.
+	lockRetry = 2 * time.Second
+)
+
+type lock struct {
+	collection *mongo.Collection
+	owner      string
+}
+
+type lockDoc struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func newLock(collection *mongo.Collection) *lock {
+	return &lock{collection: collection, owner: primitive.NewObjectID().Hex()}
+}
+
+// with runs fn while holding the lock, waiting for another holder to finish
+// or for its lease to expire. The lease is renewed while fn runs; should a
+// renewal fail, fn's context is cancelled, as another process may take the
+// lock over and migrate at the same time.
+func (l *lock) with(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	// renewErr is set before renewed is closed.
+	var renewErr error
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.renew(ctx); err != nil && ctx.Err() == nil {
+					renewErr = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := fn(ctx)
+	cancel()
+	<-renewed
+	if relErr := l.release(); relErr != nil {
+		log.Printf("Failed to release migration lock %v", relErr)
+	}
+	if renewErr != nil {
+		return fmt.Errorf("migration stopped, cannot renew the migration lock: %v", renewErr)
+	}
+	return err
+}
+
+func (l *lock) acquire(ctx context.Context) error {
+	waiting := false
+	for {
+		ok, err := l.tryAcquire(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if !waiting {
+			log.Println("Waiting for another instance to finish migrating")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+func (l *lock) tryAcquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	// Take over a lock whose lease has run out.
+	res, err := l.collection.UpdateOne(ctx,
+		bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": l.owner, "expires_at": now.Add(lockLease)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.ModifiedCount == 1 {
+		return true, nil
+	}
+	_, err = l.collection.InsertOne(ctx, lockDoc{ID: lockID, Owner: l.owner, ExpiresAt: now.Add(lockLease)})
+	if err == nil {
+		return true, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (l *lock) renew(ctx context.Context) error {
+	res, err := l.collection.UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": l.owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(lockLease)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.New("lock lost")
+	}
+	return nil
+}
+
+func (l *lock) release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := l.collection.DeleteOne(ctx, bson.M{"_id": lockID, "owner": l.owner})
+	return err
+}
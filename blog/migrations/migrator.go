@@ -0,0 +1,166 @@
+// Package migrations applies ordered, versioned schema changes to the blog
+// database. Applied versions are recorded in the schema_migrations
+// collection and a lease based lock makes sure only one process migrates at
+// a time.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "schema_migrations"
+	lockCollection       = "schema_migrations_lock"
+)
+
+// Migration is a single schema change. Down may be nil for changes that
+// cannot be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+type record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Status describes one known migration.
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	lock       *lock
+}
+
+// New returns a Migrator for db. It panics if two migrations share a version,
+// since that is a programming error.
+func New(db *mongo.Database, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("migrations: duplicate version %d", sorted[i].Version))
+		}
+	}
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+		lock:       newLock(db.Collection(lockCollection)),
+	}
+}
+
+// Up applies every pending migration in version order and returns the ones
+// it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.lock.with(ctx, func(ctx context.Context) error {
+		done, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			log.Printf("Applying migration %d %s", mig.Version, mig.Name)
+			if err := mig.Up(ctx, m.db); err != nil {
+				return fmt.Errorf("migration %d %s: %v", mig.Version, mig.Name, err)
+			}
+			if _, err := m.db.Collection(migrationsCollection).InsertOne(ctx, record{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				AppliedAt: time.Now().UTC(),
+			}); err != nil {
+				return fmt.Errorf("recording migration %d: %v", mig.Version, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.lock.with(ctx, func(ctx context.Context) error {
+		done, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == nil {
+				return fmt.Errorf("migration %d %s cannot be reverted", mig.Version, mig.Name)
+			}
+			log.Printf("Reverting migration %d %s", mig.Version, mig.Name)
+			if err := mig.Down(ctx, m.db); err != nil {
+				return fmt.Errorf("reverting migration %d %s: %v", mig.Version, mig.Name, err)
+			}
+			if _, err := m.db.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"_id": mig.Version}); err != nil {
+				return fmt.Errorf("unrecording migration %d: %v", mig.Version, err)
+			}
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if rec, ok := done[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = rec.AppliedAt
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cur, err := m.db.Collection(migrationsCollection).Find(ctx, bson.D{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	done := make(map[int]record)
+	for cur.Next(ctx) {
+		var rec record
+		if err := cur.Decode(&rec); err != nil {
+			return nil, err
+		}
+		done[rec.Version] = rec
+	}
+	return done, cur.Err()
+}
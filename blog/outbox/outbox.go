@@ -0,0 +1,107 @@
+// Package outbox publishes blog events reliably with a transactional
+// outbox.
+//
+// An event is written to the outbox collection in the same Mongo
+// transaction as the change it describes, so either both are stored or
+// neither is. A Relay then reads the entries not yet delivered in the order
+// they were committed, hands each to a Publisher and marks it delivered.
+// An entry published just before a crash is published again once the
+// relay restarts, so delivery is at least once and consumers should
+// ignore entry ids they have already seen.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Entry is an event waiting in, or delivered from, the outbox.
+type Entry struct {
+	// ID identifies the entry; it stays the same when it is published
+	// again.
+	ID primitive.ObjectID `bson:"_id" json:"id"`
+	// Seq orders the entries of a database in the order their
+	// transactions committed.
+	Seq  int64  `bson:"seq" json:"seq"`
+	Type string `bson:"type" json:"type"`
+	// Key identifies what the event is about, such as a blog id.
+	Key string `bson:"key" json:"key"`
+	// Payload is the JSON encoded event.
+	Payload   json.RawMessage `bson:"payload" json:"payload"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+	Delivered bool            `bson:"delivered" json:"-"`
+	// ExpiresAt is when a delivered entry is removed.
+	ExpiresAt time.Time `bson:"expires_at,omitempty" json:"-"`
+}
+
+// Publisher hands entries on to whoever consumes the events. Publish must
+// not return before the entry is safely with the consumer: once it
+// returns nil the entry is marked delivered and not published again.
+type Publisher interface {
+	Publish(ctx context.Context, e *Entry) error
+}
+
+// Memory is a Publisher keeping the entries it is given in memory, for
+// tests and programs consuming events in process. It is safe for
+// concurrent use.
+type Memory struct {
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+func (m *Memory) Publish(_ context.Context, e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+// Entries returns the entries published so far, oldest first.
+func (m *Memory) Entries() []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Entry(nil), m.entries...)
+}
+
+// File is a Publisher appending each entry to a file as a line of JSON.
+// Every line is synced to disk before Publish returns. It is safe for
+// concurrent use.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens the file at path for appending, creating it if needed.
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+func (f *File) Publish(_ context.Context, e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.f.Sync()
+}
+
+// Close closes the file. The context is accepted so Close can be
+// registered as a shutdown hook.
+func (f *File) Close(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
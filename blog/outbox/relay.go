@@ -0,0 +1,203 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Options configures a Relay.
+type Options struct {
+	// BatchSize is how many entries are read at a time.
+	BatchSize int
+	// Interval is how often the outbox is checked when nothing calls
+	// Notify, which also picks up entries written by other replicas.
+	Interval time.Duration
+	// Timeout bounds publishing one entry.
+	Timeout time.Duration
+	// Backoff is the wait after a failed publish before it is retried,
+	// doubled after each further failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long a relay may publish after last renewing its
+	// lease, which it does before every entry, so it must be longer than
+	// Timeout. Among replicas sharing a database only the holder
+	// publishes, which keeps entries in order.
+	Lease time.Duration
+}
+
+// Relay publishes the entries of an outbox in the background, one at a
+// time in Seq order. An entry that fails to publish is retried with
+// backoff, and the entries after it wait, so none overtakes another.
+type Relay struct {
+	store Store
+	pub   Publisher
+	opts  Options
+	owner string
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+	// failures counts the failed attempts in a row; only run touches it.
+	failures int
+}
+
+// NewRelay starts a Relay publishing the entries of store to pub. Call
+// Close to stop it.
+func NewRelay(store Store, pub Publisher, opts Options) *Relay {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Relay{
+		store:  store,
+		pub:    pub,
+		opts:   opts,
+		owner:  primitive.NewObjectID().Hex(),
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Notify wakes the relay after an entry was committed, so it is published
+// without waiting for the next check. It never blocks and is a no-op on a
+// nil *Relay.
+func (r *Relay) Notify() {
+	if r == nil {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+	for {
+		wait := r.opts.Interval
+		wake := r.wake
+		if failures := r.relay(ctx); failures > 0 {
+			// Ignore Notify while backing off, so a failing publisher is
+			// not retried on every write.
+			wait = r.backoff(failures)
+			wake = nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// relay publishes pending entries until there are none left, one fails or
+// the lease is lost, and returns how many times in a row relaying has
+// failed.
+func (r *Relay) relay(ctx context.Context) int {
+	for ctx.Err() == nil {
+		ok, err := r.acquire(ctx)
+		if err != nil {
+			return r.failed()
+		}
+		if !ok {
+			break
+		}
+		entries, err := r.store.Pending(ctx, r.opts.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to read outbox", "err", err)
+			}
+			return r.failed()
+		}
+		for i, e := range entries {
+			// Renewed before every entry, so the lease cannot run out
+			// while a batch of slow publishes is under way.
+			if i > 0 {
+				if ok, err = r.acquire(ctx); err != nil {
+					return r.failed()
+				}
+				if !ok {
+					break
+				}
+			}
+			err := r.publish(ctx, e)
+			if errors.Is(err, ErrLeaseLost) {
+				slog.Warn("lost the outbox lease while publishing", "entry_id", e.ID.Hex(), "seq", e.Seq)
+				ok = false
+				break
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("failed to publish outbox entry", "entry_id", e.ID.Hex(), "seq", e.Seq, "type", e.Type, "err", err)
+				}
+				return r.failed()
+			}
+		}
+		if !ok || len(entries) < r.opts.BatchSize {
+			break
+		}
+	}
+	r.failures = 0
+	return 0
+}
+
+// acquire takes or renews the lease, reporting whether the relay holds it.
+func (r *Relay) acquire(ctx context.Context) (bool, error) {
+	ok, err := r.store.Acquire(ctx, r.owner, r.opts.Lease)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("failed to acquire outbox lease", "err", err)
+	}
+	return ok, err
+}
+
+func (r *Relay) publish(ctx context.Context, e *Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+	if err := r.pub.Publish(ctx, e); err != nil {
+		return err
+	}
+	// Should this fail, the entry is published again: at least once.
+	return r.store.MarkDelivered(ctx, e.ID, r.owner)
+}
+
+func (r *Relay) failed() int {
+	r.failures++
+	return r.failures
+}
+
+// backoff returns the wait after n failures in a row, n > 0.
+func (r *Relay) backoff(n int) time.Duration {
+	b := r.opts.Backoff
+	for i := 1; i < n && b < r.opts.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > r.opts.MaxBackoff {
+		b = r.opts.MaxBackoff
+	}
+	return b
+}
+
+// Close stops the relay, cutting short the entry being published, which is
+// then published again by the next relay. It waits until ctx is done at
+// most. It is a no-op on a nil *Relay.
+func (r *Relay) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
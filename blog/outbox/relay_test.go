@@ -0,0 +1,269 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore is a Store kept in memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries []*Entry
+	holder  string
+	expires time.Time
+}
+
+// newMemoryStore returns a store holding undelivered entries with the
+// given sequence numbers, which must be in increasing order.
+func newMemoryStore(seqs ...int64) *memoryStore {
+	s := &memoryStore{}
+	for _, seq := range seqs {
+		s.entries = append(s.entries, &Entry{ID: primitive.NewObjectID(), Seq: seq, Type: "blog.created"})
+	}
+	return s
+}
+
+func (s *memoryStore) Pending(_ context.Context, limit int) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []*Entry
+	for _, e := range s.entries {
+		if !e.Delivered && len(pending) < limit {
+			cp := *e
+			pending = append(pending, &cp)
+		}
+	}
+	return pending, nil
+}
+
+func (s *memoryStore) MarkDelivered(_ context.Context, id primitive.ObjectID, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holder != owner || time.Now().After(s.expires) {
+		return ErrLeaseLost
+	}
+	for _, e := range s.entries {
+		if e.ID == id {
+			e.Delivered = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Acquire(_ context.Context, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.holder != "" && s.holder != owner && now.Before(s.expires) {
+		return false, nil
+	}
+	s.holder, s.expires = owner, now.Add(ttl)
+	return true, nil
+}
+
+// setHolder hands the lease to owner for an hour, or frees it when owner
+// is empty.
+func (s *memoryStore) setHolder(owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holder, s.expires = owner, time.Now().Add(time.Hour)
+}
+
+// pending returns the sequence numbers of the undelivered entries.
+func (s *memoryStore) pending() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var seqs []int64
+	for _, e := range s.entries {
+		if !e.Delivered {
+			seqs = append(seqs, e.Seq)
+		}
+	}
+	return seqs
+}
+
+// recorder is a Publisher recording the sequence number of every entry it
+// is given. fn, when set, is called first and fails the publish with its
+// error.
+type recorder struct {
+	mu   sync.Mutex
+	seqs []int64
+	fn   func(e *Entry) error
+}
+
+func (p *recorder) Publish(_ context.Context, e *Entry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fn != nil {
+		if err := p.fn(e); err != nil {
+			return err
+		}
+	}
+	p.seqs = append(p.seqs, e.Seq)
+	return nil
+}
+
+func (p *recorder) published() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int64(nil), p.seqs...)
+}
+
+func testOptions() Options {
+	return Options{
+		BatchSize:  2,
+		Interval:   time.Hour,
+		Timeout:    time.Second,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		Lease:      time.Minute,
+	}
+}
+
+// waitFor fails t unless cond holds within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func equalSeqs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func closeRelay(t *testing.T, r *Relay) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Close(ctx); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+}
+
+func TestRelayPublishesInOrder(t *testing.T) {
+	store := newMemoryStore(1, 2, 3, 4, 5)
+	pub := &recorder{}
+	r := NewRelay(store, pub, testOptions())
+	defer closeRelay(t, r)
+
+	// Five entries take three batches of two.
+	waitFor(t, "every entry to be delivered", func() bool { return len(store.pending()) == 0 })
+	if got := pub.published(); !equalSeqs(got, []int64{1, 2, 3, 4, 5}) {
+		t.Errorf("published %v, want 1 to 5 in order", got)
+	}
+}
+
+func TestRelayPublishesOnNotify(t *testing.T) {
+	store := newMemoryStore()
+	pub := &recorder{}
+	r := NewRelay(store, pub, testOptions())
+	defer closeRelay(t, r)
+
+	store.mu.Lock()
+	store.entries = append(store.entries, &Entry{ID: primitive.NewObjectID(), Seq: 1})
+	store.mu.Unlock()
+	r.Notify()
+	waitFor(t, "the entry to be delivered", func() bool { return len(store.pending()) == 0 })
+}
+
+func TestRelayRetriesWithoutReordering(t *testing.T) {
+	store := newMemoryStore(1, 2, 3)
+	failures := 3
+	pub := &recorder{fn: func(e *Entry) error {
+		if e.Seq == 2 && failures > 0 {
+			failures--
+			return errors.New("consumer unavailable")
+		}
+		return nil
+	}}
+	r := NewRelay(store, pub, testOptions())
+	defer closeRelay(t, r)
+
+	waitFor(t, "every entry to be delivered", func() bool { return len(store.pending()) == 0 })
+	// Entry 3 waited for entry 2 instead of overtaking it.
+	if got := pub.published(); !equalSeqs(got, []int64{1, 2, 3}) {
+		t.Errorf("published %v, want 1, 2, 3", got)
+	}
+}
+
+func TestRelayWaitsForLease(t *testing.T) {
+	store := newMemoryStore(1)
+	store.setHolder("other replica")
+	pub := &recorder{}
+	opts := testOptions()
+	opts.Interval = time.Millisecond
+	r := NewRelay(store, pub, opts)
+	defer closeRelay(t, r)
+
+	time.Sleep(20 * time.Millisecond)
+	if got := pub.published(); len(got) != 0 {
+		t.Fatalf("published %v while another replica held the lease", got)
+	}
+	store.setHolder("")
+	waitFor(t, "the entry to be delivered", func() bool { return len(store.pending()) == 0 })
+}
+
+func TestRelayStopsWhenLeaseIsLost(t *testing.T) {
+	store := newMemoryStore(1, 2, 3)
+	pub := &recorder{}
+	pub.fn = func(e *Entry) error {
+		if e.Seq == 2 {
+			// Another replica takes over while entry 2 is published,
+			// say after this relay stalled past its lease.
+			store.setHolder("other replica")
+		}
+		return nil
+	}
+	r := NewRelay(store, pub, testOptions())
+
+	waitFor(t, "entry 2 to be published", func() bool { return len(pub.published()) == 2 })
+	closeRelay(t, r)
+	if got := pub.published(); !equalSeqs(got, []int64{1, 2}) {
+		t.Errorf("published %v, want 1 and 2", got)
+	}
+	// Entry 2 is left for the new holder to publish again.
+	if got := store.pending(); !equalSeqs(got, []int64{2, 3}) {
+		t.Errorf("pending %v, want 2 and 3", got)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := &Relay{opts: Options{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}}
+	for n, want := range map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  40 * time.Millisecond,
+		4:  50 * time.Millisecond,
+		10: 50 * time.Millisecond,
+	} {
+		if got := r.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestRelayCloseIsNilSafe(t *testing.T) {
+	var r *Relay
+	r.Notify()
+	if err := r.Close(context.Background()); err != nil {
+		t.Errorf("Close() of a nil relay = %v", err)
+	}
+}
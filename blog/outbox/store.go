@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Documents of the state collection.
+const (
+	// seqID is the counter entries take their Seq from.
+	seqID = "seq"
+	// leaseID records which relay may publish, and until when.
+	leaseID = "relay"
+)
+
+// ErrLeaseLost is returned by MarkDelivered when the lease is no longer
+// held by the relay marking the entry.
+var ErrLeaseLost = errors.New("outbox lease lost")
+
+// Store is what a Relay needs of the outbox.
+type Store interface {
+	// Pending returns up to limit undelivered entries, lowest Seq first.
+	Pending(ctx context.Context, limit int) ([]*Entry, error)
+	// MarkDelivered records that entry id was published by owner, or fails
+	// with ErrLeaseLost when owner no longer holds the lease.
+	MarkDelivered(ctx context.Context, id primitive.ObjectID, owner string) error
+	// Acquire takes or renews, for ttl, the lease allowing owner alone to
+	// publish. ok is false while another owner holds it.
+	Acquire(ctx context.Context, owner string, ttl time.Duration) (ok bool, err error)
+}
+
+// MongoStore is the Store kept in the outbox collections of a blog
+// database.
+type MongoStore struct {
+	entries   *mongo.Collection
+	state     *mongo.Collection
+	timeout   time.Duration
+	retention time.Duration
+	metrics   *metrics.MongoMetrics
+}
+
+// NewMongoStore returns a MongoStore in db. Each operation may take at
+// most timeout, and delivered entries are kept for retention. m may be
+// nil.
+func NewMongoStore(db *mongo.Database, timeout, retention time.Duration, m *metrics.MongoMetrics) *MongoStore {
+	return &MongoStore{
+		entries:   db.Collection(migrations.OutboxCollection),
+		state:     db.Collection(migrations.OutboxStateCollection),
+		timeout:   timeout,
+		retention: retention,
+		metrics:   m,
+	}
+}
+
+// Add writes e to the outbox, setting its Seq. It must be called in the
+// transaction of the change e describes, ctx being the transaction's
+// session context. Concurrent transactions conflict on the counter, so Seq
+// follows commit order.
+func (s *MongoStore) Add(ctx context.Context, e *Entry) (err error) {
+	done := s.metrics.Start(s.state.Name(), "update")
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err = s.state.FindOneAndUpdate(ctx,
+		bson.M{"_id": seqID},
+		bson.M{"$inc": bson.M{"value": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	done(err)
+	if err != nil {
+		return err
+	}
+	e.Seq = counter.Value
+	done = s.metrics.Start(s.entries.Name(), "insert")
+	defer func() { done(err) }()
+	_, err = s.entries.InsertOne(ctx, e)
+	return err
+}
+
+func (s *MongoStore) Pending(ctx context.Context, limit int) (_ []*Entry, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.entries.Name(), "find")
+	defer func() { done(err) }()
+	cur, err := s.entries.Find(ctx, bson.M{"delivered": false}, options.Find().
+		SetSort(bson.D{{Key: "seq", Value: 1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MarkDelivered checks the lease and marks the entry in one transaction.
+// Checking it writes the lease document, so a relay taking the lease over
+// meanwhile conflicts with the transaction rather than both going ahead.
+func (s *MongoStore) MarkDelivered(ctx context.Context, id primitive.ObjectID, owner string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.entries.Name(), "update")
+	defer func() { done(err) }()
+	sess, err := s.entries.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now().UTC()
+		res, err := s.state.UpdateOne(sc,
+			bson.M{"_id": leaseID, "owner": owner, "expires_at": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"marked_at": now}})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, ErrLeaseLost
+		}
+		_, err = s.entries.UpdateOne(sc, bson.M{"_id": id}, bson.M{"$set": bson.M{
+			"delivered":  true,
+			"expires_at": now.Add(s.retention),
+		}})
+		return nil, err
+	})
+	return err
+}
+
+func (s *MongoStore) Acquire(ctx context.Context, owner string, ttl time.Duration) (_ bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.state.Name(), "update")
+	defer func() { done(err) }()
+	now := time.Now().UTC()
+	// The filter matches a lease that is ours or has run out. Otherwise the
+	// upsert collides with the lease document and someone else holds it.
+	_, err = s.state.UpdateOne(ctx,
+		bson.M{"_id": leaseID, "$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		}},
+		bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,407 @@
+// Package related finds the blogs most similar to a blog.
+//
+// Every blog is a TF-IDF vector over the words of its title, content and
+// tags, and blogs are compared by the cosine of their vectors. Words of the
+// title count more than those of the content, and tags more than either.
+// The index lives in memory: it is built from the store in the background,
+// kept up to date as blogs change and rebuilt now and then to pick up the
+// changes made by other replicas.
+package related
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Weights of a word by where it appears.
+const (
+	titleWeight   = 2
+	contentWeight = 1
+	tagWeight     = 3
+)
+
+// rebuildRetry is the wait before retrying a failed rebuild, unless the
+// rebuild interval is shorter.
+const rebuildRetry = 30 * time.Second
+
+// stopwords are too common to tell blogs apart.
+var stopwords = toSet(`a about after all also an and any are as at be because been but by can
+could did do does for from had has have he her his how i if in into is it its just me more
+most my no not of on one or our out she so some than that the their them then there these
+they this to up us was we were what when which who will with would you your`)
+
+func toSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// Document is the text of a blog.
+type Document struct {
+	ID      string
+	Title   string
+	Content string
+	Tags    []string
+}
+
+// Match is a blog similar to another.
+type Match struct {
+	ID string
+	// Score is the cosine similarity of the two blogs, in (0, 1].
+	Score float64
+}
+
+// Scanner calls fn for every document in the store, stopping at the first
+// error.
+type Scanner func(ctx context.Context, fn func(Document) error) error
+
+// Options configures an Index.
+type Options struct {
+	// RebuildInterval is how often the index is rebuilt from the store.
+	RebuildInterval time.Duration
+	// RebuildTimeout bounds each rebuild, scanning the store included.
+	RebuildTimeout time.Duration
+}
+
+// Index finds similar blogs. It is safe for concurrent use; a nil *Index
+// indexes nothing.
+type Index struct {
+	scan Scanner
+	opts Options
+
+	mu    sync.RWMutex
+	terms *terms
+	ready bool
+	// changes, while a rebuild scans the store, records the changes made
+	// meanwhile so they can be applied to the rebuilt index.
+	changes *[]change
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// change is an Add, or a Remove when doc is nil.
+type change struct {
+	id  string
+	doc *Document
+}
+
+// New starts an Index built from scan in the background. It answers
+// nothing until the first build is done. Call Close to stop rebuilding.
+func New(scan Scanner, opts Options) *Index {
+	ctx, cancel := context.WithCancel(context.Background())
+	x := &Index{
+		scan:   scan,
+		opts:   opts,
+		terms:  newTerms(),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go x.run(ctx)
+	return x
+}
+
+func (x *Index) run(ctx context.Context) {
+	defer close(x.done)
+	for {
+		wait := x.opts.RebuildInterval
+		start := time.Now()
+		rctx, cancel := context.WithTimeout(ctx, x.opts.RebuildTimeout)
+		err := x.Rebuild(rctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("failed to build related blogs index", "err", err)
+			if wait > rebuildRetry {
+				wait = rebuildRetry
+			}
+		} else {
+			slog.Debug("built related blogs index", "blogs", x.Len(), "took", time.Since(start))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Rebuild replaces the index with one built from the store. Changes made
+// while the store is scanned are applied to the new index before it
+// replaces the old one, so none is lost. It returns at once when another
+// rebuild is under way.
+func (x *Index) Rebuild(ctx context.Context) error {
+	x.mu.Lock()
+	if x.changes != nil {
+		x.mu.Unlock()
+		return nil
+	}
+	changes := []change{}
+	x.changes = &changes
+	x.mu.Unlock()
+
+	fresh := newTerms()
+	err := x.scan(ctx, func(d Document) error {
+		fresh.add(d)
+		return nil
+	})
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.changes = nil
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if c.doc == nil {
+			fresh.remove(c.id)
+		} else {
+			fresh.add(*c.doc)
+		}
+	}
+	x.terms = fresh
+	x.ready = true
+	return nil
+}
+
+// Ready reports whether the index has been built.
+func (x *Index) Ready() bool {
+	if x == nil {
+		return false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.ready
+}
+
+// Len returns how many blogs are indexed.
+func (x *Index) Len() int {
+	if x == nil {
+		return 0
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.terms.docs)
+}
+
+// Add indexes d, replacing any earlier version of it.
+func (x *Index) Add(d Document) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.terms.add(d)
+	if x.changes != nil {
+		*x.changes = append(*x.changes, change{id: d.ID, doc: &d})
+	}
+}
+
+// Remove drops blog id from the index.
+func (x *Index) Remove(id string) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.terms.remove(id)
+	if x.changes != nil {
+		*x.changes = append(*x.changes, change{id: id})
+	}
+}
+
+// Similar returns up to n blogs most similar to blog id, most similar
+// first. ok is false when id is not indexed.
+func (x *Index) Similar(id string, n int) (_ []Match, ok bool) {
+	if x == nil {
+		return nil, false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.terms.similar(id, n)
+}
+
+// Close stops rebuilding the index, waiting until ctx is done at most. The
+// index keeps answering. It is a no-op on a nil *Index.
+func (x *Index) Close(ctx context.Context) error {
+	if x == nil {
+		return nil
+	}
+	x.cancel()
+	select {
+	case <-x.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// terms holds the term frequencies of every document. IDF is worked out
+// when the index is queried, as every change moves it.
+type terms struct {
+	// docs maps a document to the weighted count of each of its terms.
+	docs map[string]map[string]float64
+	// postings maps a term to the documents that have it; its length is
+	// the term's document frequency.
+	postings map[string]map[string]struct{}
+
+	// norms caches the norm of each document's vector once a query has
+	// worked it out. Queries share the index's read lock, so they fill it
+	// under normsMu; every change empties it, as it moves the IDF of
+	// every term.
+	normsMu sync.Mutex
+	norms   map[string]float64
+}
+
+func newTerms() *terms {
+	return &terms{
+		docs:     make(map[string]map[string]float64),
+		postings: make(map[string]map[string]struct{}),
+		norms:    make(map[string]float64),
+	}
+}
+
+func (t *terms) add(d Document) {
+	t.remove(d.ID)
+	t.norms = make(map[string]float64)
+	counts := make(map[string]float64)
+	for _, w := range tokenize(d.Title) {
+		counts[w] += titleWeight
+	}
+	for _, w := range tokenize(d.Content) {
+		counts[w] += contentWeight
+	}
+	for _, tag := range d.Tags {
+		// Kept apart from words, so a tag only matches the same tag.
+		counts["#"+strings.ToLower(tag)] += tagWeight
+	}
+	t.docs[d.ID] = counts
+	for term := range counts {
+		p, ok := t.postings[term]
+		if !ok {
+			p = make(map[string]struct{})
+			t.postings[term] = p
+		}
+		p[d.ID] = struct{}{}
+	}
+}
+
+func (t *terms) remove(id string) {
+	counts, ok := t.docs[id]
+	if !ok {
+		return
+	}
+	delete(t.docs, id)
+	t.norms = make(map[string]float64)
+	for term := range counts {
+		p := t.postings[term]
+		delete(p, id)
+		if len(p) == 0 {
+			delete(t.postings, term)
+		}
+	}
+}
+
+func (t *terms) similar(id string, n int) ([]Match, bool) {
+	counts, ok := t.docs[id]
+	if !ok {
+		return nil, false
+	}
+	v := t.vector(counts)
+	vNorm := t.norm(id)
+	if vNorm == 0 {
+		return nil, true
+	}
+	// Only documents sharing a term can score above zero.
+	dots := make(map[string]float64)
+	for term, weight := range v {
+		for other := range t.postings[term] {
+			if other != id {
+				dots[other] += weight * t.weight(term, t.docs[other][term])
+			}
+		}
+	}
+	matches := make([]Match, 0, len(dots))
+	for other, dot := range dots {
+		if dot <= 0 {
+			continue
+		}
+		matches = append(matches, Match{ID: other, Score: dot / (vNorm * t.norm(other))})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches, true
+}
+
+// vector returns the TF-IDF vector of a document with the given counts.
+func (t *terms) vector(counts map[string]float64) map[string]float64 {
+	v := make(map[string]float64, len(counts))
+	for term, c := range counts {
+		v[term] = t.weight(term, c)
+	}
+	return v
+}
+
+// weight is the TF-IDF weight of a term with weighted count c, using a
+// dampened term frequency and a smoothed inverse document frequency.
+func (t *terms) weight(term string, c float64) float64 {
+	if c <= 0 {
+		return 0
+	}
+	tf := 1 + math.Log(c)
+	idf := math.Log(1 + float64(len(t.docs))/float64(len(t.postings[term])))
+	return tf * idf
+}
+
+// norm returns the norm of the TF-IDF vector of document id.
+func (t *terms) norm(id string) float64 {
+	t.normsMu.Lock()
+	n, ok := t.norms[id]
+	t.normsMu.Unlock()
+	if ok {
+		return n
+	}
+	var sum float64
+	for term, c := range t.docs[id] {
+		w := t.weight(term, c)
+		sum += w * w
+	}
+	n = math.Sqrt(sum)
+	t.normsMu.Lock()
+	t.norms[id] = n
+	t.normsMu.Unlock()
+	return n
+}
+
+// tokenize splits text into lower case words, leaving out stopwords and
+// single characters.
+func tokenize(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) > 1 && !stopwords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
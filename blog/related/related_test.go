@@ -0,0 +1,262 @@
+package related
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func matchIDs(matches []Match) []string {
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// built returns an index of docs without starting the background rebuild.
+func built(docs ...Document) *Index {
+	x := &Index{terms: newTerms(), ready: true}
+	for _, d := range docs {
+		x.Add(d)
+	}
+	return x
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("The Go-routines, and I/O: a GUIDE to Go 1.22!")
+	want := []string{"go", "routines", "guide", "go", "22"}
+	if !equalIDs(got, want) {
+		t.Errorf("tokenize() = %q, want %q", got, want)
+	}
+}
+
+func TestSimilarScore(t *testing.T) {
+	x := built(
+		Document{ID: "a", Title: "Golang"},
+		Document{ID: "b", Title: "Golang", Content: "channels"},
+		Document{ID: "c", Title: "Python"},
+	)
+	matches, ok := x.Similar("a", 10)
+	if !ok || len(matches) != 1 || matches[0].ID != "b" {
+		t.Fatalf("Similar(a) = %+v, %v, want b alone", matches, ok)
+	}
+	// golang is in 2 of 3 blogs with a title weight of 2, channels in 1
+	// with a content weight of 1.
+	golang := (1 + math.Log(2)) * math.Log(1+3.0/2)
+	channels := math.Log(1 + 3.0/1)
+	want := golang / math.Sqrt(golang*golang+channels*channels)
+	if math.Abs(matches[0].Score-want) > 1e-9 {
+		t.Errorf("score = %v, want %v", matches[0].Score, want)
+	}
+}
+
+func TestSimilarRanking(t *testing.T) {
+	x := built(
+		Document{ID: "query", Title: "Concurrency in Go", Content: "goroutines and channels", Tags: []string{"go"}},
+		Document{ID: "same", Title: "Concurrency in Go", Content: "goroutines and channels", Tags: []string{"Go"}},
+		Document{ID: "tagged", Title: "Generics", Content: "type parameters", Tags: []string{"go"}},
+		Document{ID: "content", Title: "Pipelines", Content: "channels everywhere"},
+		Document{ID: "unrelated", Title: "Sourdough", Content: "flour and water"},
+	)
+	matches, ok := x.Similar("query", 10)
+	if !ok {
+		t.Fatal("Similar() of an indexed blog reported it missing")
+	}
+	if got, want := matchIDs(matches), []string{"same", "tagged", "content"}; !equalIDs(got, want) {
+		t.Errorf("Similar() = %v, want %v", got, want)
+	}
+	if math.Abs(matches[0].Score-1) > 1e-9 {
+		t.Errorf("score of an identical blog = %v, want 1", matches[0].Score)
+	}
+	for _, m := range matches {
+		if m.Score <= 0 || m.Score > 1+1e-9 {
+			t.Errorf("score of %s = %v, want in (0, 1]", m.ID, m.Score)
+		}
+	}
+	if matches, _ := x.Similar("query", 1); !equalIDs(matchIDs(matches), []string{"same"}) {
+		t.Errorf("Similar(query, 1) = %v, want same", matchIDs(matches))
+	}
+	if _, ok := x.Similar("missing", 10); ok {
+		t.Errorf("Similar() of a blog not indexed reported ok")
+	}
+}
+
+func TestIncrementalUpdates(t *testing.T) {
+	x := built(
+		Document{ID: "a", Title: "Kubernetes operators"},
+		Document{ID: "b", Title: "Kubernetes networking"},
+		Document{ID: "c", Title: "Baking bread"},
+	)
+	if got := matchIDs(mustSimilar(t, x, "a")); !equalIDs(got, []string{"b"}) {
+		t.Fatalf("Similar(a) = %v, want b", got)
+	}
+
+	// Adding a blog again replaces it.
+	x.Add(Document{ID: "b", Title: "Baking bread at home"})
+	if got := matchIDs(mustSimilar(t, x, "a")); len(got) != 0 {
+		t.Errorf("Similar(a) after b changed = %v, want none", got)
+	}
+	if got := matchIDs(mustSimilar(t, x, "c")); !equalIDs(got, []string{"b"}) {
+		t.Errorf("Similar(c) after b changed = %v, want b", got)
+	}
+
+	x.Remove("b")
+	if _, ok := x.Similar("b", 10); ok {
+		t.Errorf("Similar() of a removed blog reported ok")
+	}
+	if got := matchIDs(mustSimilar(t, x, "c")); len(got) != 0 {
+		t.Errorf("Similar(c) after b was removed = %v, want none", got)
+	}
+	if x.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", x.Len())
+	}
+	if len(x.terms.postings["baking"]) != 1 || x.terms.postings["home"] != nil {
+		t.Errorf("postings not cleaned up after a remove: %v", x.terms.postings)
+	}
+}
+
+func mustSimilar(t *testing.T, x *Index, id string) []Match {
+	t.Helper()
+	matches, ok := x.Similar(id, 10)
+	if !ok {
+		t.Fatalf("Similar(%s) reported it missing", id)
+	}
+	return matches
+}
+
+func TestCachedNormsFollowChanges(t *testing.T) {
+	x := built(
+		Document{ID: "a", Title: "Rust ownership", Content: "borrow checker"},
+		Document{ID: "b", Title: "Rust lifetimes", Content: "borrow checker"},
+	)
+	before := mustSimilar(t, x, "a")[0].Score
+	// A blog sharing only some words moves every IDF, so the norms
+	// cached by the first query must not be used again.
+	x.Add(Document{ID: "c", Title: "Rust", Content: "checker"})
+	after := mustSimilar(t, x, "a")
+	fresh := built(
+		Document{ID: "a", Title: "Rust ownership", Content: "borrow checker"},
+		Document{ID: "b", Title: "Rust lifetimes", Content: "borrow checker"},
+		Document{ID: "c", Title: "Rust", Content: "checker"},
+	)
+	want := mustSimilar(t, fresh, "a")
+	if len(after) != len(want) {
+		t.Fatalf("Similar(a) = %+v, want %+v", after, want)
+	}
+	for i := range want {
+		if after[i].ID != want[i].ID || math.Abs(after[i].Score-want[i].Score) > 1e-9 {
+			t.Errorf("Similar(a) = %+v, want %+v as from a fresh index", after, want)
+		}
+	}
+	if after[0].Score == before {
+		t.Errorf("score of b did not change after c was added")
+	}
+}
+
+func TestRebuildKeepsChangesMadeWhileScanning(t *testing.T) {
+	scanning := make(chan struct{})
+	proceed := make(chan struct{})
+	x := &Index{
+		terms: newTerms(),
+		scan: func(ctx context.Context, fn func(Document) error) error {
+			close(scanning)
+			<-proceed
+			// The store was read before the changes below.
+			for _, d := range []Document{
+				{ID: "a", Title: "Terraform modules"},
+				{ID: "b", Title: "Terraform state"},
+			} {
+				if err := fn(d); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- x.Rebuild(context.Background()) }()
+	<-scanning
+	x.Add(Document{ID: "c", Title: "Terraform providers"})
+	x.Remove("b")
+	// A second rebuild returns at once while the first is under way.
+	if err := x.Rebuild(context.Background()); err != nil {
+		t.Fatalf("concurrent Rebuild() = %v", err)
+	}
+	close(proceed)
+	if err := <-done; err != nil {
+		t.Fatalf("Rebuild() = %v", err)
+	}
+	if !x.Ready() || x.Len() != 2 {
+		t.Fatalf("after Rebuild() Ready() = %v, Len() = %d, want true, 2", x.Ready(), x.Len())
+	}
+	if got := matchIDs(mustSimilar(t, x, "a")); !equalIDs(got, []string{"c"}) {
+		t.Errorf("Similar(a) = %v, want c", got)
+	}
+}
+
+func TestFailedRebuildKeepsIndex(t *testing.T) {
+	x := built(Document{ID: "a", Title: "Kafka"}, Document{ID: "b", Title: "Kafka"})
+	x.scan = func(ctx context.Context, fn func(Document) error) error {
+		fn(Document{ID: "c", Title: "Kafka"})
+		return errors.New("store unavailable")
+	}
+	if err := x.Rebuild(context.Background()); err == nil {
+		t.Fatal("Rebuild() succeeded with a failing scan")
+	}
+	if x.Len() != 2 {
+		t.Errorf("Len() = %d after a failed rebuild, want the old 2", x.Len())
+	}
+}
+
+func TestIndexBuildsInBackground(t *testing.T) {
+	scan := func(ctx context.Context, fn func(Document) error) error {
+		fn(Document{ID: "a", Title: "Kafka"})
+		return fn(Document{ID: "b", Title: "Kafka"})
+	}
+	x := New(scan, Options{RebuildInterval: time.Hour, RebuildTimeout: time.Minute})
+	deadline := time.Now().Add(5 * time.Second)
+	for !x.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("index not built in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := matchIDs(mustSimilar(t, x, "a")); !equalIDs(got, []string{"b"}) {
+		t.Errorf("Similar(a) = %v, want b", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := x.Close(ctx); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
+
+func TestNilIndex(t *testing.T) {
+	var x *Index
+	x.Add(Document{ID: "a"})
+	x.Remove("a")
+	if x.Ready() || x.Len() != 0 {
+		t.Errorf("nil index is ready or not empty")
+	}
+	if _, ok := x.Similar("a", 1); ok {
+		t.Errorf("Similar() on a nil index reported ok")
+	}
+	if err := x.Close(context.Background()); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoSource lists the blogs stored in Mongo, oldest first, so new blogs
+// only ever change the last page. Entries are keyed by _id and pages are
+// read by seeking past the previous page's last _id, which costs the same
+// for every page. Each query may take at most timeout.
+type MongoSource struct {
+	blogs   *mongo.Collection
+	timeout time.Duration
+	metrics *metrics.MongoMetrics
+}
+
+// NewMongoSource returns a MongoSource reading db. m may be nil.
+func NewMongoSource(db *mongo.Database, timeout time.Duration, m *metrics.MongoMetrics) *MongoSource {
+	return &MongoSource{
+		blogs:   db.Collection(migrations.BlogCollection),
+		timeout: timeout,
+		metrics: m,
+	}
+}
+
+func (s *MongoSource) Count(ctx context.Context) (n int64, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.blogs.Name(), "count")
+	defer func() { done(err) }()
+	return s.blogs.CountDocuments(ctx, bson.M{})
+}
+
+func (s *MongoSource) Entries(ctx context.Context, after string, limit int64, fn func(Entry) error) (last string, err error) {
+	filter := bson.M{}
+	if after != "" {
+		id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return "", fmt.Errorf("invalid sitemap key %q: %v", after, err)
+		}
+		filter["_id"] = bson.M{"$gt": id}
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.blogs.Name(), "find")
+	defer func() { done(err) }()
+	cur, err := s.blogs.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"slug": 1, "updated_at": 1}))
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		var doc struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			Slug      string             `bson:"slug"`
+			UpdatedAt time.Time          `bson:"updated_at"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return last, err
+		}
+		// Blogs stored before updates were timed were last changed no
+		// earlier than they were created.
+		lastMod := doc.UpdatedAt
+		if lastMod.IsZero() {
+			lastMod = doc.ID.Timestamp()
+		}
+		if err := fn(Entry{Slug: doc.Slug, LastMod: lastMod}); err != nil {
+			return last, err
+		}
+		last = doc.ID.Hex()
+	}
+	return last, cur.Err()
+}
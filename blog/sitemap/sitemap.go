@@ -0,0 +1,248 @@
+// Package sitemap writes sitemaps (https://www.sitemaps.org/protocol.html)
+// listing the page of every blog so search engines can find them. Sitemaps
+// with more than MaxURLs blogs are split into pages listed by a sitemap
+// index.
+package sitemap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+)
+
+// MaxURLs is the most URLs the protocol allows in one sitemap file.
+const MaxURLs = 50000
+
+// ContentType is the content type of sitemaps and sitemap indexes.
+const ContentType = "application/xml; charset=utf-8"
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Path is where the sitemap, or the index of a split one, lives relative to
+// the root of the site.
+const Path = "/sitemap.xml"
+
+const pagePath = "/sitemaps/sitemap-%d.xml"
+
+// PagePath returns where page n of a split sitemap lives relative to the
+// root of the site.
+func PagePath(n int) string {
+	return fmt.Sprintf(pagePath, n)
+}
+
+// ParsePagePath returns the page number in p, a path returned by PagePath.
+func ParsePagePath(p string) (int, bool) {
+	var n int
+	if _, err := fmt.Sscanf(p, pagePath, &n); err != nil || n < 1 || PagePath(n) != p {
+		return 0, false
+	}
+	return n, true
+}
+
+// Entry is a blog listed in the sitemap.
+type Entry struct {
+	Slug    string
+	LastMod time.Time
+}
+
+// Source lists the blogs a sitemap covers, in an order that does not change
+// as blogs are added, so pages of a split sitemap stay stable.
+type Source interface {
+	Count(ctx context.Context) (int64, error)
+	// Entries calls fn for at most limit entries following the one keyed
+	// after, or from the first entry when after is "", and returns the key
+	// of the last entry it passed to fn.
+	Entries(ctx context.Context, after string, limit int64, fn func(Entry) error) (string, error)
+}
+
+// New returns the sitemap of the blogs in src, with links into site and its
+// pages at PagePath.
+func New(src Source, site config.Site) *Sitemap {
+	return &Sitemap{
+		Source:  src,
+		BlogURL: site.BlogURL,
+		PageURL: func(n int) string { return site.BaseURL + PagePath(n) },
+	}
+}
+
+// Sitemap lays out the sitemap of the blogs in Source.
+type Sitemap struct {
+	Source Source
+	// BlogURL returns the address of a blog's page and PageURL that of page
+	// n, counted from 1, of a split sitemap.
+	BlogURL func(slug string) string
+	PageURL func(n int) string
+	// PageSize is the number of blogs per page, MaxURLs when zero.
+	PageSize int
+
+	// ends holds the key of the last entry of the first pages, so a page
+	// is read from where the previous one ended rather than by skipping
+	// every blog before it. It is dropped when blogs are deleted, which
+	// moves the pages that follow.
+	mu    sync.Mutex
+	count int64
+	ends  []string
+}
+
+func (s *Sitemap) pageSize() int64 {
+	if s.PageSize > 0 {
+		return int64(s.PageSize)
+	}
+	return MaxURLs
+}
+
+// Pages returns the number of pages the sitemap is split into. With a
+// single page, it is the sitemap itself and no index is needed.
+func (s *Sitemap) Pages(ctx context.Context) (int, error) {
+	n, err := s.Source.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	if n < s.count {
+		s.ends = nil
+	}
+	s.count = n
+	s.mu.Unlock()
+	size := s.pageSize()
+	if n <= size {
+		return 1, nil
+	}
+	return int((n + size - 1) / size), nil
+}
+
+type url struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// WritePage writes page n of the sitemap to w and returns when its most
+// recently changed blog changed.
+func (s *Sitemap) WritePage(ctx context.Context, w io.Writer, n int) (time.Time, error) {
+	var modified time.Time
+	if n < 1 {
+		return modified, fmt.Errorf("sitemap page %d out of range", n)
+	}
+	enc, err := start(w, "urlset")
+	if err != nil {
+		return modified, err
+	}
+	size := s.pageSize()
+	after, ok, err := s.pageStart(ctx, n)
+	if err != nil {
+		return modified, err
+	}
+	if !ok {
+		return modified, end(w, enc, "urlset")
+	}
+	var count int64
+	last, err := s.Source.Entries(ctx, after, size, func(e Entry) error {
+		count++
+		if e.LastMod.After(modified) {
+			modified = e.LastMod
+		}
+		return enc.EncodeElement(url{Loc: s.BlogURL(e.Slug), LastMod: lastMod(e.LastMod)}, xml.StartElement{Name: xml.Name{Local: "url"}})
+	})
+	if err != nil {
+		return modified, err
+	}
+	if count == size {
+		s.setEnd(n, last)
+	}
+	return modified, end(w, enc, "urlset")
+}
+
+// pageStart returns the key of the entry page n follows, reading the pages
+// before it whose end is not known yet. It reports false when page n is
+// past the last blog.
+func (s *Sitemap) pageStart(ctx context.Context, n int) (string, bool, error) {
+	if n == 1 {
+		return "", true, nil
+	}
+	s.mu.Lock()
+	known := len(s.ends)
+	after := ""
+	if known >= n-1 {
+		after = s.ends[n-2]
+	} else if known > 0 {
+		after = s.ends[known-1]
+	}
+	s.mu.Unlock()
+	size := s.pageSize()
+	for p := known + 1; p < n; p++ {
+		var count int64
+		last, err := s.Source.Entries(ctx, after, size, func(Entry) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return "", false, err
+		}
+		if count < size {
+			return "", false, nil
+		}
+		s.setEnd(p, last)
+		after = last
+	}
+	return after, true, nil
+}
+
+// setEnd records last as the key of the last entry of page n.
+func (s *Sitemap) setEnd(n int, last string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ends) == n-1 {
+		s.ends = append(s.ends, last)
+	}
+}
+
+// WriteIndex writes a sitemap index listing the first pages pages to w.
+// Pages are listed without a modification date, which would take reading
+// every blog to know.
+func (s *Sitemap) WriteIndex(w io.Writer, pages int) error {
+	enc, err := start(w, "sitemapindex")
+	if err != nil {
+		return err
+	}
+	for n := 1; n <= pages; n++ {
+		if err := enc.EncodeElement(url{Loc: s.PageURL(n)}, xml.StartElement{Name: xml.Name{Local: "sitemap"}}); err != nil {
+			return err
+		}
+	}
+	return end(w, enc, "sitemapindex")
+}
+
+func start(w io.Writer, root string) (*xml.Encoder, error) {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc, enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: root},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: namespace}},
+	})
+}
+
+func end(w io.Writer, enc *xml.Encoder, root string) error {
+	if err := enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: root}}); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func lastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,247 @@
+package sitemap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+)
+
+var testSite = config.Site{BaseURL: "https://blog.example.com", Title: "Blog"}
+
+// memorySource is a Source of entries keyed and ordered by slug. It
+// records the key every Entries call started after.
+type memorySource struct {
+	entries []Entry
+	reads   []string
+}
+
+func newMemorySource(n int) *memorySource {
+	src := &memorySource{}
+	base := time.Date(2021, 7, 19, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		src.entries = append(src.entries, Entry{
+			Slug:    fmt.Sprintf("blog-%02d", i),
+			LastMod: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	return src
+}
+
+func (m *memorySource) Count(context.Context) (int64, error) {
+	return int64(len(m.entries)), nil
+}
+
+func (m *memorySource) Entries(_ context.Context, after string, limit int64, fn func(Entry) error) (string, error) {
+	m.reads = append(m.reads, after)
+	i := sort.Search(len(m.entries), func(i int) bool { return m.entries[i].Slug > after })
+	last := ""
+	for ; i < len(m.entries) && limit > 0; i, limit = i+1, limit-1 {
+		if err := fn(m.entries[i]); err != nil {
+			return last, err
+		}
+		last = m.entries[i].Slug
+	}
+	return last, nil
+}
+
+func (m *memorySource) delete(slug string) {
+	for i, e := range m.entries {
+		if e.Slug == slug {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+type parsedURLSet struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+type parsedIndex struct {
+	XMLName  xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// page writes page n of s and returns the URLs it lists.
+func page(t *testing.T, s *Sitemap, n int) ([]string, time.Time) {
+	t.Helper()
+	var buf bytes.Buffer
+	modified, err := s.WritePage(context.Background(), &buf, n)
+	if err != nil {
+		t.Fatalf("WritePage(%d) = %v", n, err)
+	}
+	var doc parsedURLSet
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("page %d does not parse: %v\n%s", n, err, buf.Bytes())
+	}
+	var locs []string
+	for _, u := range doc.URLs {
+		locs = append(locs, u.Loc)
+	}
+	return locs, modified
+}
+
+func blogURLs(slugs ...string) []string {
+	var urls []string
+	for _, s := range slugs {
+		urls = append(urls, testSite.BlogURL(s))
+	}
+	return urls
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPagePath(t *testing.T) {
+	for _, n := range []int{1, 2, 40} {
+		if got, ok := ParsePagePath(PagePath(n)); !ok || got != n {
+			t.Errorf("ParsePagePath(PagePath(%d)) = %d, %v", n, got, ok)
+		}
+	}
+	for _, p := range []string{"/sitemaps/sitemap-0.xml", "/sitemaps/sitemap-01.xml", "/sitemaps/sitemap-1.xml.gz", "/sitemaps/sitemap-x.xml", Path} {
+		if n, ok := ParsePagePath(p); ok {
+			t.Errorf("ParsePagePath(%q) = %d, want not a page", p, n)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	for _, tt := range []struct {
+		blogs, size, want int
+	}{
+		{0, 2, 1},
+		{2, 2, 1},
+		{3, 2, 2},
+		{5, 2, 3},
+		{6, 2, 3},
+	} {
+		s := New(newMemorySource(tt.blogs), testSite)
+		s.PageSize = tt.size
+		if got, err := s.Pages(context.Background()); err != nil || got != tt.want {
+			t.Errorf("Pages() of %d blogs by %d = %d, %v, want %d", tt.blogs, tt.size, got, err, tt.want)
+		}
+	}
+}
+
+func TestSinglePage(t *testing.T) {
+	s := New(newMemorySource(3), testSite)
+	got, modified := page(t, s, 1)
+	if want := blogURLs("blog-00", "blog-01", "blog-02"); !equal(got, want) {
+		t.Errorf("page 1 = %v, want %v", got, want)
+	}
+	if want := time.Date(2021, 7, 19, 12, 0, 0, 0, time.UTC); !modified.Equal(want) {
+		t.Errorf("page 1 modified %v, want %v", modified, want)
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	src := newMemorySource(5)
+	s := New(src, testSite)
+	s.PageSize = 2
+
+	want := [][]string{
+		blogURLs("blog-00", "blog-01"),
+		blogURLs("blog-02", "blog-03"),
+		blogURLs("blog-04"),
+		nil,
+	}
+	for i, w := range want {
+		if got, _ := page(t, s, i+1); !equal(got, w) {
+			t.Errorf("page %d = %v, want %v", i+1, got, w)
+		}
+	}
+	// Each page was read from where the previous one ended. The last
+	// page is short, so page 4 has to read it again to find it is empty.
+	if want := []string{"", "blog-01", "blog-03", "blog-03"}; !equal(src.reads, want) {
+		t.Errorf("reads started after %q, want %q", src.reads, want)
+	}
+}
+
+func TestPageReadsThroughUnknownPages(t *testing.T) {
+	src := newMemorySource(5)
+	s := New(src, testSite)
+	s.PageSize = 2
+
+	if got, _ := page(t, s, 3); !equal(got, blogURLs("blog-04")) {
+		t.Errorf("page 3 = %v, want blog-04", got)
+	}
+	if want := []string{"", "blog-01", "blog-03"}; !equal(src.reads, want) {
+		t.Errorf("reads started after %q, want %q", src.reads, want)
+	}
+	// The ends of the pages before are remembered.
+	src.reads = nil
+	page(t, s, 2)
+	page(t, s, 3)
+	if want := []string{"blog-01", "blog-03"}; !equal(src.reads, want) {
+		t.Errorf("reads started after %q, want %q", src.reads, want)
+	}
+	if got, _ := page(t, s, 5); len(got) != 0 {
+		t.Errorf("page 5 = %v, want none", got)
+	}
+}
+
+func TestPagesMoveWhenBlogsAreDeleted(t *testing.T) {
+	src := newMemorySource(5)
+	s := New(src, testSite)
+	s.PageSize = 2
+	ctx := context.Background()
+
+	if _, err := s.Pages(ctx); err != nil {
+		t.Fatal(err)
+	}
+	page(t, s, 1)
+	page(t, s, 2)
+
+	src.delete("blog-00")
+	if n, err := s.Pages(ctx); err != nil || n != 2 {
+		t.Fatalf("Pages() after a delete = %d, %v, want 2", n, err)
+	}
+	if got, _ := page(t, s, 2); !equal(got, blogURLs("blog-03", "blog-04")) {
+		t.Errorf("page 2 after a delete = %v, want blog-03 and blog-04", got)
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	s := New(newMemorySource(0), testSite)
+	var buf bytes.Buffer
+	if err := s.WriteIndex(&buf, 3); err != nil {
+		t.Fatalf("WriteIndex() = %v", err)
+	}
+	var doc parsedIndex
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("index does not parse: %v\n%s", err, buf.Bytes())
+	}
+	var got []string
+	for _, sm := range doc.Sitemaps {
+		got = append(got, sm.Loc)
+	}
+	want := []string{
+		"https://blog.example.com/sitemaps/sitemap-1.xml",
+		"https://blog.example.com/sitemaps/sitemap-2.xml",
+		"https://blog.example.com/sitemaps/sitemap-3.xml",
+	}
+	if !equal(got, want) {
+		t.Errorf("index lists %v, want %v", got, want)
+	}
+}
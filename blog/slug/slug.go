@@ -0,0 +1,42 @@
+package slug
+
+import (
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTitleLength bounds the part of the slug taken from the title.
+const maxTitleLength = 60
+
+// Make builds a URL friendly slug such as "my-first-blog-60f50e50db60a7737b8b7c44"
+// from a title and object id. The id suffix keeps slugs unique even when
+// titles repeat.
+func Make(title string, id primitive.ObjectID) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(title) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop accents left over after decomposition.
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+		if b.Len() >= maxTitleLength {
+			break
+		}
+	}
+	base := strings.Trim(b.String(), "-")
+	if base == "" {
+		return id.Hex()
+	}
+	return base + "-" + id.Hex()
+}
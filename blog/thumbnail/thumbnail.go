@@ -0,0 +1,168 @@
+// Package thumbnail scales PNG, JPEG and GIF images down to thumbnails
+// using the standard library alone. Each thumbnail pixel averages the
+// source pixels it covers, which keeps scaled down photos smooth without
+// the aliasing of nearest neighbour sampling.
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// Content types of the thumbnails.
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+)
+
+// ErrTooLarge is returned for images with more pixels than allowed.
+var ErrTooLarge = errors.New("image has too many pixels")
+
+// Supported reports whether images of contentType can be thumbnailed.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Options configures Generate.
+type Options struct {
+	// Widths are the widths of the thumbnails in pixels. Heights keep the
+	// aspect ratio of the image.
+	Widths []int
+	// MaxPixels bounds the size of images decoded, guarding against small
+	// files that decode to huge images.
+	MaxPixels int
+	// JPEGQuality is the quality, from 1 to 100, JPEG thumbnails are
+	// encoded with.
+	JPEGQuality int
+}
+
+// Thumbnail is an encoded thumbnail.
+type Thumbnail struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Generate decodes the image read from r and returns a thumbnail for each
+// width narrower than the image, narrowest first; images are never scaled
+// up. JPEG images give JPEG thumbnails and the others PNG ones, which keep
+// transparency. Animated GIFs are thumbnailed from their first frame.
+func Generate(r io.Reader, opts Options) ([]Thumbnail, error) {
+	// Only the header is read to check the size; it is replayed to decode
+	// the image, so the rest is only read for images small enough.
+	var head bytes.Buffer
+	br := bufio.NewReader(r)
+	cfg, format, err := image.DecodeConfig(io.TeeReader(br, &head))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	img, err := decode(format, io.MultiReader(&head, br))
+	if err != nil {
+		return nil, err
+	}
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	widths := append([]int(nil), opts.Widths...)
+	sort.Ints(widths)
+	var thumbs []Thumbnail
+	for i, w := range widths {
+		if w >= sw || w < 1 || (i > 0 && w == widths[i-1]) {
+			continue
+		}
+		h := (sh*w + sw/2) / sw
+		if h < 1 {
+			h = 1
+		}
+		t := Thumbnail{Width: w, Height: h, ContentType: PNG}
+		var buf bytes.Buffer
+		dst := resize(src, w, h)
+		if format == "jpeg" {
+			t.ContentType = JPEG
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: opts.JPEGQuality})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Data = buf.Bytes()
+		thumbs = append(thumbs, t)
+	}
+	return thumbs, nil
+}
+
+func decode(format string, r io.Reader) (image.Image, error) {
+	switch format {
+	case "png":
+		return png.Decode(r)
+	case "jpeg":
+		return jpeg.Decode(r)
+	case "gif":
+		return gif.Decode(r)
+	}
+	return nil, fmt.Errorf("unsupported image format %s", format)
+}
+
+// toRGBA converts img to premultiplied RGBA with its origin at 0,0, the
+// form resize works on.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// resize scales src down to w by h pixels, averaging the block of source
+// pixels each destination pixel covers. w and h must not exceed the size
+// of src.
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sums := make([]uint64, 4*w)
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		for i := range sums {
+			sums[i] = 0
+		}
+		for sy := y0; sy < y1; sy++ {
+			row := src.Pix[sy*src.Stride:]
+			for x := 0; x < w; x++ {
+				x0, x1 := x*sw/w, (x+1)*sw/w
+				s := sums[4*x : 4*x+4]
+				for sx := x0; sx < x1; sx++ {
+					p := row[4*sx : 4*sx+4]
+					s[0] += uint64(p[0])
+					s[1] += uint64(p[1])
+					s[2] += uint64(p[2])
+					s[3] += uint64(p[3])
+				}
+			}
+		}
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			n := uint64((y1 - y0) * ((x+1)*sw/w - x*sw/w))
+			for c := 0; c < 4; c++ {
+				out[4*x+c] = uint8((sums[4*x+c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
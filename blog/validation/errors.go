@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FieldViolations decodes the errdetails.BadRequest details carried by an
+// InvalidArgument error into a map of field name to messages. It returns nil
+// when err is not an InvalidArgument status or carries no field violations.
+func FieldViolations(err error) map[string][]string {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		return nil
+	}
+	var fields map[string][]string
+	for _, detail := range st.Details() {
+		br, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, fv := range br.GetFieldViolations() {
+			if fields == nil {
+				fields = make(map[string][]string)
+			}
+			fields[fv.GetField()] = append(fields[fv.GetField()], fv.GetDescription())
+		}
+	}
+	return fields
+}
@@ -0,0 +1,536 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Limits applied to blog fields. Lengths are counted in characters, not bytes.
+const (
+	MaxAuthorIDLength = 64
+	MaxUserIDLength   = 64
+	MaxViewerIDLength = 128
+	MaxTitleLength    = 200
+	MaxContentLength  = 100000
+)
+
+// Limits applied to blog tags.
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+)
+
+// Limits applied to attachment uploads. The size of whole attachments is
+// limited by server configuration.
+const (
+	MaxFilenameLength = 255
+	MaxChunkSize      = 1 << 20
+)
+
+// MaxTopPosts bounds GetBlogAnalyticsRequest.top_posts.
+const MaxTopPosts = 100
+
+// MaxRelatedBlogs bounds GetRelatedBlogsRequest.limit.
+const MaxRelatedBlogs = 50
+
+// Limits applied to webhooks.
+const (
+	MaxWebhookURLLength  = 2048
+	MinWebhookSecretSize = 16
+	MaxWebhookSecretSize = 256
+)
+
+// MaxTenantIDLength keeps tenant database names within MongoDB's limit.
+const MaxTenantIDLength = 32
+
+// Violations collects field violations for a single request.
+type Violations struct {
+	list []*errdetails.BadRequest_FieldViolation
+}
+
+// Add records a violation for the given field.
+func (v *Violations) Add(field, format string, args ...interface{}) {
+	v.list = append(v.list, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// Empty reports whether no violations were recorded.
+func (v *Violations) Empty() bool {
+	return len(v.list) == 0
+}
+
+// Err returns nil when there are no violations, otherwise an InvalidArgument
+// status carrying an errdetails.BadRequest with every recorded violation.
+func (v *Violations) Err() error {
+	if v.Empty() {
+		return nil
+	}
+	st := status.New(codes.InvalidArgument, "Invalid request")
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.list})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// ValidateCreateBlogRequest checks a blog to be created. The fields set by
+// the server, such as the id, slug and timestamps, must be left empty.
+func ValidateCreateBlogRequest(req *blogpb.CreateBlogRequest) error {
+	v := &Violations{}
+	blog := req.GetBlog()
+	if blog == nil {
+		v.Add("blog", "is required")
+		return v.Err()
+	}
+	if blog.GetId() != "" {
+		v.Add("blog.id", "must be empty, it is assigned by the server")
+	}
+	if blog.GetSlug() != "" {
+		v.Add("blog.slug", "must be empty, it is assigned by the server")
+	}
+	if len(blog.GetReactions()) > 0 {
+		v.Add("blog.reactions", "must be empty, they are counted by the server")
+	}
+	if blog.GetCreatedAt() != nil || blog.GetUpdatedAt() != nil {
+		v.Add("blog.created_at", "must be empty, timestamps are set by the server")
+	}
+	if len(blog.GetAttachments()) > 0 {
+		v.Add("blog.attachments", "must be empty, use UploadAttachment")
+	}
+	validateBlogFields(v, blog)
+	return v.Err()
+}
+
+// ValidateReadBlogRequest checks the blog id and the optional viewer id.
+func ValidateReadBlogRequest(req *blogpb.ReadBlogRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "id", req.GetId())
+	validateText(v, "viewer_id", req.GetViewerId(), false, MaxViewerIDLength, false)
+	return v.Err()
+}
+
+// UpdatableBlogFields are the fields of a blog UpdateBlog may change, by
+// proto name.
+var UpdatableBlogFields = []string{"author_id", "title", "content", "tags"}
+
+// ValidateUpdateBlogRequest checks the id of the blog to be updated, the
+// paths of the update mask and the fields they name, or every updatable
+// field when the mask is empty.
+func ValidateUpdateBlogRequest(req *blogpb.UpdateBlogRequest) error {
+	v := &Violations{}
+	blog := req.GetBlog()
+	if blog == nil {
+		v.Add("blog", "is required")
+		return v.Err()
+	}
+	validateObjectID(v, "blog.id", blog.GetId())
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		validateBlogFields(v, blog)
+		return v.Err()
+	}
+	seen := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		switch {
+		case !isUpdatable(p):
+			v.Add("update_mask", "%q is not one of %s", p, strings.Join(UpdatableBlogFields, ", "))
+		case seen[p]:
+			v.Add("update_mask", "%q appears more than once", p)
+		default:
+			validateBlogField(v, blog, p)
+		}
+		seen[p] = true
+	}
+	return v.Err()
+}
+
+func isUpdatable(field string) bool {
+	for _, f := range UpdatableBlogFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDeleteBlogRequest checks the id of the blog to be deleted.
+func ValidateDeleteBlogRequest(req *blogpb.DeleteBlogRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	return v.Err()
+}
+
+// ValidateReactToBlogRequest checks the blog id, the user id and the
+// reaction, which is required.
+func ValidateReactToBlogRequest(req *blogpb.ReactToBlogRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	validateName(v, "user_id", req.GetUserId(), MaxUserIDLength)
+	validateReaction(v, "reaction", req.GetReaction(), true)
+	return v.Err()
+}
+
+// ValidateRemoveReactionRequest checks the blog id, the user id and the
+// reaction to be removed.
+func ValidateRemoveReactionRequest(req *blogpb.RemoveReactionRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	validateName(v, "user_id", req.GetUserId(), MaxUserIDLength)
+	validateReaction(v, "reaction", req.GetReaction(), true)
+	return v.Err()
+}
+
+// ValidateListReactionsRequest checks the blog id and the reaction, which
+// may be left empty to list every reaction.
+func ValidateListReactionsRequest(req *blogpb.ListReactionsRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	validateReaction(v, "reaction", req.GetReaction(), false)
+	return v.Err()
+}
+
+// ValidateGetBlogAnalyticsRequest checks the fields of req. The length of
+// the time range is checked by the server once defaults are applied.
+func ValidateGetBlogAnalyticsRequest(req *blogpb.GetBlogAnalyticsRequest) error {
+	v := &Violations{}
+	switch {
+	case req.GetBlogId() != "" && req.GetAuthorId() != "":
+		v.Add("author_id", "must be empty when blog_id is set")
+	case req.GetBlogId() != "":
+		validateObjectID(v, "blog_id", req.GetBlogId())
+	case req.GetAuthorId() != "":
+		validateName(v, "author_id", req.GetAuthorId(), MaxAuthorIDLength)
+	default:
+		v.Add("blog_id", "either blog_id or author_id is required")
+	}
+	if _, ok := blogpb.Granularity_name[int32(req.GetGranularity())]; !ok {
+		v.Add("granularity", "must be HOUR or DAY, got %d", req.GetGranularity())
+	}
+	startOK := validateTimestamp(v, "start_time", req.GetStartTime())
+	endOK := validateTimestamp(v, "end_time", req.GetEndTime())
+	if startOK && endOK && req.GetStartTime() != nil && req.GetEndTime() != nil &&
+		!req.GetStartTime().AsTime().Before(req.GetEndTime().AsTime()) {
+		v.Add("end_time", "must be after start_time")
+	}
+	if n := req.GetTopPosts(); n < 0 || n > MaxTopPosts {
+		v.Add("top_posts", "must be between 0 and %d, got %d", MaxTopPosts, n)
+	}
+	return v.Err()
+}
+
+// ValidateGetRelatedBlogsRequest checks the blog id and that the limit is
+// at most MaxRelatedBlogs. A zero limit is left to the server's default.
+func ValidateGetRelatedBlogsRequest(req *blogpb.GetRelatedBlogsRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	if n := req.GetLimit(); n < 0 || n > MaxRelatedBlogs {
+		v.Add("limit", "must be between 0 and %d, got %d", MaxRelatedBlogs, n)
+	}
+	return v.Err()
+}
+
+// ValidateAttachmentMetadata checks the first message of an
+// UploadAttachment stream.
+func ValidateAttachmentMetadata(m *blogpb.AttachmentMetadata) error {
+	v := &Violations{}
+	if m == nil {
+		v.Add("metadata", "must be sent first")
+		return v.Err()
+	}
+	validateObjectID(v, "metadata.blog_id", m.GetBlogId())
+	if validateText(v, "metadata.filename", m.GetFilename(), true, MaxFilenameLength, false) &&
+		strings.ContainsAny(m.GetFilename(), `/\`) {
+		v.Add("metadata.filename", "must not contain path separators")
+	}
+	if ct := m.GetContentType(); ct != "" {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			v.Add("metadata.content_type", "must be a media type such as image/png")
+		}
+	}
+	if m.GetSize() < 0 {
+		v.Add("metadata.size", "must not be negative")
+	}
+	return v.Err()
+}
+
+// ValidateChunk checks a content message of an UploadAttachment stream.
+func ValidateChunk(chunk []byte) error {
+	v := &Violations{}
+	if len(chunk) == 0 {
+		v.Add("chunk", "must not be empty")
+	} else if len(chunk) > MaxChunkSize {
+		v.Add("chunk", "must be at most %d bytes, got %d", MaxChunkSize, len(chunk))
+	}
+	return v.Err()
+}
+
+// ValidateDownloadAttachmentRequest checks the blog and attachment ids and
+// the requested thumbnail width.
+func ValidateDownloadAttachmentRequest(req *blogpb.DownloadAttachmentRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "blog_id", req.GetBlogId())
+	validateObjectID(v, "attachment_id", req.GetAttachmentId())
+	if req.GetThumbnailWidth() < 0 {
+		v.Add("thumbnail_width", "must not be negative")
+	}
+	return v.Err()
+}
+
+// ValidateRegisterWebhookRequest checks that the URL is an absolute http or
+// https URL, that the events are known and distinct, and the length of the
+// secret when one is given.
+func ValidateRegisterWebhookRequest(req *blogpb.RegisterWebhookRequest) error {
+	v := &Violations{}
+	if raw := req.GetUrl(); raw == "" {
+		v.Add("url", "must not be empty")
+	} else if len(raw) > MaxWebhookURLLength {
+		v.Add("url", "must be at most %d characters", MaxWebhookURLLength)
+	} else if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.Add("url", "must be an absolute http or https URL")
+	}
+	seen := make(map[blogpb.WebhookEvent]bool)
+	for _, e := range req.GetEvents() {
+		if _, ok := blogpb.WebhookEvent_name[int32(e)]; !ok || e == blogpb.WebhookEvent_WEBHOOK_EVENT_UNSPECIFIED {
+			v.Add("events", "%v is not a webhook event", e)
+			continue
+		}
+		if seen[e] {
+			v.Add("events", "%v appears more than once", e)
+		}
+		seen[e] = true
+	}
+	if n := len(req.GetSecret()); n > 0 && (n < MinWebhookSecretSize || n > MaxWebhookSecretSize) {
+		v.Add("secret", "must be between %d and %d characters", MinWebhookSecretSize, MaxWebhookSecretSize)
+	}
+	return v.Err()
+}
+
+// ValidateDeleteWebhookRequest checks the id of the webhook to be deleted.
+func ValidateDeleteWebhookRequest(req *blogpb.DeleteWebhookRequest) error {
+	v := &Violations{}
+	validateObjectID(v, "webhook_id", req.GetWebhookId())
+	return v.Err()
+}
+
+// ValidateCreateTenantRequest checks the id of the tenant to be created.
+func ValidateCreateTenantRequest(req *blogpb.CreateTenantRequest) error {
+	return validateTenantID(req.GetTenantId())
+}
+
+// ValidateSuspendTenantRequest checks the id of the tenant to be
+// suspended.
+func ValidateSuspendTenantRequest(req *blogpb.SuspendTenantRequest) error {
+	return validateTenantID(req.GetTenantId())
+}
+
+// ValidateResumeTenantRequest checks the id of the tenant to be resumed.
+func ValidateResumeTenantRequest(req *blogpb.ResumeTenantRequest) error {
+	return validateTenantID(req.GetTenantId())
+}
+
+func validateTenantID(id string) error {
+	v := &Violations{}
+	if err := ValidateTenantID(id); err != nil {
+		v.Add("tenant_id", "%v", err)
+	}
+	return v.Err()
+}
+
+// ValidateTenantID checks a tenant id. Tenant ids name databases and
+// directories, so they are lowercase ASCII letters, digits and dashes
+// starting with a letter.
+func ValidateTenantID(id string) error {
+	if id == "" {
+		return errors.New("must not be empty")
+	}
+	if len(id) > MaxTenantIDLength {
+		return fmt.Errorf("must be at most %d characters", MaxTenantIDLength)
+	}
+	if id[0] < 'a' || id[0] > 'z' {
+		return errors.New("must start with a lowercase letter")
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return errors.New("may only contain lowercase letters, digits and dashes")
+		}
+	}
+	return nil
+}
+
+func validateTimestamp(v *Violations, field string, ts *timestamppb.Timestamp) bool {
+	if ts == nil {
+		return true
+	}
+	if err := ts.CheckValid(); err != nil {
+		v.Add(field, "must be a valid timestamp")
+		return false
+	}
+	return true
+}
+
+func validateBlogFields(v *Violations, blog *blogpb.Blog) {
+	for _, f := range UpdatableBlogFields {
+		validateBlogField(v, blog, f)
+	}
+}
+
+// validateBlogField checks the field of blog with the given proto name.
+func validateBlogField(v *Violations, blog *blogpb.Blog, field string) {
+	switch field {
+	case "author_id":
+		validateName(v, "blog.author_id", blog.GetAuthorId(), MaxAuthorIDLength)
+	case "title":
+		validateText(v, "blog.title", blog.GetTitle(), true, MaxTitleLength, false)
+	case "content":
+		validateText(v, "blog.content", blog.GetContent(), false, MaxContentLength, true)
+	case "tags":
+		validateTags(v, "blog.tags", blog.GetTags())
+	}
+}
+
+func validateTags(v *Violations, field string, tags []string) {
+	if len(tags) > MaxTags {
+		v.Add(field, "must have at most %d tags, got %d", MaxTags, len(tags))
+		return
+	}
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		if err := ValidateTag(t); err != nil {
+			v.Add(field, "%q %v", t, err)
+			continue
+		}
+		if seen[t] {
+			v.Add(field, "%q appears more than once", t)
+		}
+		seen[t] = true
+	}
+}
+
+// ValidateTag checks a single tag. Tags are lowercase ASCII letters and
+// digits separated by single dashes.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return errors.New("must not be empty")
+	}
+	if len(tag) > MaxTagLength {
+		return fmt.Errorf("must be at most %d characters", MaxTagLength)
+	}
+	if tag[0] == '-' || tag[len(tag)-1] == '-' || strings.Contains(tag, "--") {
+		return errors.New("must not start or end with a dash or contain two in a row")
+	}
+	for _, r := range tag {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return errors.New("may only contain lowercase letters, digits and dashes")
+		}
+	}
+	return nil
+}
+
+// validateName checks a required user or author id.
+func validateName(v *Violations, field, value string, max int) {
+	if !validateText(v, field, value, true, max, false) {
+		return
+	}
+	for _, r := range value {
+		if !isAuthorRune(r) {
+			v.Add(field, "contains invalid character %q, only letters, digits, spaces and _ . - @ are allowed", r)
+			return
+		}
+	}
+}
+
+func validateReaction(v *Violations, field string, value blogpb.Reaction, required bool) {
+	if value == blogpb.Reaction_REACTION_UNSPECIFIED {
+		if required {
+			v.Add(field, "is required")
+		}
+		return
+	}
+	if _, ok := blogpb.Reaction_name[int32(value)]; !ok {
+		v.Add(field, "must be one of the known reactions, got %d", value)
+	}
+}
+
+// validateText checks presence, encoding, length and control characters of a
+// text field. It returns true when the value passed every check.
+func validateText(v *Violations, field, value string, required bool, max int, multiline bool) bool {
+	if value == "" {
+		if required {
+			v.Add(field, "is required")
+			return false
+		}
+		return true
+	}
+	if !utf8.ValidString(value) {
+		v.Add(field, "must be valid UTF-8")
+		return false
+	}
+	if required && isBlank(value) {
+		v.Add(field, "must not be blank")
+		return false
+	}
+	if n := utf8.RuneCountInString(value); n > max {
+		v.Add(field, "must be at most %d characters, got %d", max, n)
+		return false
+	}
+	for _, r := range value {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		}
+		if unicode.IsControl(r) {
+			v.Add(field, "must not contain control characters")
+			return false
+		}
+	}
+	return true
+}
+
+// ObjectID returns the object id value, which a Validate function has
+// accepted. Any other value gives the zero id.
+func ObjectID(value string) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(value)
+	return id
+}
+
+func validateObjectID(v *Violations, field, value string) {
+	if value == "" {
+		v.Add(field, "is required")
+		return
+	}
+	if !primitive.IsValidObjectID(value) {
+		v.Add(field, "must be a 24 character hex object id")
+	}
+}
+
+func isBlank(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAuthorRune(r rune) bool {
+	switch {
+	case unicode.IsLetter(r), unicode.IsDigit(r):
+		return true
+	case r == ' ', r == '_', r == '.', r == '-', r == '@':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/workerpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordTimeout bounds recording the outcome of one attempt.
+const recordTimeout = 10 * time.Second
+
+// abandonedAfter is how long past its due time, on top of the request
+// timeout, the next attempt of a pending delivery must be for the
+// delivery to be taken as left behind by a dispatcher that stopped.
+const abandonedAfter = time.Minute
+
+// ErrPrivateAddress is returned for deliveries to addresses in private,
+// loopback or link-local networks when those are not allowed.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Timeout bounds each request, including reading the response.
+	Timeout time.Duration
+	// MaxAttempts is how many requests are made for a delivery before it
+	// fails.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled before each
+	// further one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DisableAfter is how many deliveries in a row may fail before the
+	// webhook is disabled.
+	DisableAfter int
+	// AllowPrivate allows webhooks on private, loopback and link-local
+	// addresses, which are refused by default so that webhooks cannot be
+	// used to reach services inside the network.
+	AllowPrivate bool
+}
+
+// Dispatcher delivers events in the background. Requests run on a worker
+// pool, possibly shared with other dispatchers, and retries wait on timers
+// rather than workers. Pending deliveries are recorded with their event, and
+// those left behind by a dispatcher that stopped are made by another one on
+// the store, which looks for them when it starts and then every
+// abandonedAfter. Publish and Enqueue are safe for concurrent use.
+type Dispatcher struct {
+	store  Store
+	pool   *workerpool.Pool
+	client *http.Client
+	opts   Options
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	// timers are the pending retries.
+	timers map[*time.Timer]bool
+}
+
+// NewDispatcher returns a Dispatcher delivering the events of the webhooks
+// in store on pool, and starts making the pending deliveries left in store.
+// Call Close before closing the pool.
+func NewDispatcher(store Store, pool *workerpool.Pool, opts Options) *Dispatcher {
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		// Checked on the address actually dialled, so neither DNS nor
+		// redirects can point a webhook inside the network.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		store: store,
+		pool:  pool,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// A redirect is an answer from the wrong endpoint, not a
+			// successful delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		opts:   opts,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		timers: make(map[*time.Timer]bool),
+	}
+	go d.redrive(ctx)
+	return d
+}
+
+// redrive resumes the pending deliveries left behind by dispatchers that
+// stopped until ctx is done.
+func (d *Dispatcher) redrive(ctx context.Context) {
+	defer close(d.done)
+	t := time.NewTicker(abandonedAfter)
+	defer t.Stop()
+	for {
+		d.resume(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// resume schedules the pending deliveries left behind by dispatchers that
+// stopped, those that had made attempts after the backoff for the next.
+func (d *Dispatcher) resume(ctx context.Context) {
+	dels, err := d.store.Claim(ctx, time.Now().Add(-d.opts.Timeout-abandonedAfter))
+	if err != nil && ctx.Err() == nil {
+		slog.Error("failed to claim pending webhook deliveries", "err", err)
+	}
+	for _, del := range dels {
+		w, err := d.store.Get(ctx, del.WebhookID)
+		if err == nil && !w.Disabled {
+			n := del.Attempts + 1
+			var wait time.Duration
+			if n > 1 {
+				wait = d.backoff(n)
+			}
+			d.retry(w, del, n, wait)
+			continue
+		}
+		if err == nil || err == ErrNotFound {
+			// There is nothing to deliver to any more.
+			a := Attempt{At: time.Now().UTC(), Error: "webhook deleted or disabled"}
+			err = d.store.RecordAttempt(ctx, del, a, StatusFailed, time.Time{})
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Error("failed to resume webhook delivery", "webhook_id", del.WebhookID.Hex(), "delivery_id", del.ID.Hex(), "err", err)
+		}
+	}
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsInterfaceLocalMulticast()
+}
+
+// Publish queues delivering e to the webhooks subscribed to it. It never
+// blocks; events arriving while the pool's queue is full are dropped and
+// logged.
+func (d *Dispatcher) Publish(e *Event) {
+	if d == nil {
+		return
+	}
+	queued := d.pool.Submit(func(ctx context.Context) {
+		hooks, err := d.store.Subscribed(ctx, e.Type)
+		if err != nil {
+			slog.Error("failed to look up webhooks", "event_id", e.ID, "event_type", e.Type, "err", err)
+			return
+		}
+		for _, w := range hooks {
+			d.deliver(ctx, w, &Delivery{ID: primitive.NewObjectID(), WebhookID: w.ID, Event: e}, 1)
+		}
+	})
+	if !queued {
+		slog.Warn("dropped webhook event, the queue was full", "event_id", e.ID, "event_type", e.Type)
+	}
+}
+
+// Enqueue is Publish for callers that retry events themselves: it returns
+// once a pending delivery of e is recorded for each webhook subscribed to
+// it, so e is delivered even if the dispatcher stops first, and fails
+// otherwise. Deliveries of e recorded by an earlier call are left to the
+// dispatcher that recorded them, or resumed by another one.
+func (d *Dispatcher) Enqueue(ctx context.Context, e *Event) error {
+	hooks, err := d.store.Subscribed(ctx, e.Type)
+	if err != nil {
+		return err
+	}
+	for _, w := range hooks {
+		del := &Delivery{ID: primitive.NewObjectID(), WebhookID: w.ID, Event: e}
+		created, err := d.store.Enqueue(ctx, del)
+		if err != nil {
+			return err
+		}
+		if created {
+			d.retry(w, del, 1, 0)
+		}
+	}
+	return nil
+}
+
+// deliver makes attempt n of delivery del to w and, when it fails,
+// schedules the next one.
+func (d *Dispatcher) deliver(ctx context.Context, w *Webhook, del *Delivery, n int) {
+	a := d.attempt(ctx, w, del)
+	status := StatusDelivered
+	if a.Error != "" {
+		status = StatusPending
+		if n >= d.opts.MaxAttempts {
+			status = StatusFailed
+		}
+	}
+	var wait time.Duration
+	if status == StatusPending {
+		wait = d.backoff(n + 1)
+	}
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+	if err := d.store.RecordAttempt(rctx, del, a, status, a.At.Add(wait)); err != nil {
+		slog.Error("failed to record webhook delivery attempt", "webhook_id", w.ID.Hex(), "delivery_id", del.ID.Hex(), "err", err)
+	}
+	if status == StatusPending {
+		d.retry(w, del, n+1, wait)
+		return
+	}
+	disabled, err := d.store.RecordResult(rctx, w.ID, a.Error, d.opts.DisableAfter)
+	if err != nil {
+		slog.Error("failed to record webhook delivery", "webhook_id", w.ID.Hex(), "delivery_id", del.ID.Hex(), "err", err)
+	}
+	if disabled {
+		slog.Warn("disabled failing webhook", "webhook_id", w.ID.Hex(), "url", w.URL, "failures", d.opts.DisableAfter)
+	}
+}
+
+// attempt POSTs the event of del to w.
+func (d *Dispatcher) attempt(ctx context.Context, w *Webhook, del *Delivery) Attempt {
+	a := Attempt{At: time.Now().UTC()}
+	err := d.post(ctx, w, del, a.At, &a.StatusCode)
+	a.Duration = time.Since(a.At)
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+func (d *Dispatcher) post(ctx context.Context, w *Webhook, del *Delivery, at time.Time, code *int) error {
+	body, err := del.Event.body()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blog-webhooks/1")
+	req.Header.Set(EventHeader, del.Event.Type)
+	req.Header.Set(DeliveryHeader, del.ID.Hex())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(at.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(w.Secret, at, body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Drain a little of the body so the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	*code = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("endpoint answered %s", res.Status)
+	}
+	return nil
+}
+
+// retry schedules attempt n of del after wait. Retries that cannot be
+// scheduled stay recorded as pending and are resumed later, by this
+// dispatcher or another one.
+func (d *Dispatcher) retry(w *Webhook, del *Delivery, n int, wait time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		slog.Warn("left webhook retry pending, shutting down", "webhook_id", w.ID.Hex(), "delivery_id", del.ID.Hex())
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		delete(d.timers, t)
+		d.mu.Unlock()
+		queued := d.pool.Submit(func(ctx context.Context) {
+			d.deliver(ctx, w, del, n)
+		})
+		if !queued {
+			slog.Warn("left webhook retry pending, the queue was full", "webhook_id", w.ID.Hex(), "delivery_id", del.ID.Hex())
+		}
+	})
+	d.timers[t] = true
+}
+
+// backoff returns the wait before attempt n, n > 1.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	b := d.opts.Backoff
+	for i := 2; i < n && b < d.opts.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > d.opts.MaxBackoff {
+		b = d.opts.MaxBackoff
+	}
+	return b
+}
+
+// Close stops scheduling retries. Those waiting are left recorded as
+// pending, with their events, and are made by another dispatcher on the
+// store. Deliveries already queued on the pool finish when the pool
+// is closed. Close waits for resuming pending deliveries to stop until ctx
+// is done at most.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.cancel()
+	var err error
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if len(d.timers) > 0 {
+		slog.Info("left webhook retries pending, shutting down", "count", len(d.timers))
+	}
+	for t := range d.timers {
+		t.Stop()
+	}
+	d.timers = nil
+	return err
+}
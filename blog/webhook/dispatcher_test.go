@@ -0,0 +1,457 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/workerpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore is a Store kept in memory.
+type memoryStore struct {
+	mu         sync.Mutex
+	hooks      []*Webhook
+	deliveries map[primitive.ObjectID]*memoryDelivery
+	// recorded is signalled after every attempt is recorded.
+	recorded chan struct{}
+}
+
+type memoryDelivery struct {
+	del      Delivery
+	status   string
+	next     time.Time
+	attempts []Attempt
+}
+
+func newMemoryStore(hooks ...*Webhook) *memoryStore {
+	return &memoryStore{
+		hooks:      hooks,
+		deliveries: make(map[primitive.ObjectID]*memoryDelivery),
+		recorded:   make(chan struct{}, 100),
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, w *Webhook, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.hooks) >= max {
+		return ErrLimitReached
+	}
+	w.Slot = len(s.hooks)
+	s.hooks = append(s.hooks, w)
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, id primitive.ObjectID) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.hooks {
+		if w.ID == id {
+			cp := *w
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) List(context.Context) ([]*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hooks []*Webhook
+	for _, w := range s.hooks {
+		cp := *w
+		hooks = append(hooks, &cp)
+	}
+	return hooks, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.hooks {
+		if w.ID == id {
+			s.hooks = append(s.hooks[:i], s.hooks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) Subscribed(ctx context.Context, eventType string) ([]*Webhook, error) {
+	hooks, _ := s.List(ctx)
+	var subscribed []*Webhook
+	for _, w := range hooks {
+		if w.Disabled {
+			continue
+		}
+		if len(w.Events) == 0 {
+			subscribed = append(subscribed, w)
+		}
+		for _, e := range w.Events {
+			if e == eventType {
+				subscribed = append(subscribed, w)
+			}
+		}
+	}
+	return subscribed, nil
+}
+
+func (s *memoryStore) Enqueue(_ context.Context, d *Delivery) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, md := range s.deliveries {
+		if md.del.WebhookID == d.WebhookID && md.del.Event.ID == d.Event.ID {
+			return false, nil
+		}
+	}
+	s.deliveries[d.ID] = &memoryDelivery{del: *d, status: StatusPending, next: time.Now()}
+	return true, nil
+}
+
+func (s *memoryStore) RecordAttempt(_ context.Context, d *Delivery, a Attempt, status string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md := s.deliveries[d.ID]
+	if md == nil {
+		md = &memoryDelivery{del: *d}
+		s.deliveries[d.ID] = md
+	}
+	md.status = status
+	md.next = next
+	md.attempts = append(md.attempts, a)
+	s.recorded <- struct{}{}
+	return nil
+}
+
+func (s *memoryStore) Claim(_ context.Context, before time.Time) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []*Delivery
+	for _, md := range s.deliveries {
+		if md.status == StatusPending && md.next.Before(before) {
+			md.next = time.Now()
+			del := md.del
+			del.Attempts = len(md.attempts)
+			claimed = append(claimed, &del)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) RecordResult(_ context.Context, id primitive.ObjectID, failure string, disableAfter int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.hooks {
+		if w.ID != id {
+			continue
+		}
+		if failure == "" {
+			w.ConsecutiveFailures = 0
+			w.LastError = ""
+			return false, nil
+		}
+		w.ConsecutiveFailures++
+		w.LastError = failure
+		if !w.Disabled && w.ConsecutiveFailures >= disableAfter {
+			w.Disabled = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// delivery returns the record of the only delivery made so far.
+func (s *memoryStore) delivery(t *testing.T) *memoryDelivery {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(s.deliveries))
+	}
+	for _, md := range s.deliveries {
+		cp := *md
+		cp.attempts = append([]Attempt(nil), md.attempts...)
+		return &cp
+	}
+	return nil
+}
+
+// waitRecorded waits until n more attempts are recorded.
+func (s *memoryStore) waitRecorded(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.recorded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for attempt %d of %d", i+1, n)
+		}
+	}
+}
+
+// request is a delivery request as received by the endpoint.
+type request struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// endpoint is a webhook receiver answering with the given status codes in
+// turn, the last one repeating.
+type endpoint struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []request
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, request{at: time.Now(), header: r.Header.Clone(), body: body})
+	code := e.codes[len(e.codes)-1]
+	if len(e.requests) <= len(e.codes) {
+		code = e.codes[len(e.requests)-1]
+	}
+	w.WriteHeader(code)
+}
+
+func (e *endpoint) received() []request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]request(nil), e.requests...)
+}
+
+var testOptions = Options{
+	Timeout:      time.Second,
+	MaxAttempts:  3,
+	Backoff:      50 * time.Millisecond,
+	MaxBackoff:   time.Second,
+	DisableAfter: 2,
+	AllowPrivate: true,
+}
+
+func newTestDispatcher(t *testing.T, store Store, opts Options) *Dispatcher {
+	t.Helper()
+	pool := workerpool.New(2, 10)
+	d := NewDispatcher(store, pool, opts)
+	t.Cleanup(func() {
+		d.Close(context.Background())
+		pool.Close(context.Background())
+	})
+	return d
+}
+
+func testEvent() *Event {
+	return &Event{
+		ID:         "60f50e50db60a7737b8b7c44",
+		Type:       BlogCreated,
+		OccurredAt: time.Date(2021, 7, 19, 10, 0, 0, 0, time.UTC),
+		Blog:       json.RawMessage(`{"id":"60f50e50db60a7737b8b7c45","title":"Hello"}`),
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	ep := &endpoint{codes: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+	hook := &Webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "s3cret", ConsecutiveFailures: 1}
+	store := newMemoryStore(hook)
+	d := newTestDispatcher(t, store, testOptions)
+
+	e := testEvent()
+	if err := d.Enqueue(context.Background(), e); err != nil {
+		t.Fatalf("Enqueue() = %v", err)
+	}
+	store.waitRecorded(t, 3)
+
+	reqs := ep.received()
+	if len(reqs) != 3 {
+		t.Fatalf("endpoint got %d requests, want 3", len(reqs))
+	}
+	var delivery string
+	for i, r := range reqs {
+		if !Verify(hook.Secret, r.header.Get(TimestampHeader), r.header.Get(SignatureHeader), r.body) {
+			t.Errorf("request %d: signature %q does not verify", i, r.header.Get(SignatureHeader))
+		}
+		if Verify("other", r.header.Get(TimestampHeader), r.header.Get(SignatureHeader), r.body) {
+			t.Errorf("request %d: signature verifies with the wrong secret", i)
+		}
+		if got := r.header.Get(EventHeader); got != BlogCreated {
+			t.Errorf("request %d: %s = %q, want %q", i, EventHeader, got, BlogCreated)
+		}
+		if i == 0 {
+			delivery = r.header.Get(DeliveryHeader)
+		} else if got := r.header.Get(DeliveryHeader); got != delivery {
+			t.Errorf("request %d: %s = %q, want %q as on the first", i, DeliveryHeader, got, delivery)
+		}
+		var p payload
+		if err := json.Unmarshal(r.body, &p); err != nil {
+			t.Fatalf("request %d: cannot decode the body: %v", i, err)
+		}
+		if p.ID != e.ID || p.Type != e.Type || !p.OccurredAt.Equal(e.OccurredAt) || string(p.Blog) != string(e.Blog) {
+			t.Errorf("request %d: body = %+v, want the event %+v", i, p, e)
+		}
+	}
+	// Backoff, then twice that.
+	for i, want := range []time.Duration{testOptions.Backoff, 2 * testOptions.Backoff} {
+		if gap := reqs[i+1].at.Sub(reqs[i].at); gap < want {
+			t.Errorf("retry %d came after %v, want at least %v", i+1, gap, want)
+		}
+	}
+
+	md := store.delivery(t)
+	if md.status != StatusDelivered {
+		t.Errorf("delivery status = %q, want %q", md.status, StatusDelivered)
+	}
+	if md.del.Event.ID != e.ID || string(md.del.Event.Blog) != string(e.Blog) {
+		t.Errorf("recorded event = %+v, want %+v", md.del.Event, e)
+	}
+	if md.del.ID.Hex() != delivery {
+		t.Errorf("recorded delivery id = %s, want %s", md.del.ID.Hex(), delivery)
+	}
+	wantCodes := []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent}
+	if len(md.attempts) != len(wantCodes) {
+		t.Fatalf("recorded %d attempts, want %d", len(md.attempts), len(wantCodes))
+	}
+	for i, a := range md.attempts {
+		if a.StatusCode != wantCodes[i] {
+			t.Errorf("attempt %d status code = %d, want %d", i, a.StatusCode, wantCodes[i])
+		}
+		if (a.Error == "") != (i == len(wantCodes)-1) {
+			t.Errorf("attempt %d error = %q", i, a.Error)
+		}
+	}
+	w, _ := store.Get(context.Background(), hook.ID)
+	if w.ConsecutiveFailures != 0 || w.Disabled {
+		t.Errorf("webhook has %d failures, disabled %v; want 0, false", w.ConsecutiveFailures, w.Disabled)
+	}
+}
+
+func TestDispatcherDisablesFailingWebhook(t *testing.T) {
+	ep := &endpoint{codes: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+	hook := &Webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "s3cret"}
+	store := newMemoryStore(hook)
+	opts := testOptions
+	opts.MaxAttempts = 1
+	d := newTestDispatcher(t, store, opts)
+
+	for i := 0; i < opts.DisableAfter; i++ {
+		e := testEvent()
+		e.ID = primitive.NewObjectID().Hex()
+		if err := d.Enqueue(context.Background(), e); err != nil {
+			t.Fatalf("Enqueue() = %v", err)
+		}
+		store.waitRecorded(t, 1)
+	}
+	// RecordResult follows RecordAttempt; wait for the last one.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w, _ := store.Get(context.Background(), hook.ID)
+		if w.Disabled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook not disabled after %d failures: %+v", w.ConsecutiveFailures, w)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hooks, _ := store.Subscribed(context.Background(), BlogCreated)
+	if len(hooks) != 0 {
+		t.Errorf("disabled webhook still subscribed")
+	}
+	if err := d.Enqueue(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Enqueue() = %v", err)
+	}
+	d.Close(context.Background())
+	if n := len(ep.received()); n != opts.DisableAfter {
+		t.Errorf("endpoint got %d requests, want %d", n, opts.DisableAfter)
+	}
+}
+
+func TestDispatcherRedrivesPendingDeliveries(t *testing.T) {
+	ep := &endpoint{codes: []int{http.StatusOK}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+	hook := &Webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "s3cret"}
+	store := newMemoryStore(hook)
+	// A delivery whose retry was due long ago, left by a dispatcher that
+	// stopped after one failed attempt.
+	e := testEvent()
+	del := &Delivery{ID: primitive.NewObjectID(), WebhookID: hook.ID, Event: e}
+	a := Attempt{At: time.Now().Add(-time.Hour), StatusCode: http.StatusBadGateway, Error: "endpoint answered 502"}
+	store.RecordAttempt(context.Background(), del, a, StatusPending, a.At.Add(testOptions.Backoff))
+	store.waitRecorded(t, 1)
+
+	newTestDispatcher(t, store, testOptions)
+	store.waitRecorded(t, 1)
+
+	md := store.delivery(t)
+	if md.status != StatusDelivered || len(md.attempts) != 2 {
+		t.Errorf("delivery is %s after %d attempts, want delivered after 2", md.status, len(md.attempts))
+	}
+	reqs := ep.received()
+	if len(reqs) != 1 {
+		t.Fatalf("endpoint got %d requests, want 1", len(reqs))
+	}
+	if got := reqs[0].header.Get(DeliveryHeader); got != del.ID.Hex() {
+		t.Errorf("%s = %q, want %q", DeliveryHeader, got, del.ID.Hex())
+	}
+	var p payload
+	if err := json.Unmarshal(reqs[0].body, &p); err != nil || p.ID != e.ID || string(p.Blog) != string(e.Blog) {
+		t.Errorf("body = %s, want the recorded event", reqs[0].body)
+	}
+}
+
+func TestDispatcherLeavesRecentPendingDeliveries(t *testing.T) {
+	ep := &endpoint{codes: []int{http.StatusOK}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+	hook := &Webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "s3cret"}
+	store := newMemoryStore(hook)
+	// Another dispatcher is about to retry this one.
+	del := &Delivery{ID: primitive.NewObjectID(), WebhookID: hook.ID, Event: testEvent()}
+	a := Attempt{At: time.Now(), Error: "endpoint answered 502"}
+	store.RecordAttempt(context.Background(), del, a, StatusPending, a.At.Add(testOptions.Backoff))
+	store.waitRecorded(t, 1)
+
+	d := newTestDispatcher(t, store, testOptions)
+	d.Close(context.Background())
+	if n := len(ep.received()); n != 0 {
+		t.Errorf("endpoint got %d requests, want 0", n)
+	}
+}
+
+func TestDispatcherEnqueueRecordsPendingDeliveries(t *testing.T) {
+	ep := &endpoint{codes: []int{http.StatusOK}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+	hook := &Webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "s3cret"}
+	store := newMemoryStore(hook)
+	// A dispatcher that stops before making the delivery.
+	d := newTestDispatcher(t, store, testOptions)
+	d.Close(context.Background())
+
+	e := testEvent()
+	for i := 0; i < 2; i++ {
+		if err := d.Enqueue(context.Background(), e); err != nil {
+			t.Fatalf("Enqueue() = %v", err)
+		}
+	}
+	md := store.delivery(t)
+	if md.status != StatusPending || len(md.attempts) != 0 || md.del.Event.ID != e.ID {
+		t.Errorf("delivery of %s is %s after %d attempts, want pending after 0 for %s",
+			md.del.Event.ID, md.status, len(md.attempts), e.ID)
+	}
+	if n := len(ep.received()); n != 0 {
+		t.Errorf("endpoint got %d requests, want 0", n)
+	}
+}
@@ -0,0 +1,362 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/migrations"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned for webhooks that do not exist.
+var ErrNotFound = errors.New("webhook not found")
+
+// ErrLimitReached is returned by Create when the most webhooks allowed are
+// registered already.
+var ErrLimitReached = errors.New("webhook limit reached")
+
+// Webhook is an endpoint subscribed to blog events.
+type Webhook struct {
+	ID  primitive.ObjectID `bson:"_id"`
+	URL string             `bson:"url"`
+	// Slot numbers the webhook among those allowed. Slots are unique, so
+	// concurrent registrations cannot exceed the limit.
+	Slot int `bson:"slot"`
+	// Events are the event types delivered; empty means all of them.
+	Events []string `bson:"events,omitempty"`
+	Secret string   `bson:"secret"`
+	// Disabled webhooks are no longer delivered to.
+	Disabled bool `bson:"disabled"`
+	// ConsecutiveFailures counts the deliveries in a row that failed every
+	// attempt.
+	ConsecutiveFailures int       `bson:"consecutive_failures"`
+	LastError           string    `bson:"last_error,omitempty"`
+	CreatedAt           time.Time `bson:"created_at"`
+	DisabledAt          time.Time `bson:"disabled_at,omitempty"`
+}
+
+// Delivery is the delivery of one event to one webhook.
+type Delivery struct {
+	ID        primitive.ObjectID
+	WebhookID primitive.ObjectID
+	Event     *Event
+	// Attempts is how many requests were made before the delivery was
+	// claimed.
+	Attempts int
+}
+
+// Attempt is one request of a delivery.
+type Attempt struct {
+	At time.Time `bson:"at"`
+	// StatusCode is 0 when no response was received.
+	StatusCode int           `bson:"status_code,omitempty"`
+	Error      string        `bson:"error,omitempty"`
+	Duration   time.Duration `bson:"duration"`
+}
+
+// Delivery states.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Store keeps webhooks and the record of their deliveries, with the events
+// of pending deliveries so they survive a restart.
+type Store interface {
+	// Create stores w in the first free one of max slots, or fails with
+	// ErrLimitReached when they are all taken.
+	Create(ctx context.Context, w *Webhook, max int) error
+	// Get returns webhook id or fails with ErrNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+	// List returns every webhook, oldest first.
+	List(ctx context.Context) ([]*Webhook, error)
+	// Delete removes a webhook or fails with ErrNotFound.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Subscribed returns the enabled webhooks subscribed to eventType.
+	Subscribed(ctx context.Context, eventType string) ([]*Webhook, error)
+	// Enqueue records d as pending with its first attempt due now, unless
+	// a delivery of the same event to the same webhook is recorded
+	// already, as reported by created.
+	Enqueue(ctx context.Context, d *Delivery) (created bool, err error)
+	// RecordAttempt adds an attempt to the record of d, which then has
+	// the given status. next is when the next attempt of a pending
+	// delivery is due.
+	RecordAttempt(ctx context.Context, d *Delivery, a Attempt, status string, next time.Time) error
+	// Claim returns the pending deliveries whose next attempt was due
+	// before before, left behind by a dispatcher that stopped. Each is
+	// claimed by making its next attempt due now, so concurrent calls do
+	// not return the same delivery.
+	Claim(ctx context.Context, before time.Time) ([]*Delivery, error)
+	// RecordResult notes the outcome of a finished delivery to webhook id.
+	// A failed one that makes disableAfter failures in a row disables the
+	// webhook, reported by disabled.
+	RecordResult(ctx context.Context, id primitive.ObjectID, failure string, disableAfter int) (disabled bool, err error)
+}
+
+// MongoStore is the Store kept in the webhooks and webhook deliveries
+// collections of a blog database.
+type MongoStore struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+	timeout    time.Duration
+	retention  time.Duration
+	metrics    *metrics.MongoMetrics
+}
+
+// NewMongoStore returns a MongoStore in db. Each operation may take at
+// most timeout, and delivery records are kept for retention. m may be nil.
+func NewMongoStore(db *mongo.Database, timeout, retention time.Duration, m *metrics.MongoMetrics) *MongoStore {
+	return &MongoStore{
+		webhooks:   db.Collection(migrations.WebhookCollection),
+		deliveries: db.Collection(migrations.WebhookDeliveryCollection),
+		timeout:    timeout,
+		retention:  retention,
+		metrics:    m,
+	}
+}
+
+func (s *MongoStore) Create(ctx context.Context, w *Webhook, max int) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.webhooks.Name(), "insert")
+	defer func() { done(err) }()
+	for {
+		slots, err := s.webhooks.Distinct(ctx, "slot", bson.M{})
+		if err != nil {
+			return err
+		}
+		taken := make(map[int64]bool, len(slots))
+		for _, v := range slots {
+			switch n := v.(type) {
+			case int32:
+				taken[int64(n)] = true
+			case int64:
+				taken[n] = true
+			}
+		}
+		w.Slot = -1
+		for i := 0; i < max; i++ {
+			if !taken[int64(i)] {
+				w.Slot = i
+				break
+			}
+		}
+		if w.Slot < 0 {
+			return ErrLimitReached
+		}
+		_, err = s.webhooks.InsertOne(ctx, w)
+		// A concurrent registration took the slot first.
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+}
+
+func (s *MongoStore) Get(ctx context.Context, id primitive.ObjectID) (_ *Webhook, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.webhooks.Name(), "find")
+	defer func() { done(err) }()
+	w := &Webhook{}
+	err = s.webhooks.FindOne(ctx, bson.M{"_id": id}).Decode(w)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *MongoStore) List(ctx context.Context) ([]*Webhook, error) {
+	return s.find(ctx, bson.M{})
+}
+
+func (s *MongoStore) Subscribed(ctx context.Context, eventType string) ([]*Webhook, error) {
+	return s.find(ctx, bson.M{
+		"disabled": false,
+		"$or": bson.A{
+			bson.M{"events": bson.M{"$exists": false}},
+			bson.M{"events": eventType},
+		},
+	})
+}
+
+func (s *MongoStore) find(ctx context.Context, filter bson.M) (_ []*Webhook, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.webhooks.Name(), "find")
+	defer func() { done(err) }()
+	cur, err := s.webhooks.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var hooks []*Webhook
+	if err := cur.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (s *MongoStore) Delete(ctx context.Context, id primitive.ObjectID) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.webhooks.Name(), "delete")
+	defer func() { done(err) }()
+	res, err := s.webhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoStore) Enqueue(ctx context.Context, d *Delivery) (_ bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.deliveries.Name(), "update")
+	defer func() { done(err) }()
+	now := time.Now().UTC()
+	res, err := s.deliveries.UpdateOne(ctx,
+		bson.M{"event_id": d.Event.ID, "webhook_id": d.WebhookID},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":             d.ID,
+			"event_type":      d.Event.Type,
+			"occurred_at":     d.Event.OccurredAt,
+			"blog":            []byte(d.Event.Blog),
+			"status":          StatusPending,
+			"next_attempt_at": now,
+			"expires_at":      now.Add(s.retention),
+			"attempts":        bson.A{},
+		}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount == 1, nil
+}
+
+func (s *MongoStore) RecordAttempt(ctx context.Context, d *Delivery, a Attempt, status string, next time.Time) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.deliveries.Name(), "update")
+	defer func() { done(err) }()
+	set := bson.M{"status": status}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"webhook_id":  d.WebhookID,
+			"event_id":    d.Event.ID,
+			"event_type":  d.Event.Type,
+			"occurred_at": d.Event.OccurredAt,
+			"blog":        []byte(d.Event.Blog),
+			"expires_at":  a.At.Add(s.retention),
+		},
+		"$set":  set,
+		"$push": bson.M{"attempts": a},
+	}
+	if status == StatusPending {
+		set["next_attempt_at"] = next
+	} else {
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+	}
+	_, err = s.deliveries.UpdateOne(ctx, bson.M{"_id": d.ID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// deliveryDoc is a delivery as stored in the deliveries collection.
+type deliveryDoc struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	WebhookID     primitive.ObjectID `bson:"webhook_id"`
+	EventID       string             `bson:"event_id"`
+	EventType     string             `bson:"event_type"`
+	OccurredAt    time.Time          `bson:"occurred_at"`
+	Blog          []byte             `bson:"blog"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	Attempts      []Attempt          `bson:"attempts"`
+}
+
+func (s *MongoStore) Claim(ctx context.Context, before time.Time) (_ []*Delivery, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.deliveries.Name(), "find")
+	defer func() { done(err) }()
+	// Deliveries recorded before events were kept cannot be made again.
+	cur, err := s.deliveries.Find(ctx, bson.M{
+		"status":          StatusPending,
+		"next_attempt_at": bson.M{"$lt": before},
+		"blog":            bson.M{"$exists": true},
+	}, options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []deliveryDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	var claimed []*Delivery
+	for _, doc := range docs {
+		res, err := s.deliveries.UpdateOne(ctx,
+			bson.M{"_id": doc.ID, "status": StatusPending, "next_attempt_at": doc.NextAttemptAt},
+			bson.M{"$set": bson.M{"next_attempt_at": now}})
+		if err != nil {
+			return claimed, err
+		}
+		if res.ModifiedCount == 0 {
+			// Claimed or attempted by someone else in the meantime.
+			continue
+		}
+		claimed = append(claimed, &Delivery{
+			ID:        doc.ID,
+			WebhookID: doc.WebhookID,
+			Event: &Event{
+				ID:         doc.EventID,
+				Type:       doc.EventType,
+				OccurredAt: doc.OccurredAt,
+				Blog:       doc.Blog,
+			},
+			Attempts: len(doc.Attempts),
+		})
+	}
+	return claimed, nil
+}
+
+func (s *MongoStore) RecordResult(ctx context.Context, id primitive.ObjectID, failure string, disableAfter int) (disabled bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := s.metrics.Start(s.webhooks.Name(), "update")
+	defer func() { done(err) }()
+	if failure == "" {
+		_, err = s.webhooks.UpdateOne(ctx, bson.M{"_id": id},
+			bson.M{"$set": bson.M{"consecutive_failures": 0}, "$unset": bson.M{"last_error": ""}})
+		return false, err
+	}
+	// Only the update that reaches the limit disables the webhook, so it
+	// is reported once.
+	w := &Webhook{}
+	err = s.webhooks.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"consecutive_failures": 1}, "$set": bson.M{"last_error": failure}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(w)
+	if err == mongo.ErrNoDocuments {
+		// Deleted while the delivery was under way.
+		return false, nil
+	}
+	if err != nil || w.Disabled || w.ConsecutiveFailures < disableAfter {
+		return false, err
+	}
+	res, err := s.webhooks.UpdateOne(ctx, bson.M{"_id": id, "disabled": false},
+		bson.M{"$set": bson.M{"disabled": true, "disabled_at": time.Now().UTC()}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -0,0 +1,84 @@
+// Package webhook delivers blog lifecycle events to HTTP endpoints that
+// subscribed to them.
+//
+// Each event is POSTed as JSON to every enabled webhook subscribed to its
+// type. Requests are signed with the webhook's secret, so receivers can
+// check that they come from the blog server, and failed requests are
+// retried with exponential backoff. Every attempt is recorded, and webhooks
+// whose deliveries keep failing are disabled.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Event types.
+const (
+	BlogCreated = "blog.created"
+	BlogUpdated = "blog.updated"
+	BlogDeleted = "blog.deleted"
+)
+
+// Headers set on every delivery.
+const (
+	// EventHeader carries the event type.
+	EventHeader = "X-Blog-Event"
+	// DeliveryHeader identifies the delivery; it is the same on retries.
+	DeliveryHeader = "X-Blog-Delivery"
+	// TimestampHeader carries the Unix time the request was signed at.
+	TimestampHeader = "X-Blog-Timestamp"
+	// SignatureHeader carries "sha256=" followed by the hex encoded
+	// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the
+	// webhook's secret.
+	SignatureHeader = "X-Blog-Signature"
+)
+
+// Event is something that happened to a blog.
+type Event struct {
+	// ID identifies the event. Receivers may see an event more than once
+	// and can use it to ignore repeats.
+	ID         string
+	Type       string
+	OccurredAt time.Time
+	// Blog is the JSON encoded blog after the event, or as it was before
+	// it was deleted.
+	Blog json.RawMessage
+}
+
+// payload is the body of a delivery.
+type payload struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Blog       json.RawMessage `json:"blog"`
+}
+
+func (e *Event) body() ([]byte, error) {
+	return json.Marshal(payload{ID: e.ID, Type: e.Type, OccurredAt: e.OccurredAt.UTC(), Blog: e.Blog})
+}
+
+// Sign returns the value of SignatureHeader for body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, the value of SignatureHeader, is valid
+// for body sent at timestamp, the value of TimestampHeader. Receivers
+// should also reject timestamps too far from their own clock.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	want := Sign(secret, time.Unix(sec, 0), body)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
@@ -8,13 +8,17 @@ import (
 	"time"
 
 	"github.com/akhil4chelsia/grpc-go-microservice/calculator/calcpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 func main() {
-	cc, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	tracer, err := tracing.New(tracing.OptionsFromEnv("calc_client"))
+	checkError(err, "Failed to set up tracing")
+	defer tracer.Shutdown(context.Background())
+	cc, err := grpc.Dial("localhost:50053", grpc.WithInsecure(), tracer.DialOption())
 	checkError(err, "Error while connecting to server.")
 	c := calcpb.NewCalcServiceClient(cc)
 	req := &calcpb.CalcRequest{
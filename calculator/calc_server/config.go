@@ -0,0 +1,68 @@
+package main
+
+import "github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+
+type Config struct {
+	Server    config.Server    `yaml:"server"`
+	Shutdown  config.Shutdown  `yaml:"shutdown"`
+	HTTP      config.HTTP      `yaml:"http"`
+	Health    config.Health    `yaml:"health"`
+	GRPCWeb   config.GRPCWeb   `yaml:"grpc_web"`
+	Metrics   config.Metrics   `yaml:"metrics"`
+	Tracing   config.Tracing   `yaml:"tracing"`
+	Logging   config.Logging   `yaml:"logging"`
+	RateLimit config.RateLimit `yaml:"rate_limit"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: config.Server{
+			Address: "0.0.0.0:50053",
+			TLS: config.TLS{
+				CertFile: "ssl/server.crt",
+				KeyFile:  "ssl/server.pem",
+			},
+		},
+		Shutdown: config.DefaultShutdown,
+		HTTP:     config.HTTP{Address: "localhost:8083"},
+		Health:   config.DefaultHealth,
+		GRPCWeb:  config.DefaultGRPCWeb,
+		Metrics:  config.DefaultMetrics,
+		Tracing:  config.DefaultTracing,
+		Logging:  config.DefaultLogging,
+		RateLimit: config.RateLimit{
+			Enabled: true,
+			Key:     "peer",
+			Methods: []string{"*=20:40", "/calculator.CalcService/PrimeNumberDecomposition=1:5"},
+			Streams: []string{"/calculator.CalcService/PrimeNumberDecomposition=2"},
+		},
+	}
+}
+
+func (c *Config) Validate() error {
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	if err := c.Shutdown.Validate(); err != nil {
+		return err
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	if err := c.Health.Validate(); err != nil {
+		return err
+	}
+	if err := c.GRPCWeb.Validate(c.HTTP, c.Server.TLS); err != nil {
+		return err
+	}
+	if err := c.Metrics.Validate(c.HTTP); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	return c.RateLimit.Validate()
+}
@@ -7,9 +7,18 @@ import (
 	"log"
 	"math"
 	"net"
+	"net/http"
 	"time"
 
 	"github.com/akhil4chelsia/grpc-go-microservice/calculator/calcpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/grpcweb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/healthcheck"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/lifecycle"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/ratelimit"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -19,7 +28,7 @@ type server struct {
 }
 
 func (s *server) Sum(ctx context.Context, req *calcpb.CalcRequest) (*calcpb.CalcResponse, error) {
-	fmt.Println("Calculating sum...")
+	logging.FromContext(ctx).Debug("calculating sum", "x", req.X, "y", req.Y)
 	return &calcpb.CalcResponse{
 		Result: req.X + req.Y,
 	}, nil
@@ -43,7 +52,6 @@ func (s *server) PrimeNumberDecomposition(req *calcpb.PrimeNumberDecompositionRe
 }
 
 func (s *server) CalcAverage(stream calcpb.CalcService_CalcAverageServer) error {
-	fmt.Println("Calculating average with stream of numbers.")
 	var sum float32 = 0
 	var count float32 = 0
 	for {
@@ -54,7 +62,8 @@ func (s *server) CalcAverage(stream calcpb.CalcService_CalcAverageServer) error
 			})
 		}
 		if err != nil {
-			log.Fatal("Error while receiving stream.")
+			logging.FromContext(stream.Context()).Error("failed to receive number", "err", err)
+			return err
 		}
 		sum = sum + float32(req.GetNumber())
 		count = count + 1
@@ -72,7 +81,8 @@ func (s *server) FindMax(stream calcpb.CalcService_FindMaxServer) error {
 			return nil
 		}
 		if err != nil {
-			log.Fatalf("error while receiving stream %v", err)
+			logging.FromContext(stream.Context()).Error("failed to receive number", "err", err)
+			return err
 		}
 
 		if req.GetNumber() > int32(max) {
@@ -81,7 +91,8 @@ func (s *server) FindMax(stream calcpb.CalcService_FindMaxServer) error {
 				Max: float64(max),
 			})
 			if sendErr != nil {
-				log.Fatalf("Error while sending response %s", sendErr)
+				logging.FromContext(stream.Context()).Error("failed to send max", "err", sendErr)
+				return sendErr
 			}
 		}
 	}
@@ -104,20 +115,76 @@ func (s *server) SquareRoot(ctx context.Context, req *calcpb.SquareRootRequest)
 }
 
 func main() {
-	fmt.Println("Starting calc server...")
-	lis, err := net.Listen("tcp", "0.0.0.0:50051")
-	checkError(err, "Error while starting listner.")
-
-	s := grpc.NewServer()
-	calcpb.RegisterCalcServiceServer(s, &server{})
-	if err := s.Serve(lis); err != nil {
-		checkError(err, "Failed to start grpc server.")
+	cfg := defaultConfig()
+	config.MustLoad("CALC", cfg)
+	logger, logLevel, err := logging.Setup(cfg.Logging.Options())
+	if err != nil {
+		log.Fatalf("Failed to set up logging %v", err)
 	}
 
-}
+	lis, err := net.Listen("tcp", cfg.Server.Address)
+	if err != nil {
+		logging.Fatal("error while starting listener", "err", err)
+	}
+	opts, err := cfg.Server.ServerOptions()
+	if err != nil {
+		logging.Fatal("failed loading ssl certificates", "err", err)
+	}
 
-func checkError(err error, message string) {
+	tracer, err := tracing.New(cfg.Tracing.Options("calc_server"))
 	if err != nil {
-		log.Printf("%s , %v", message, err)
+		logging.Fatal("failed to set up tracing", "err", err)
+	}
+	unary := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(logger),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		logging.StreamServerInterceptor(logger),
+	}
+	reg := metrics.NewRegistry()
+	var serverMetrics *metrics.ServerMetrics
+	if cfg.Metrics.Enabled {
+		serverMetrics = metrics.NewServerMetrics(reg)
+		unary = append(unary, serverMetrics.UnaryServerInterceptor())
+		stream = append(stream, serverMetrics.StreamServerInterceptor())
+	}
+	if cfg.RateLimit.Enabled {
+		limiter := ratelimit.New(cfg.RateLimit.Options())
+		unary = append(unary, limiter.UnaryServerInterceptor())
+		stream = append(stream, limiter.StreamServerInterceptor())
+	}
+	opts = append(opts, tracer.ServerOption(), grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s := grpc.NewServer(opts...)
+	calcpb.RegisterCalcServiceServer(s, &server{})
+	if serverMetrics != nil {
+		serverMetrics.InitializeMetrics(s)
+	}
+	checker := healthcheck.Register(s)
+	runner := lifecycle.New(s, lis, cfg.Shutdown.DrainTimeout, cfg.Shutdown.CloseTimeout)
+	// Registered first so it is closed last and flushes the spans of
+	// everything shut down before it.
+	runner.OnClose("tracer", tracer.Shutdown)
+	runner.SetHealth(checker)
+	runner.Go(func(ctx context.Context) {
+		checker.Run(ctx, cfg.Health.Interval, cfg.Health.Timeout)
+	})
+	if cfg.HTTP.Address != "" {
+		mux := http.NewServeMux()
+		checker.RegisterHTTP(mux)
+		logging.RegisterHTTP(mux, logLevel, cfg.Logging.LevelToken)
+		if cfg.Metrics.Enabled {
+			metrics.RegisterHTTP(mux, reg)
+		}
+		var handler http.Handler = mux
+		if cfg.GRPCWeb.Enabled {
+			handler = grpcweb.New(s, cfg.GRPCWeb.CORSOptions()).Wrap(mux)
+		}
+		if err := runner.AddHTTPServer(cfg.HTTP.Address, handler); err != nil {
+			logging.Fatal("failed to start HTTP listener", "err", err)
+		}
+	}
+	logger.Info("starting calc server", "address", cfg.Server.Address, "http_address", cfg.HTTP.Address)
+	if err := runner.Run(); err != nil {
+		logging.Fatal("failed to start server", "err", err)
 	}
 }
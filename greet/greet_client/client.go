@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/akhil4chelsia/grpc-go-microservice/greet/greetpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -26,7 +27,12 @@ func main() {
 		}
 		opts = grpc.WithTransportCredentials(creds)
 	}
-	cc, err := grpc.Dial("localhost:50051", opts)
+	tracer, err := tracing.New(tracing.OptionsFromEnv("greet_client"))
+	if err != nil {
+		log.Fatalf("Failed to set up tracing %v", err)
+	}
+	defer tracer.Shutdown(context.Background())
+	cc, err := grpc.Dial("localhost:50052", opts, tracer.DialOption())
 
 	if err != nil {
 		log.Fatalf("Could not connect to server. %v", err)
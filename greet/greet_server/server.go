@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/akhil4chelsia/grpc-go-microservice/greet/greetpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/config"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/grpcweb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/healthcheck"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/lifecycle"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/metrics"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/ratelimit"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
@@ -21,7 +28,7 @@ type server struct {
 }
 
 func (s *server) Greet(ctx context.Context, req *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
-	fmt.Println("Invoked Green() on server.")
+	logging.FromContext(ctx).Debug("greeting", "first_name", req.GetGreeting().GetFirstName())
 	firstname := req.GetGreeting().GetFirstName()
 	result := "Hello, " + firstname
 	res := &greetpb.GreetResponse{
@@ -31,7 +38,7 @@ func (s *server) Greet(ctx context.Context, req *greetpb.GreetRequest) (*greetpb
 }
 
 func (s *server) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greetpb.GreetService_GreetManyTimesServer) error {
-	fmt.Print("Greet many times invoked.")
+	logging.FromContext(stream.Context()).Debug("greeting many times", "first_name", req.GetFirstName())
 	first_name := req.FirstName
 	for i := 0; i < 10; i++ {
 		res := &greetpb.GreetManyTimesResponse{
@@ -44,7 +51,6 @@ func (s *server) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greet
 }
 
 func (s *server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
-	fmt.Println("Invoking LongGreet on server.")
 	result := ""
 	for {
 		req, err := stream.Recv()
@@ -54,7 +60,8 @@ func (s *server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
 			})
 		}
 		if err != nil {
-			log.Fatalf("Error while reading stream.")
+			logging.FromContext(stream.Context()).Error("failed to receive greeting", "err", err)
+			return err
 		}
 		result = result + "Hello " + req.GetFirstName() + ", "
 	}
@@ -68,14 +75,15 @@ func (s *server) GreetEveryone(stream greetpb.GreetService_GreetEveryoneServer)
 			return nil
 		}
 		if err != nil {
-			log.Fatal("error while receiving stream")
+			logging.FromContext(stream.Context()).Error("failed to receive greeting", "err", err)
+			return err
 		}
 		sendErr := stream.Send(&greetpb.GreetEveryoneResponse{
 			Result: "Hello, " + req.GetFirstName(),
 		})
 
 		if sendErr != nil {
-			log.Fatalf("Error while sending response, %v", sendErr)
+			logging.FromContext(stream.Context()).Error("failed to send greeting", "err", sendErr)
 			return sendErr
 		}
 	}
@@ -85,7 +93,7 @@ func (s *server) GreetWithDeadline(ctx context.Context, req *greetpb.GreetWithDe
 
 	for i := 0; i < 3; i++ {
 		if ctx.Err() == context.Canceled {
-			fmt.Println("client cancelled the request.")
+			logging.FromContext(ctx).Info("client cancelled the request")
 			return nil, status.Error(codes.Canceled, "Deadline exceeded for client request.")
 		}
 		time.Sleep(1 * time.Second)
@@ -97,29 +105,79 @@ func (s *server) GreetWithDeadline(ctx context.Context, req *greetpb.GreetWithDe
 
 func main() {
 
-	lis, err := net.Listen("tcp", "localhost:50051")
+	cfg := defaultConfig()
+	config.MustLoad("GREET", cfg)
+	logger, logLevel, err := logging.Setup(cfg.Logging.Options())
 	if err != nil {
-		log.Fatalf("Failed to start listner. %v", err)
-	}
-	tls := false
-	opts := []grpc.ServerOption{}
-	if tls {
-		certFile := "ssl/server.crt"
-		keyFile := "ssl/server.pem"
-		creds, sslErr := credentials.NewServerTLSFromFile(certFile, keyFile)
-		if sslErr != nil {
-			log.Fatalf("Failed loading ssl certificates %v", sslErr)
-			return
-		}
-		opts = append(opts, grpc.Creds(creds))
+		log.Fatalf("Failed to set up logging %v", err)
 	}
 
+	lis, err := net.Listen("tcp", cfg.Server.Address)
+	if err != nil {
+		logging.Fatal("failed to start listener", "err", err)
+	}
+	opts, err := cfg.Server.ServerOptions()
+	if err != nil {
+		logging.Fatal("failed loading ssl certificates", "err", err)
+	}
+
+	tracer, err := tracing.New(cfg.Tracing.Options("greet_server"))
+	if err != nil {
+		logging.Fatal("failed to set up tracing", "err", err)
+	}
+	unary := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(logger),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		logging.StreamServerInterceptor(logger),
+	}
+	reg := metrics.NewRegistry()
+	var serverMetrics *metrics.ServerMetrics
+	if cfg.Metrics.Enabled {
+		serverMetrics = metrics.NewServerMetrics(reg)
+		unary = append(unary, serverMetrics.UnaryServerInterceptor())
+		stream = append(stream, serverMetrics.StreamServerInterceptor())
+	}
+	if cfg.RateLimit.Enabled {
+		limiter := ratelimit.New(cfg.RateLimit.Options())
+		unary = append(unary, limiter.UnaryServerInterceptor())
+		stream = append(stream, limiter.StreamServerInterceptor())
+	}
+	opts = append(opts, tracer.ServerOption(), grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
 	s := grpc.NewServer(opts...)
 	greetpb.RegisterGreetServiceServer(s, &server{})
 	reflection.Register(s)
+	if serverMetrics != nil {
+		serverMetrics.InitializeMetrics(s)
+	}
+	checker := healthcheck.Register(s)
+
+	runner := lifecycle.New(s, lis, cfg.Shutdown.DrainTimeout, cfg.Shutdown.CloseTimeout)
+	// Registered first so it is closed last and flushes the spans of
+	// everything shut down before it.
+	runner.OnClose("tracer", tracer.Shutdown)
+	runner.SetHealth(checker)
+	runner.Go(func(ctx context.Context) {
+		checker.Run(ctx, cfg.Health.Interval, cfg.Health.Timeout)
+	})
+	if cfg.HTTP.Address != "" {
+		mux := http.NewServeMux()
+		checker.RegisterHTTP(mux)
+		logging.RegisterHTTP(mux, logLevel, cfg.Logging.LevelToken)
+		if cfg.Metrics.Enabled {
+			metrics.RegisterHTTP(mux, reg)
+		}
+		var handler http.Handler = mux
+		if cfg.GRPCWeb.Enabled {
+			handler = grpcweb.New(s, cfg.GRPCWeb.CORSOptions()).Wrap(mux)
+		}
+		if err := runner.AddHTTPServer(cfg.HTTP.Address, handler); err != nil {
+			logging.Fatal("failed to start HTTP listener", "err", err)
+		}
+	}
 
-	fmt.Println("Starting geeting server...")
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to start server. %v", err)
+	logger.Info("starting greet server", "address", cfg.Server.Address, "http_address", cfg.HTTP.Address)
+	if err := runner.Run(); err != nil {
+		logging.Fatal("failed to start server", "err", err)
 	}
 }
@@ -0,0 +1,111 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStore keeps blobs in a GridFS bucket, as files named after their
+// keys. Two concurrent puts of a key may both store it; readers get the
+// newest and Delete removes both.
+type GridFSStore struct {
+	db     *mongo.Database
+	bucket string
+}
+
+// NewGridFSStore returns a GridFSStore using the bucket named bucket in db.
+func NewGridFSStore(db *mongo.Database, bucket string) *GridFSStore {
+	return &GridFSStore{db: db, bucket: bucket}
+}
+
+// open returns the bucket with deadlines taken from ctx. The driver's
+// GridFS API takes deadlines rather than contexts, set per bucket, so each
+// operation gets a bucket of its own.
+func (s *GridFSStore) open(ctx context.Context) (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(s.db, options.GridFSBucket().SetName(s.bucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := b.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if err := b.SetWriteDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if ok, err := s.Exists(ctx, key); err != nil || ok {
+		return err
+	}
+	b, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = b.UploadFromStream(key, contextReader{ctx, r})
+	return err
+}
+
+func (s *GridFSStore) Exists(ctx context.Context, key string) (bool, error) {
+	if err := validKey(key); err != nil {
+		return false, err
+	}
+	b, err := s.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := b.GetFilesCollection().CountDocuments(ctx, bson.M{"filename": key}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (s *GridFSStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	b, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := b.OpenDownloadStreamByName(key)
+	if err == gridfs.ErrFileNotFound {
+		return nil, ErrNotFound
+	}
+	return ds, err
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	b, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	cur, err := b.GetFilesCollection().Find(ctx, bson.M{"filename": key}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cur.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := b.Delete(f.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// Package blob stores immutable blobs of bytes under keys chosen by the
+// caller, typically a hash of their content, on the local filesystem or in
+// Mongo GridFS.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned by Store.Open when no blob has the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store persists blobs. Keys are made of lowercase letters and digits.
+type Store interface {
+	// Put stores the content of r under key. Keys name their content, so
+	// putting a key again, even concurrently, stores nothing new that
+	// readers could tell apart.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Exists reports whether a blob is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Open returns a reader of the blob stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key, if there is one.
+	Delete(ctx context.Context, key string) error
+}
+
+func validKey(key string) error {
+	if key == "" {
+		return errors.New("blob key is empty")
+	}
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return fmt.Errorf("blob key %q has characters other than lowercase letters and digits", key)
+		}
+	}
+	return nil
+}
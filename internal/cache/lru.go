@@ -0,0 +1,146 @@
+// Package cache provides the in-process LRU cache used to keep hot data out
+// of the database, and the statistics caches report.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats counts the lookups a cache has served since it was created.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// LRU is a fixed size cache evicting the least recently used entry when
+// full. Entries also expire ttl after they were added. It is safe for
+// concurrent use.
+type LRU struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	stats Stats
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewLRU returns a cache holding at most size entries for at most ttl each.
+// A zero ttl keeps entries until they are evicted.
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns the value stored for key and marks it recently used.
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && !time.Now().Before(e.expires) {
+		c.removeElement(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Add stores value for key, replacing any previous value, and evicts the
+// least recently used entry if the cache is over its size.
+func (c *LRU) Add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, c.expiry()
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.push(key, value)
+}
+
+// ContainsOrAdd adds value for key unless the cache already holds an
+// unexpired entry for it, reporting whether it did. Unlike Get followed by
+// Add it is atomic, and it neither refreshes the entry nor counts towards
+// the hit and miss statistics.
+func (c *LRU) ContainsOrAdd(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		if c.ttl <= 0 || time.Now().Before(el.Value.(*entry).expires) {
+			return true
+		}
+		c.removeElement(el)
+	}
+	c.push(key, value)
+	return false
+}
+
+// Remove drops key from the cache.
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Len returns the number of entries, including expired ones not yet
+// dropped.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats returns the cache's counters.
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.ll.Len()
+	return s
+}
+
+// push adds a new entry for key, evicting the least recently used ones
+// while the cache is over its size.
+func (c *LRU) push(key string, value interface{}) {
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: c.expiry()})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRU) expiry() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
@@ -0,0 +1,223 @@
+// Package config loads server configuration from defaults, a YAML file,
+// environment variables and command line flags.
+//
+// A config is a plain struct whose exported fields are named by their yaml
+// tags. Every leaf field can be set as
+//
+//	server:                     # in the YAML file
+//	  address: localhost:50051
+//	BLOG_SERVER_ADDRESS=...     # environment, prefixed with the service name
+//	--server-address=...        # command line flag
+//
+// Later sources win: defaults < file < environment < flags. The file is
+// chosen with --config or <PREFIX>_CONFIG.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Option changes how Load reads a configuration.
+type Option func(*loadOptions)
+
+type loadOptions struct {
+	allowUnknownFields bool
+}
+
+// AllowUnknownFields makes YAML files with keys the config struct does not
+// know about load without error. Tools that read only part of a server's
+// configuration file use it.
+func AllowUnknownFields() Option {
+	return func(o *loadOptions) { o.allowUnknownFields = true }
+}
+
+// Validator is implemented by config structs (and sections) that can check
+// themselves after loading.
+type Validator interface {
+	Validate() error
+}
+
+// Load fills cfg, which must point to a struct already holding the defaults.
+// It returns the positional arguments left after the flags and reports
+// whether --print-config was given.
+func Load(prefix string, cfg interface{}, args []string, opts ...Option) (rest []string, printConfig bool, err error) {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fields, err := collectFields(cfg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	fs := flag.NewFlagSet(strings.ToLower(prefix), flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(prefix+"_CONFIG"), "path to a YAML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		fs.Var(&pendingValue{f: f, values: flagValues}, f.flagName(), f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg, o.allowUnknownFields); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, f := range fields {
+		if s, ok := os.LookupEnv(f.envName(prefix)); ok {
+			if err := f.set(s); err != nil {
+				return nil, false, fmt.Errorf("%s: %v", f.envName(prefix), err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if s, ok := flagValues[f.path]; ok {
+			if err := f.set(s); err != nil {
+				return nil, false, fmt.Errorf("--%s: %v", f.flagName(), err)
+			}
+		}
+	}
+	return fs.Args(), printConfig, nil
+}
+
+// MustLoad loads and validates cfg from os.Args and the environment, exiting
+// the process on error. With --print-config it prints the configuration and
+// exits. It returns the positional arguments left after the flags.
+func MustLoad(prefix string, cfg interface{}, opts ...Option) []string {
+	args, printConfig, err := Load(prefix, cfg, os.Args[1:], opts...)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("Failed to load configuration. %v", err)
+	}
+	if printConfig {
+		if err := Print(os.Stdout, cfg); err != nil {
+			log.Fatalf("Failed to print configuration. %v", err)
+		}
+		os.Exit(0)
+	}
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			log.Fatalf("Invalid configuration. %v", err)
+		}
+	}
+	return args
+}
+
+func loadFile(path string, cfg interface{}, allowUnknownFields bool) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(!allowUnknownFields)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Print writes cfg as YAML, replacing secret values. Fields tagged
+// secret:"true" are hidden entirely, fields tagged secret:"url" keep
+// everything but the password.
+func Print(w io.Writer, cfg interface{}) error {
+	fields, err := collectFields(cfg)
+	if err != nil {
+		return err
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range fields {
+		node := root
+		parts := strings.Split(f.path, ".")
+		for _, part := range parts[:len(parts)-1] {
+			node = child(node, part)
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: redact(f), Style: scalarStyle(f)},
+		)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func child(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	c := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, c)
+	return c
+}
+
+func scalarStyle(f *field) yaml.Style {
+	if f.value.Kind() == reflect.String && f.value.String() == "" {
+		return yaml.DoubleQuotedStyle
+	}
+	return 0
+}
+
+const redacted = "REDACTED"
+
+func redact(f *field) string {
+	s := f.String()
+	switch {
+	case s == "" || f.secret == "":
+		return s
+	case f.secret == "url":
+		u, err := url.Parse(s)
+		if err != nil {
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		return u.String()
+	}
+	return redacted
+}
+
+// pendingValue records a flag's raw value so it can be applied after the
+// file and environment.
+type pendingValue struct {
+	f      *field
+	values map[string]string
+}
+
+func (p *pendingValue) String() string {
+	if p == nil || p.f == nil {
+		return ""
+	}
+	return redact(p.f)
+}
+
+func (p *pendingValue) Set(s string) error {
+	p.values[p.f.path] = s
+	return nil
+}
+
+// IsBoolFlag lets boolean fields be given as a bare --flag.
+func (p *pendingValue) IsBoolFlag() bool {
+	return p.f.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is a single configurable leaf value found by walking a config struct.
+type field struct {
+	path   string // dotted yaml path, e.g. "server.tls.enabled"
+	usage  string
+	secret string // "", "true" or "url"
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// collectFields walks the exported fields of the struct pointed to by cfg and
+// returns every leaf value that can be set from a string.
+func collectFields(cfg interface{}) ([]*field, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: expected pointer to struct, got %T", cfg)
+	}
+	var fields []*field
+	if err := walk(v.Elem(), "", &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func walk(v reflect.Value, prefix string, out *[]*field) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			if err := walk(fv, path, out); err != nil {
+				return err
+			}
+			continue
+		}
+		if !settable(fv.Type()) {
+			return fmt.Errorf("config: unsupported type %s for %s", fv.Type(), path)
+		}
+		*out = append(*out, &field{
+			path:   path,
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret"),
+			value:  fv,
+		})
+	}
+	return nil
+}
+
+func yamlName(sf reflect.StructField) string {
+	tag := sf.Tag.Get("yaml")
+	if tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return strings.ToLower(sf.Name)
+}
+
+func settable(t reflect.Type) bool {
+	if t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String || t.Elem().Kind() == reflect.Int
+	}
+	return false
+}
+
+// set parses s according to the field's type and stores it.
+func (f *field) set(s string) error {
+	v := f.value
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if v.Type().Elem().Kind() == reflect.String {
+			v.Set(reflect.ValueOf(items))
+			return nil
+		}
+		ints := make([]int, len(items))
+		for i, item := range items {
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.path, err)
+			}
+			ints[i] = n
+		}
+		v.Set(reflect.ValueOf(ints))
+	}
+	return nil
+}
+
+// String formats the current value the way set accepts it.
+func (f *field) String() string {
+	v := f.value
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagName turns "server.tls.enabled" into "server-tls-enabled".
+func (f *field) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(f.path)
+}
+
+// envName turns "server.tls.enabled" into "<PREFIX>_SERVER_TLS_ENABLED".
+func (f *field) envName(prefix string) string {
+	return prefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(f.path))
+}
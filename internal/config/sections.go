@@ -0,0 +1,417 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/grpcweb"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/ratelimit"
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Server is the gRPC listener configuration shared by every server.
+type Server struct {
+	Address string `yaml:"address" usage:"host:port the gRPC server listens on"`
+	TLS     TLS    `yaml:"tls"`
+}
+
+func (s Server) Validate() error {
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("server.address: %v", err)
+	}
+	return s.TLS.Validate()
+}
+
+// TLS enables transport security on the gRPC listener.
+type TLS struct {
+	Enabled  bool   `yaml:"enabled" usage:"serve gRPC over TLS"`
+	CertFile string `yaml:"cert_file" usage:"PEM certificate used when TLS is enabled"`
+	KeyFile  string `yaml:"key_file" usage:"PEM private key used when TLS is enabled"`
+	// ClientCAFile makes the server require client certificates signed by
+	// one of its CAs.
+	ClientCAFile string `yaml:"client_ca_file" usage:"PEM CA certificates client certificates must be signed by, empty to not ask clients for one"`
+}
+
+func (t TLS) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return errors.New("server.tls: cert_file and key_file are required when tls is enabled")
+	}
+	for _, f := range []string{t.CertFile, t.KeyFile, t.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("server.tls: %v", err)
+		}
+	}
+	return nil
+}
+
+// Mongo selects the MongoDB deployment and database.
+type Mongo struct {
+	URI      string `yaml:"uri" secret:"url" usage:"MongoDB connection string"`
+	Database string `yaml:"database" usage:"MongoDB database name"`
+}
+
+func (m Mongo) Validate() error {
+	if !strings.HasPrefix(m.URI, "mongodb://") && !strings.HasPrefix(m.URI, "mongodb+srv://") {
+		return errors.New("mongo.uri: must start with mongodb:// or mongodb+srv://")
+	}
+	if m.Database == "" {
+		return errors.New("mongo.database: is required")
+	}
+	return nil
+}
+
+// DialAddress turns a listen address into one a local client can dial,
+// replacing an unspecified host such as 0.0.0.0 with localhost.
+func DialAddress(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// ServerOptions returns the grpc.ServerOptions implied by the configuration.
+func (s Server) ServerOptions() ([]grpc.ServerOption, error) {
+	if !s.TLS.Enabled {
+		return nil, nil
+	}
+	if s.TLS.ClientCAFile == "" {
+		creds, err := credentials.NewServerTLSFromFile(s.TLS.CertFile, s.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return []grpc.ServerOption{grpc.Creds(creds)}, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(s.TLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", s.TLS.ClientCAFile)
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	return []grpc.ServerOption{grpc.Creds(creds)}, nil
+}
+
+// Shutdown bounds how long a server takes to stop.
+type Shutdown struct {
+	DrainTimeout time.Duration `yaml:"drain_timeout" usage:"how long in-flight calls may run after a shutdown signal before they are cut off"`
+	CloseTimeout time.Duration `yaml:"close_timeout" usage:"how long to wait for background jobs and for closing each resource"`
+}
+
+// DefaultShutdown is used by every server unless configured otherwise.
+var DefaultShutdown = Shutdown{
+	DrainTimeout: 15 * time.Second,
+	CloseTimeout: 5 * time.Second,
+}
+
+func (s Shutdown) Validate() error {
+	if s.DrainTimeout <= 0 {
+		return errors.New("shutdown.drain_timeout: must be positive")
+	}
+	if s.CloseTimeout <= 0 {
+		return errors.New("shutdown.close_timeout: must be positive")
+	}
+	return nil
+}
+
+// HTTP is the optional HTTP listener serving health and other endpoints next
+// to the gRPC server. An empty address disables it.
+type HTTP struct {
+	Address string `yaml:"address" usage:"host:port of the HTTP listener, empty to disable"`
+}
+
+func (h HTTP) Validate() error {
+	if h.Address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(h.Address); err != nil {
+		return fmt.Errorf("http.address: %v", err)
+	}
+	return nil
+}
+
+// Site describes the public website blogs are shown on, which links handed
+// out to readers, such as in feeds and sitemaps, point at.
+type Site struct {
+	BaseURL     string `yaml:"base_url" usage:"public URL of the site; blog pages are served under <base_url>/blogs/<slug>"`
+	Title       string `yaml:"title" usage:"name of the site, used as the title of feeds"`
+	Description string `yaml:"description" usage:"one line description of the site"`
+}
+
+// DefaultSite points at the HTTP listener's default address.
+var DefaultSite = Site{
+	BaseURL: "http://localhost:8081",
+	Title:   "Blog",
+}
+
+func (s Site) Validate() error {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("site.base_url: must be an absolute http or https URL, got %q", s.BaseURL)
+	}
+	if strings.HasSuffix(s.BaseURL, "/") {
+		return errors.New("site.base_url: must not end in a slash")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("site.base_url: must not have a query or fragment")
+	}
+	if s.Title == "" {
+		return errors.New("site.title: must not be empty")
+	}
+	return nil
+}
+
+// BlogURL returns the address of the page showing the blog with slug.
+func (s Site) BlogURL(slug string) string {
+	return s.BaseURL + "/blogs/" + url.PathEscape(slug)
+}
+
+// Health controls how often dependencies are probed.
+type Health struct {
+	Interval time.Duration `yaml:"interval" usage:"how often dependencies are probed"`
+	Timeout  time.Duration `yaml:"timeout" usage:"how long a single dependency probe may take"`
+}
+
+// DefaultHealth is used by every server unless configured otherwise.
+var DefaultHealth = Health{
+	Interval: 10 * time.Second,
+	Timeout:  2 * time.Second,
+}
+
+func (h Health) Validate() error {
+	if h.Interval <= 0 {
+		return errors.New("health.interval: must be positive")
+	}
+	if h.Timeout <= 0 || h.Timeout > h.Interval {
+		return errors.New("health.timeout: must be positive and no longer than health.interval")
+	}
+	return nil
+}
+
+// GRPCWeb serves the gRPC services to browsers over the HTTP listener using
+// the gRPC-Web protocol.
+type GRPCWeb struct {
+	Enabled        bool          `yaml:"enabled" usage:"serve gRPC-Web calls on the HTTP listener"`
+	AllowedOrigins []string      `yaml:"allowed_origins" usage:"origins allowed to make cross-origin gRPC-Web calls, * for any"`
+	AllowedHeaders []string      `yaml:"allowed_headers" usage:"extra request headers browsers may send on cross-origin calls"`
+	MaxAge         time.Duration `yaml:"max_age" usage:"how long browsers may cache a CORS preflight result"`
+}
+
+// DefaultGRPCWeb is used by every server unless configured otherwise.
+var DefaultGRPCWeb = GRPCWeb{
+	MaxAge: 10 * time.Minute,
+}
+
+func (g GRPCWeb) Validate(h HTTP, tls TLS) error {
+	if g.Enabled && h.Address == "" {
+		return errors.New("grpc_web.enabled: requires http.address")
+	}
+	if g.Enabled && tls.ClientCAFile != "" {
+		// The HTTP listener does not ask for client certificates, so
+		// gRPC-Web calls would get past the mutual TLS check.
+		return errors.New("grpc_web.enabled: is not supported with server.tls.client_ca_file")
+	}
+	if g.MaxAge < 0 {
+		return errors.New("grpc_web.max_age: must not be negative")
+	}
+	for _, o := range g.AllowedOrigins {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			return fmt.Errorf("grpc_web.allowed_origins: %q is not * or an http(s) origin", o)
+		}
+	}
+	return nil
+}
+
+// CORSOptions returns the grpcweb.CORSOptions implied by the configuration.
+func (g GRPCWeb) CORSOptions() grpcweb.CORSOptions {
+	return grpcweb.CORSOptions{
+		AllowedOrigins: g.AllowedOrigins,
+		AllowedHeaders: g.AllowedHeaders,
+		MaxAge:         g.MaxAge,
+	}
+}
+
+// Metrics exposes Prometheus metrics at /metrics on the HTTP listener.
+type Metrics struct {
+	Enabled bool `yaml:"enabled" usage:"record RPC metrics and serve them at /metrics on the HTTP listener"`
+}
+
+// DefaultMetrics is used by every server unless configured otherwise.
+var DefaultMetrics = Metrics{Enabled: true}
+
+func (m Metrics) Validate(h HTTP) error {
+	if m.Enabled && h.Address == "" {
+		return errors.New("metrics.enabled: requires http.address")
+	}
+	return nil
+}
+
+// Tracing selects where OpenTelemetry compatible traces are exported.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" usage:"trace exporter: none, stdout, file or otlp"`
+	Endpoint    string  `yaml:"endpoint" usage:"OTLP/HTTP traces URL used by the otlp exporter"`
+	File        string  `yaml:"file" usage:"file the file exporter appends JSON spans to"`
+	SampleRatio float64 `yaml:"sample_ratio" usage:"fraction of new traces recorded, from 0 to 1"`
+}
+
+// DefaultTracing is used by every server unless configured otherwise.
+var DefaultTracing = Tracing{
+	Exporter:    tracing.ExporterNone,
+	Endpoint:    tracing.DefaultEndpoint,
+	File:        "traces.jsonl",
+	SampleRatio: 1,
+}
+
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if t.File == "" {
+			return errors.New("tracing.file: is required by the file exporter")
+		}
+	case tracing.ExporterOTLP:
+		if !strings.HasPrefix(t.Endpoint, "http://") && !strings.HasPrefix(t.Endpoint, "https://") {
+			return errors.New("tracing.endpoint: must be an http(s) URL")
+		}
+	default:
+		return fmt.Errorf("tracing.exporter: must be none, stdout, file or otlp, got %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("tracing.sample_ratio: must be between 0 and 1")
+	}
+	return nil
+}
+
+// Options returns the tracing.Options of service implied by the
+// configuration.
+func (t Tracing) Options(service string) tracing.Options {
+	return tracing.Options{
+		ServiceName: service,
+		Exporter:    t.Exporter,
+		Endpoint:    t.Endpoint,
+		File:        t.File,
+		SampleRatio: t.SampleRatio,
+	}
+}
+
+// Logging configures the structured server log.
+type Logging struct {
+	Format string   `yaml:"format" usage:"log format: json or text (logfmt)"`
+	Level  string   `yaml:"level" usage:"minimum log level: debug, info, warn or error; adjustable at runtime via /loglevel"`
+	Redact []string `yaml:"redact" usage:"parts of attribute and metadata keys whose values are never logged; a key containing any of them is redacted"`
+	// LevelToken guards changes through /loglevel, which is served on the
+	// HTTP listener next to the public endpoints.
+	LevelToken string `yaml:"level_token" secret:"true" usage:"bearer token PUT and POST /loglevel must carry, empty to not allow changing the level over HTTP"`
+}
+
+// DefaultLogging is used by every server unless configured otherwise.
+var DefaultLogging = Logging{
+	Format: logging.FormatText,
+	Level:  "info",
+	Redact: []string{"authorization", "cookie", "password", "secret", "token", "api-key", "api_key"},
+}
+
+func (l Logging) Validate() error {
+	if l.Format != logging.FormatJSON && l.Format != logging.FormatText {
+		return fmt.Errorf("logging.format: must be json or text, got %q", l.Format)
+	}
+	switch strings.ToLower(l.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("logging.level: must be debug, info, warn or error, got %q", l.Level)
+	}
+	return nil
+}
+
+// Options returns the logging.Options implied by the configuration.
+func (l Logging) Options() logging.Options {
+	return logging.Options{Format: l.Format, Level: l.Level, Redact: l.Redact}
+}
+
+// RateLimit throttles clients per method. Clients are not keyed by the
+// blog author they write for: author ids are taken from the request and
+// nothing authenticates them, so a client could spread its calls over
+// any number of buckets.
+type RateLimit struct {
+	Enabled        bool     `yaml:"enabled" usage:"reject calls over the configured rates with ResourceExhausted"`
+	Key            string   `yaml:"key" usage:"what a client is: peer or api_key (x-api-key metadata); calls without a known API key are keyed by peer"`
+	APIKeys        []string `yaml:"api_keys" secret:"true" usage:"API keys clients may send in x-api-key metadata to get a bucket of their own"`
+	Methods        []string `yaml:"methods" usage:"token buckets as method=rate:burst, rate per second; method * sets the default"`
+	Streams        []string `yaml:"streams" usage:"concurrent streams one client may hold as method=n"`
+	TrustedProxies []string `yaml:"trusted_proxies" usage:"addresses or CIDR blocks of the proxies whose x-forwarded-for metadata names the client"`
+}
+
+func (r RateLimit) Validate() error {
+	if _, err := ratelimit.ParseProxies(r.TrustedProxies); err != nil {
+		return fmt.Errorf("rate_limit.trusted_proxies: %v", err)
+	}
+	if !r.Enabled {
+		return nil
+	}
+	switch r.Key {
+	case "peer":
+	case "api_key":
+		if len(r.APIKeys) == 0 {
+			return errors.New("rate_limit.api_keys: is required when rate_limit.key is api_key")
+		}
+	default:
+		return fmt.Errorf("rate_limit.key: must be peer or api_key, got %q", r.Key)
+	}
+	if _, err := ratelimit.ParseLimits(r.Methods); err != nil {
+		return fmt.Errorf("rate_limit.methods: %v", err)
+	}
+	if _, err := ratelimit.ParseStreamLimits(r.Streams); err != nil {
+		return fmt.Errorf("rate_limit.streams: %v", err)
+	}
+	return nil
+}
+
+// PeerKey returns the KeyFunc telling clients apart by address, trusting
+// x-forwarded-for metadata from the trusted proxies only. The configuration
+// must have been validated.
+func (r RateLimit) PeerKey() ratelimit.KeyFunc {
+	proxies, _ := ratelimit.ParseProxies(r.TrustedProxies)
+	return ratelimit.ByForwardedPeer(proxies)
+}
+
+// Options returns the ratelimit.Options implied by the configuration. The
+// configuration must have been validated.
+func (r RateLimit) Options() ratelimit.Options {
+	limits, _ := ratelimit.ParseLimits(r.Methods)
+	streams, _ := ratelimit.ParseStreamLimits(r.Streams)
+	key := r.PeerKey()
+	if r.Key == "api_key" {
+		key = ratelimit.ByAPIKey(r.APIKeys, key)
+	}
+	return ratelimit.Options{Key: key, Limits: limits, Streams: streams}
+}
@@ -0,0 +1,101 @@
+package grpcweb
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions controls which browser origins may call the server.
+type CORSOptions struct {
+	// AllowedOrigins lists origins such as "https://app.example.com". "*"
+	// allows any origin. Pages whose origin has the same host as the
+	// request are always allowed, so empty means only pages served by this
+	// server can call.
+	AllowedOrigins []string
+	// AllowedHeaders are request headers allowed in addition to the ones
+	// gRPC-Web clients always send.
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache a preflight result.
+	MaxAge time.Duration
+}
+
+var (
+	defaultAllowedHeaders = []string{
+		"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout", "Authorization",
+	}
+	exposedHeaders = []string{
+		"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin",
+	}
+)
+
+type cors struct {
+	any     bool
+	origins map[string]bool
+	headers string
+	maxAge  string
+}
+
+func newCORS(opts CORSOptions) *cors {
+	c := &cors{origins: make(map[string]bool)}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			c.any = true
+		}
+		c.origins[strings.TrimSuffix(o, "/")] = true
+	}
+	c.headers = strings.Join(append(append([]string(nil), defaultAllowedHeaders...), opts.AllowedHeaders...), ", ")
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+	return c
+}
+
+func (c *cors) isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *cors) originAllowed(r *http.Request, origin string) bool {
+	if c.any || c.origins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// allow adds the CORS response headers for a cross-origin call and reports
+// whether the call may proceed. Requests without an Origin header are not
+// cross-origin and always allowed.
+func (c *cors) allow(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !c.originAllowed(r, origin) {
+		return false
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+	h.Add("Vary", "Origin")
+	return true
+}
+
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.originAllowed(r, origin) || r.Header.Get("Access-Control-Request-Method") != http.MethodPost {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Methods", http.MethodPost)
+	h.Set("Access-Control-Allow-Headers", c.headers)
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	h.Add("Vary", "Origin")
+	w.WriteHeader(http.StatusNoContent)
+}
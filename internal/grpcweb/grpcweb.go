@@ -0,0 +1,204 @@
+// Package grpcweb serves a grpc.Server to browsers speaking the gRPC-Web
+// protocol over HTTP/1.1, in both the binary (application/grpc-web) and the
+// base64 text (application/grpc-web-text) encodings, with CORS handling.
+//
+// Requests are translated for grpc.Server.ServeHTTP, so the server's
+// interceptors apply exactly as they do for native gRPC clients. Unary and
+// server streaming calls are supported; client and bidirectional streaming
+// need HTTP/2 end to end and are not.
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	contentTypeBinary = "application/grpc-web"
+	contentTypeText   = "application/grpc-web-text"
+
+	// trailerFlag marks a frame holding trailers instead of a message.
+	trailerFlag = 0x80
+	// trailerPrefix is how grpc's handler transport marks trailers it could
+	// not declare up front (http2.TrailerPrefix).
+	trailerPrefix = "Trailer:"
+)
+
+type Handler struct {
+	server *grpc.Server
+	cors   *cors
+}
+
+// New returns a Handler serving s to gRPC-Web clients.
+func New(s *grpc.Server, opts CORSOptions) *Handler {
+	return &Handler{server: s, cors: newCORS(opts)}
+}
+
+// IsGRPCWebRequest reports whether r is a gRPC-Web call.
+func IsGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeBinary)
+}
+
+// Wrap returns a handler that serves gRPC-Web calls and their CORS preflight
+// requests itself and passes everything else to next.
+func (h *Handler) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cors.isPreflight(r) && h.isGRPCPath(r.URL.Path) {
+			h.cors.preflight(w, r)
+			return
+		}
+		if IsGRPCWebRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isGRPCPath reports whether path names a method registered on the server,
+// e.g. /blog.BlogService/ReadBlog.
+func (h *Handler) isGRPCPath(path string) bool {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 {
+		return false
+	}
+	_, ok := h.server.GetServiceInfo()[parts[0]]
+	return ok
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.cors.allow(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, contentTypeText)
+
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2"
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if text {
+		req.Body = ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+	}
+
+	resp := &response{w: w, header: make(http.Header), text: text, contentType: contentType}
+	h.server.ServeHTTP(resp, req)
+	resp.finish()
+}
+
+// response adapts what grpc's handler transport writes into a gRPC-Web
+// response: headers pass through, messages are copied as they are (or base64
+// encoded in text mode) and trailers are sent as a final length prefixed
+// frame in the body.
+type response struct {
+	w           http.ResponseWriter
+	header      http.Header
+	text        bool
+	contentType string
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	declared := r.declaredTrailers()
+	dst := r.w.Header()
+	for k, vv := range r.header {
+		if k == "Trailer" || declared[k] || strings.HasPrefix(k, trailerPrefix) {
+			continue
+		}
+		dst[k] = vv
+	}
+	dst.Set("Content-Type", r.contentType)
+	dst.Del("Content-Length")
+	r.w.WriteHeader(code)
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.text {
+		// Buffer so every flush emits complete, padded base64.
+		return r.buf.Write(b)
+	}
+	return r.w.Write(b)
+}
+
+func (r *response) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.flushText()
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *response) flushText() {
+	if !r.text || r.buf.Len() == 0 {
+		return
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, r.w)
+	enc.Write(r.buf.Bytes())
+	enc.Close()
+	r.buf.Reset()
+}
+
+// finish writes the trailer frame once the call has completed.
+func (r *response) finish() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	var trailers bytes.Buffer
+	declared := r.declaredTrailers()
+	for k, vv := range r.header {
+		name := k
+		if strings.HasPrefix(k, trailerPrefix) {
+			name = strings.TrimPrefix(k, trailerPrefix)
+		} else if !declared[k] {
+			continue
+		}
+		for _, v := range vv {
+			trailers.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+trailers.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailers.Len()))
+	frame = append(frame, trailers.Bytes()...)
+	if r.text {
+		r.buf.Write(frame)
+	} else {
+		r.w.Write(frame)
+	}
+	r.Flush()
+}
+
+func (r *response) declaredTrailers() map[string]bool {
+	declared := make(map[string]bool)
+	for _, v := range r.header["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	return declared
+}
+
+var _ io.Writer = (*response)(nil)
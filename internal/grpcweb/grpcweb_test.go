@@ -0,0 +1,365 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/blog/blogpb"
+	"github.com/akhil4chelsia/grpc-go-microservice/greet/greetpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type greetServer struct {
+	greetpb.UnimplementedGreetServiceServer
+}
+
+func (*greetServer) Greet(_ context.Context, req *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
+	if req.GetGreeting().GetFirstName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "first name is required")
+	}
+	return &greetpb.GreetResponse{Result: "Hello " + req.GetGreeting().GetFirstName()}, nil
+}
+
+func (*greetServer) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greetpb.GreetService_GreetManyTimesServer) error {
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&greetpb.GreetManyTimesResponse{Result: fmt.Sprintf("Hello %s number %d", req.GetFirstName(), i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type blogServer struct {
+	blogpb.UnimplementedBlogServiceServer
+	blogs []*blogpb.Blog
+}
+
+func (s *blogServer) ListBlog(_ *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
+	for _, b := range s.blogs {
+		if err := stream.Send(&blogpb.ListBlogResponse{Blog: b}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const testOrigin = "https://app.example.com"
+
+func newTestServer(t *testing.T, blogs ...*blogpb.Blog) *httptest.Server {
+	t.Helper()
+	s := grpc.NewServer()
+	greetpb.RegisterGreetServiceServer(s, &greetServer{})
+	blogpb.RegisterBlogServiceServer(s, &blogServer{blogs: blogs})
+	h := New(s, CORSOptions{AllowedOrigins: []string{testOrigin}, MaxAge: time.Hour})
+	ts := httptest.NewServer(h.Wrap(http.NotFoundHandler()))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// webResponse is a gRPC-Web response taken apart.
+type webResponse struct {
+	header   http.Header
+	messages [][]byte
+	trailers map[string]string
+}
+
+// code is the grpc-status of the call, sent in the trailer frame or, for
+// calls failing before any message, in the headers.
+func (r *webResponse) code(t *testing.T) codes.Code {
+	t.Helper()
+	v, ok := r.trailers["grpc-status"]
+	if !ok {
+		v = r.header.Get("Grpc-Status")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		t.Fatalf("bad grpc-status %q: %v", v, err)
+	}
+	return codes.Code(n)
+}
+
+// call makes a gRPC-Web call the way a browser client does.
+func call(t *testing.T, ts *httptest.Server, method string, text bool, req proto.Message) *webResponse {
+	t.Helper()
+	msg, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := frame(0, msg)
+	contentType := contentTypeBinary + "+proto"
+	if text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+		contentType = contentTypeText + "+proto"
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, ts.URL+method, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("X-Grpc-Web", "1")
+	httpReq.Header.Set("Origin", testOrigin)
+	resp, err := ts.Client().Do(httpReq)
+	if err != nil {
+		t.Fatalf("POST %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s = %d, want 200", method, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != contentType {
+		t.Errorf("Content-Type = %q, want %q", got, contentType)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != testOrigin {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, testOrigin)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text {
+		if b, err = decodeText(b); err != nil {
+			t.Fatalf("cannot decode the text response: %v", err)
+		}
+	}
+	out := &webResponse{header: resp.Header, trailers: make(map[string]string)}
+	for len(b) > 0 {
+		if len(b) < 5 {
+			t.Fatalf("truncated frame header %x", b)
+		}
+		flag, n := b[0], binary.BigEndian.Uint32(b[1:5])
+		if uint32(len(b)-5) < n {
+			t.Fatalf("frame of %d bytes holds %d", n, len(b)-5)
+		}
+		payload := b[5 : 5+n]
+		b = b[5+n:]
+		if flag&trailerFlag == 0 {
+			out.messages = append(out.messages, payload)
+			continue
+		}
+		if len(b) > 0 {
+			t.Fatalf("%d bytes after the trailer frame", len(b))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\r\n") {
+			if kv := strings.SplitN(line, ": ", 2); len(kv) == 2 {
+				out.trailers[kv[0]] = kv[1]
+			}
+		}
+	}
+	return out
+}
+
+func frame(flag byte, payload []byte) []byte {
+	f := make([]byte, 5, 5+len(payload))
+	f[0] = flag
+	binary.BigEndian.PutUint32(f[1:], uint32(len(payload)))
+	return append(f, payload...)
+}
+
+// decodeText decodes a text response, which is the concatenation of base64
+// chunks, each padded on its own.
+func decodeText(b []byte) ([]byte, error) {
+	var out []byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("%d trailing bytes", len(b))
+		}
+		q, err := base64.StdEncoding.DecodeString(string(b[:4]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q...)
+		b = b[4:]
+	}
+	return out, nil
+}
+
+var modes = []struct {
+	name string
+	text bool
+}{
+	{"binary", false},
+	{"text", true},
+}
+
+func TestUnary(t *testing.T) {
+	ts := newTestServer(t)
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			resp := call(t, ts, "/greet.GreetService/Greet", m.text, &greetpb.GreetRequest{
+				Greeting: &greetpb.Greeting{FirstName: "Ann"},
+			})
+			if c := resp.code(t); c != codes.OK {
+				t.Fatalf("grpc-status = %v, want OK", c)
+			}
+			if len(resp.messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(resp.messages))
+			}
+			got := &greetpb.GreetResponse{}
+			if err := proto.Unmarshal(resp.messages[0], got); err != nil {
+				t.Fatal(err)
+			}
+			if got.GetResult() != "Hello Ann" {
+				t.Errorf("result = %q, want %q", got.GetResult(), "Hello Ann")
+			}
+		})
+	}
+}
+
+func TestUnaryError(t *testing.T) {
+	ts := newTestServer(t)
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			resp := call(t, ts, "/greet.GreetService/Greet", m.text, &greetpb.GreetRequest{})
+			if c := resp.code(t); c != codes.InvalidArgument {
+				t.Fatalf("grpc-status = %v, want InvalidArgument", c)
+			}
+			if len(resp.messages) != 0 {
+				t.Errorf("got %d messages, want none", len(resp.messages))
+			}
+			msg, ok := resp.trailers["grpc-message"]
+			if !ok {
+				msg = resp.header.Get("Grpc-Message")
+			}
+			if !strings.Contains(msg, "first") {
+				t.Errorf("grpc-message = %q, want the error", msg)
+			}
+		})
+	}
+}
+
+func TestServerStreaming(t *testing.T) {
+	blogs := []*blogpb.Blog{
+		{Id: "1", AuthorId: "ann", Title: "First"},
+		{Id: "2", AuthorId: "bob", Title: "Second", Content: strings.Repeat("long content ", 1000)},
+		{Id: "3", AuthorId: "carol", Title: "Third"},
+	}
+	ts := newTestServer(t, blogs...)
+	for _, m := range modes {
+		t.Run(m.name+"/GreetManyTimes", func(t *testing.T) {
+			resp := call(t, ts, "/greet.GreetService/GreetManyTimes", m.text, &greetpb.GreetManyTimesRequest{FirstName: "Ann"})
+			if c := resp.code(t); c != codes.OK {
+				t.Fatalf("grpc-status = %v, want OK", c)
+			}
+			if len(resp.messages) != 3 {
+				t.Fatalf("got %d messages, want 3", len(resp.messages))
+			}
+			for i, b := range resp.messages {
+				got := &greetpb.GreetManyTimesResponse{}
+				if err := proto.Unmarshal(b, got); err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("Hello Ann number %d", i); got.GetResult() != want {
+					t.Errorf("message %d = %q, want %q", i, got.GetResult(), want)
+				}
+			}
+		})
+		t.Run(m.name+"/ListBlog", func(t *testing.T) {
+			resp := call(t, ts, "/blog.BlogService/ListBlog", m.text, &blogpb.ListBlogRequest{})
+			if c := resp.code(t); c != codes.OK {
+				t.Fatalf("grpc-status = %v, want OK", c)
+			}
+			if len(resp.messages) != len(blogs) {
+				t.Fatalf("got %d messages, want %d", len(resp.messages), len(blogs))
+			}
+			for i, b := range resp.messages {
+				got := &blogpb.ListBlogResponse{}
+				if err := proto.Unmarshal(b, got); err != nil {
+					t.Fatal(err)
+				}
+				if !proto.Equal(got.GetBlog(), blogs[i]) {
+					t.Errorf("blog %d = %v, want %v", i, got.GetBlog(), blogs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name   string
+		path   string
+		origin string
+		method string
+		want   int
+	}{
+		{"allowed", "/greet.GreetService/Greet", testOrigin, http.MethodPost, http.StatusNoContent},
+		{"same host", "/greet.GreetService/Greet", ts.URL, http.MethodPost, http.StatusNoContent},
+		{"other origin", "/greet.GreetService/Greet", "https://evil.example.com", http.MethodPost, http.StatusForbidden},
+		{"not POST", "/greet.GreetService/Greet", testOrigin, http.MethodGet, http.StatusForbidden},
+		// Not a gRPC method, so it is left to the wrapped handler.
+		{"other path", "/v1/blogs", testOrigin, http.MethodPost, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodOptions, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want != http.StatusNoContent {
+				if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+				}
+				return
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":  tt.origin,
+				"Access-Control-Allow-Methods": http.MethodPost,
+				"Access-Control-Max-Age":       "3600",
+			} {
+				if got := resp.Header.Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			allowed := strings.ToLower(resp.Header.Get("Access-Control-Allow-Headers"))
+			for _, h := range []string{"content-type", "x-grpc-web", "grpc-timeout"} {
+				if !strings.Contains(allowed, h) {
+					t.Errorf("Access-Control-Allow-Headers = %q, missing %s", allowed, h)
+				}
+			}
+		})
+	}
+}
+
+func TestDisallowedOrigin(t *testing.T) {
+	ts := newTestServer(t)
+	msg, _ := proto.Marshal(&greetpb.GreetRequest{Greeting: &greetpb.Greeting{FirstName: "Ann"}})
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/greet.GreetService/Greet", bytes.NewReader(frame(0, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentTypeBinary)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,176 @@
+// Package healthcheck registers the standard grpc.health.v1.Health service
+// and keeps its status in line with periodic dependency probes. The same
+// state is exposed over HTTP as /healthz and /readyz.
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Probe reports whether a dependency is usable.
+type Probe func(ctx context.Context) error
+
+type Checker struct {
+	health   *health.Server
+	services []string
+
+	mu       sync.Mutex
+	probes   map[string]Probe
+	failures map[string]string
+	stopping bool
+}
+
+// Register adds the health service to s and returns a Checker covering every
+// service already registered on s. Call it after registering the
+// application services. Until the first probe round completes every service
+// reports SERVING when there are no probes and NOT_SERVING otherwise.
+func Register(s *grpc.Server) *Checker {
+	c := &Checker{
+		health:   health.NewServer(),
+		probes:   make(map[string]Probe),
+		failures: make(map[string]string),
+	}
+	for name := range s.GetServiceInfo() {
+		c.services = append(c.services, name)
+	}
+	sort.Strings(c.services)
+	healthpb.RegisterHealthServer(s, c.health)
+	c.setStatus(healthpb.HealthCheckResponse_SERVING)
+	return c
+}
+
+// Health returns the underlying health server.
+func (c *Checker) Health() *health.Server {
+	return c.health
+}
+
+// Shutdown marks every service NOT_SERVING and makes /readyz report that the
+// server is shutting down. Later probe results no longer change the status.
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	c.stopping = true
+	c.mu.Unlock()
+	c.health.Shutdown()
+}
+
+// AddProbe registers a dependency probe. Any failing probe marks every
+// service NOT_SERVING.
+func (c *Checker) AddProbe(name string, p Probe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probes[name] = p
+	c.failures[name] = "not checked yet"
+	c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Run probes every interval until ctx is cancelled, giving each probe at
+// most timeout to answer.
+func (c *Checker) Run(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx, timeout)
+		select {
+		case <-ctx.Done():
+			c.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) check(ctx context.Context, timeout time.Duration) {
+	c.mu.Lock()
+	probes := make(map[string]Probe, len(c.probes))
+	for name, p := range c.probes {
+		probes[name] = p
+	}
+	c.mu.Unlock()
+
+	failures := make(map[string]string)
+	for name, p := range probes {
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		err := p(pctx)
+		cancel()
+		if err != nil {
+			failures[name] = err.Error()
+		}
+	}
+	if ctx.Err() != nil {
+		// Shutting down; probes failed because they were cancelled.
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range failures {
+		if _, failing := c.failures[name]; !failing {
+			slog.Warn("health probe failing", "probe", name, "err", failures[name])
+		}
+	}
+	for name := range c.failures {
+		if _, failing := failures[name]; !failing {
+			slog.Info("health probe recovered", "probe", name)
+		}
+	}
+	c.failures = failures
+	if len(failures) == 0 {
+		c.setStatus(healthpb.HealthCheckResponse_SERVING)
+	} else {
+		c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// setStatus applies st to the overall ("") status and every service. After
+// the health server has been shut down the calls are ignored.
+func (c *Checker) setStatus(st healthpb.HealthCheckResponse_ServingStatus) {
+	c.health.SetServingStatus("", st)
+	for _, svc := range c.services {
+		c.health.SetServingStatus(svc, st)
+	}
+}
+
+// RegisterHTTP adds /healthz and /readyz to mux. /healthz answers 200 while
+// the process is up; /readyz answers 200 only while every probe passes and
+// the server is not shutting down.
+func (c *Checker) RegisterHTTP(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, "ok", nil)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		stopping := c.stopping
+		failures := make(map[string]string, len(c.failures))
+		for name, msg := range c.failures {
+			failures[name] = msg
+		}
+		c.mu.Unlock()
+		switch {
+		case stopping:
+			writeStatus(w, http.StatusServiceUnavailable, "shutting down", nil)
+		case len(failures) > 0:
+			writeStatus(w, http.StatusServiceUnavailable, "not ready", failures)
+		default:
+			writeStatus(w, http.StatusOK, "ok", nil)
+		}
+	})
+}
+
+func writeStatus(w http.ResponseWriter, code int, status string, failures map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status   string            `json:"status"`
+		Failures map[string]string `json:"failures,omitempty"`
+	}{status, failures})
+}
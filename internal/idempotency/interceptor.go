@@ -0,0 +1,173 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// MetadataKey is the gRPC metadata header clients set to make a call
+// idempotent.
+const MetadataKey = "idempotency-key"
+
+// MaxKeyLength bounds the size of client supplied keys.
+const MaxKeyLength = 255
+
+// UnaryServerInterceptor replays stored responses for calls to the given
+// methods (full method names such as "/blog.BlogService/CreateBlog") that
+// carry an idempotency-key header. Calls without the header, and calls to
+// other methods, are passed straight through.
+//
+// Only successful responses are stored, and kept for ttl; a failed call
+// releases its key so the client can retry it. While a call is in progress
+// its key is held for lease only, so should the server crash before the
+// call ends, a retry takes the key over once lease has passed instead of
+// being refused until ttl does. lease should outlast the longest call.
+func UnaryServerInterceptor(store Store, ttl, lease time.Duration, methods ...string) grpc.UnaryServerInterceptor {
+	return ScopedUnaryServerInterceptor(store, ttl, lease, nil, methods...)
+}
+
+// ScopedUnaryServerInterceptor is UnaryServerInterceptor for servers whose
+// clients must not share keys, such as the tenants of a multi-tenant
+// server. Keys are stored under the scope returns for the call, so the
+// same key used in two scopes names two requests. scope may be nil.
+func ScopedUnaryServerInterceptor(store Store, ttl, lease time.Duration, scope func(ctx context.Context) string, methods ...string) grpc.UnaryServerInterceptor {
+	enabled := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		enabled[m] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !enabled[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		key, ok := keyFromContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if len(key) > MaxKeyLength {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be at most %d characters", MetadataKey, MaxKeyLength)
+		}
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if scope != nil {
+			if sc := scope(ctx); sc != "" {
+				key = sc + "/" + key
+			}
+		}
+		fingerprint, err := Fingerprint(info.FullMethod, msg)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Cannot fingerprint request %v", err)
+		}
+
+		token, err := newToken()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Cannot create idempotency token %v", err)
+		}
+		existing, err := store.Reserve(ctx, &Record{
+			Key:         key,
+			Method:      info.FullMethod,
+			Fingerprint: fingerprint,
+			Token:       token,
+			ExpiresAt:   time.Now().Add(lease),
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Cannot record idempotency key %v", err)
+		}
+		if existing != nil {
+			return replay(existing, info.FullMethod, fingerprint)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			if relErr := store.Release(context.Background(), key, token); relErr != nil {
+				logging.FromContext(ctx).Error("failed to release idempotency key", "idempotency_key", key, "err", relErr)
+			}
+			return nil, err
+		}
+		if out, ok := resp.(proto.Message); ok {
+			if err := complete(store, key, token, out, time.Now().Add(ttl)); err != nil {
+				logging.FromContext(ctx).Error("failed to store response for idempotency key", "idempotency_key", key, "err", err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// Fingerprint identifies a request by method and deterministic encoding, so
+// retries of the same call can be told apart from a reused key.
+func Fingerprint(method string, req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func keyFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(MetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return "", false
+	}
+	return values[0], true
+}
+
+func replay(rec *Record, method, fingerprint string) (interface{}, error) {
+	if rec.Method != method || rec.Fingerprint != fingerprint {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"%s %q was already used for a different request", MetadataKey, rec.Key)
+	}
+	if !rec.Done {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"A request with %s %q is still in progress", MetadataKey, rec.Key)
+	}
+	stored := &anypb.Any{}
+	if err := proto.Unmarshal(rec.Response, stored); err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot decode stored response %v", err)
+	}
+	resp, err := stored.UnmarshalNew()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot decode stored response %v", err)
+	}
+	return resp, nil
+}
+
+func complete(store Store, key, token string, resp proto.Message, expiresAt time.Time) error {
+	wrapped, err := anypb.New(resp)
+	if err != nil {
+		return err
+	}
+	b, err := proto.Marshal(wrapped)
+	if err != nil {
+		return err
+	}
+	return store.Complete(context.Background(), key, token, b, expiresAt)
+}
+
+// newToken returns a random token identifying one call's reservation.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,239 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testMethod = "/test.Service/Create"
+
+// handler is a unary handler answering with the request and the number of
+// calls it has seen, or failing while failures are left.
+type handler struct {
+	mu       sync.Mutex
+	calls    int
+	failures int
+	// block, when set, holds every call until it is closed.
+	block   chan struct{}
+	started chan struct{}
+}
+
+func (h *handler) handle(ctx context.Context, req interface{}) (interface{}, error) {
+	h.mu.Lock()
+	h.calls++
+	n := h.calls
+	fail := h.failures > 0
+	if fail {
+		h.failures--
+	}
+	h.mu.Unlock()
+	if h.block != nil {
+		h.started <- struct{}{}
+		<-h.block
+	}
+	if fail {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return wrapperspb.String(req.(*wrapperspb.StringValue).GetValue() + " #" + strconv.Itoa(n)), nil
+}
+
+func (h *handler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
+
+func withKey(key string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, key))
+}
+
+func call(icpt grpc.UnaryServerInterceptor, ctx context.Context, h *handler, req string) (string, error) {
+	resp, err := icpt(ctx, wrapperspb.String(req), &grpc.UnaryServerInfo{FullMethod: testMethod}, h.handle)
+	if err != nil {
+		return "", err
+	}
+	return resp.(*wrapperspb.StringValue).GetValue(), nil
+}
+
+func TestInterceptorReplaysCompletedCall(t *testing.T) {
+	icpt := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, testMethod)
+	h := &handler{}
+	first, err := call(icpt, withKey("k1"), h, "blog")
+	if err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+	second, err := call(icpt, withKey("k1"), h, "blog")
+	if err != nil {
+		t.Fatalf("retry = %v", err)
+	}
+	if first != second || h.count() != 1 {
+		t.Errorf("retry answered %q after %d calls, want %q after 1", second, h.count(), first)
+	}
+	// Another key is another request.
+	if _, err := call(icpt, withKey("k2"), h, "blog"); err != nil || h.count() != 2 {
+		t.Errorf("call with another key = %v after %d calls, want nil after 2", err, h.count())
+	}
+}
+
+func TestInterceptorPassesThroughCallsWithoutKey(t *testing.T) {
+	icpt := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, testMethod)
+	h := &handler{}
+	for i := 0; i < 2; i++ {
+		if _, err := call(icpt, context.Background(), h, "blog"); err != nil {
+			t.Fatalf("call = %v", err)
+		}
+	}
+	other := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, "/test.Service/Other")
+	if _, err := call(other, withKey("k1"), h, "blog"); err != nil {
+		t.Fatalf("call to a method without idempotency = %v", err)
+	}
+	if _, err := call(other, withKey("k1"), h, "blog"); err != nil {
+		t.Fatalf("call to a method without idempotency = %v", err)
+	}
+	if h.count() != 4 {
+		t.Errorf("handler called %d times, want 4", h.count())
+	}
+}
+
+func TestInterceptorRejectsKeyReusedForAnotherRequest(t *testing.T) {
+	icpt := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, testMethod)
+	h := &handler{}
+	if _, err := call(icpt, withKey("k1"), h, "blog"); err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+	_, err := call(icpt, withKey("k1"), h, "another blog")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("reused key = %v, want InvalidArgument", err)
+	}
+	if h.count() != 1 {
+		t.Errorf("handler called %d times, want 1", h.count())
+	}
+}
+
+func TestInterceptorRefusesRetryWhileInProgress(t *testing.T) {
+	icpt := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, testMethod)
+	h := &handler{block: make(chan struct{}), started: make(chan struct{}, 1)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := call(icpt, withKey("k1"), h, "blog")
+		done <- err
+	}()
+	<-h.started
+	_, err := call(icpt, withKey("k1"), h, "blog")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("retry while in progress = %v, want AlreadyExists", err)
+	}
+	close(h.block)
+	if err := <-done; err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+}
+
+func TestInterceptorReleasesKeyOfFailedCall(t *testing.T) {
+	icpt := UnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, testMethod)
+	h := &handler{failures: 1}
+	if _, err := call(icpt, withKey("k1"), h, "blog"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("first call = %v, want Unavailable", err)
+	}
+	got, err := call(icpt, withKey("k1"), h, "blog")
+	if err != nil || h.count() != 2 {
+		t.Fatalf("retry = %q, %v after %d calls, want success after 2", got, err, h.count())
+	}
+	replayed, err := call(icpt, withKey("k1"), h, "blog")
+	if err != nil || replayed != got || h.count() != 2 {
+		t.Errorf("second retry = %q, %v after %d calls, want %q replayed", replayed, err, h.count(), got)
+	}
+}
+
+func TestScopedInterceptorKeepsScopesApart(t *testing.T) {
+	scope := func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return md.Get("tenant")[0]
+	}
+	icpt := ScopedUnaryServerInterceptor(NewMemoryStore(), time.Hour, time.Minute, scope, testMethod)
+	h := &handler{}
+	for _, tenant := range []string{"a", "b", "a"} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "k1", "tenant", tenant))
+		if _, err := call(icpt, ctx, h, "blog"); err != nil {
+			t.Fatalf("call for tenant %s = %v", tenant, err)
+		}
+	}
+	if h.count() != 2 {
+		t.Errorf("handler called %d times, want once per tenant", h.count())
+	}
+}
+
+func TestMemoryStoreLeaseTakeover(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 7, 19, 10, 0, 0, 0, time.UTC)
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	first := &Record{Key: "k1", Method: testMethod, Fingerprint: "f", Token: "a", ExpiresAt: now.Add(time.Minute)}
+	if existing, err := m.Reserve(ctx, first); err != nil || existing != nil {
+		t.Fatalf("Reserve() = %v, %v, want nil, nil", existing, err)
+	}
+	second := *first
+	second.Token = "b"
+	if existing, err := m.Reserve(ctx, &second); err != nil || existing == nil || existing.Token != "a" {
+		t.Fatalf("Reserve() while leased = %+v, %v, want the record held with a", existing, err)
+	}
+
+	// The first call hangs past its lease and a retry takes the key over.
+	now = now.Add(2 * time.Minute)
+	second.ExpiresAt = now.Add(time.Minute)
+	if existing, err := m.Reserve(ctx, &second); err != nil || existing != nil {
+		t.Fatalf("Reserve() after the lease = %+v, %v, want nil, nil", existing, err)
+	}
+	if err := m.Complete(ctx, "k1", "a", []byte("late"), now.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Complete() with the lost token = %v, want ErrNotFound", err)
+	}
+	if err := m.Release(ctx, "k1", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Release() with the lost token = %v, want ErrNotFound", err)
+	}
+	if err := m.Complete(ctx, "k1", "b", []byte("response"), now.Add(time.Hour)); err != nil {
+		t.Fatalf("Complete() = %v", err)
+	}
+
+	// Done records outlive the lease until their own expiry.
+	now = now.Add(30 * time.Minute)
+	third := second
+	third.Token = "c"
+	existing, err := m.Reserve(ctx, &third)
+	if err != nil || existing == nil || !existing.Done || string(existing.Response) != "response" {
+		t.Fatalf("Reserve() of a done key = %+v, %v, want the stored response", existing, err)
+	}
+	now = now.Add(time.Hour)
+	if existing, err := m.Reserve(ctx, &third); err != nil || existing != nil {
+		t.Errorf("Reserve() after the record expired = %+v, %v, want nil, nil", existing, err)
+	}
+}
+
+func TestFingerprintTellsRequestsApart(t *testing.T) {
+	fp := func(method string, msg proto.Message) string {
+		t.Helper()
+		f, err := Fingerprint(method, msg)
+		if err != nil {
+			t.Fatalf("Fingerprint() = %v", err)
+		}
+		return f
+	}
+	a := fp(testMethod, wrapperspb.String("blog"))
+	if a != fp(testMethod, wrapperspb.String("blog")) {
+		t.Errorf("equal requests have different fingerprints")
+	}
+	if a == fp(testMethod, wrapperspb.String("other")) || a == fp("/test.Service/Update", wrapperspb.String("blog")) {
+		t.Errorf("different requests share a fingerprint")
+	}
+}
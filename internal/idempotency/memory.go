@@ -0,0 +1,79 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired records are dropped from a
+// MemoryStore.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps records in process memory. It is meant for a single
+// server instance; records are lost on restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*Record
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*Record),
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryStore) Reserve(ctx context.Context, rec *Record) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+	if existing, ok := m.records[rec.Key]; ok && existing.ExpiresAt.After(now) {
+		cp := *existing
+		return &cp, nil
+	}
+	cp := *rec
+	m.records[rec.Key] = &cp
+	return nil, nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, key, token string, response []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[key]
+	if !ok || rec.Done || rec.Token != token {
+		return ErrNotFound
+	}
+	rec.Done = true
+	rec.Response = response
+	rec.ExpiresAt = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[key]
+	if !ok || rec.Done || rec.Token != token {
+		return ErrNotFound
+	}
+	delete(m.records, key)
+	return nil
+}
+
+// sweep drops expired records, at most once every sweepInterval so that
+// reserving a key does not scan every record.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, rec := range m.records {
+		if !rec.ExpiresAt.After(now) {
+			delete(m.records, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps records in a Mongo collection so retries are recognised
+// by every server instance. Expired documents are removed by a TTL index.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+// EnsureIndexes creates the TTL index on expires_at.
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+	})
+	return err
+}
+
+func (m *MongoStore) Reserve(ctx context.Context, rec *Record) (*Record, error) {
+	// The TTL monitor only runs once a minute, so clear out an expired record
+	// for this key before trying to claim it. This is also how a pending
+	// record whose lease ran out, its call having crashed, is taken over.
+	if _, err := m.collection.DeleteOne(ctx, bson.M{
+		"_id":        rec.Key,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}); err != nil {
+		return nil, err
+	}
+	_, err := m.collection.InsertOne(ctx, rec)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+	existing := &Record{}
+	if err := m.collection.FindOne(ctx, bson.M{"_id": rec.Key}).Decode(existing); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (m *MongoStore) Complete(ctx context.Context, key, token string, response []byte, expiresAt time.Time) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": key, "token": token, "done": false},
+		bson.M{"$set": bson.M{"done": true, "response": response, "expires_at": expiresAt}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MongoStore) Release(ctx context.Context, key, token string) error {
+	res, err := m.collection.DeleteOne(ctx, bson.M{"_id": key, "token": token, "done": false})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
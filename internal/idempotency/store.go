@@ -0,0 +1,41 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Complete and Store.Release when the key has
+// no pending record held with the given token.
+var ErrNotFound = errors.New("idempotency: key not found")
+
+// Record is what the server remembers about a request made with an
+// idempotency key.
+type Record struct {
+	Key         string `bson:"_id"`
+	Method      string `bson:"method"`
+	Fingerprint string `bson:"fingerprint"`
+	// Token identifies the call holding a pending record, so a call whose
+	// lease was taken over cannot complete or release it.
+	Token    string `bson:"token"`
+	Done     bool   `bson:"done"`
+	Response []byte `bson:"response,omitempty"`
+	// ExpiresAt is when a pending record's lease runs out, after which
+	// another call may take the key over, or when a done record is dropped.
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Store persists idempotency records until they expire.
+type Store interface {
+	// Reserve stores rec as a pending record. When a live record already exists
+	// for rec.Key it is returned unchanged and nothing is written; a record
+	// past its ExpiresAt is replaced.
+	Reserve(ctx context.Context, rec *Record) (existing *Record, err error)
+	// Complete marks the pending record for key held with token as done
+	// with the given serialized response, keeping it until expiresAt.
+	Complete(ctx context.Context, key, token string, response []byte, expiresAt time.Time) error
+	// Release drops the pending record for key held with token so the
+	// request can be retried.
+	Release(ctx context.Context, key, token string) error
+}
@@ -0,0 +1,203 @@
+// Package lifecycle runs a gRPC server until SIGINT or SIGTERM and then shuts
+// it down in order: health goes NOT_SERVING, in-flight RPCs are drained,
+// background jobs are stopped and finally resources such as database
+// clients are closed.
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type httpServer struct {
+	server *http.Server
+	lis    net.Listener
+}
+
+type closer struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+type Runner struct {
+	server       *grpc.Server
+	lis          net.Listener
+	health       Health
+	httpServers  []httpServer
+	drainTimeout time.Duration
+	closeTimeout time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	jobs    sync.WaitGroup
+	closers []closer
+}
+
+// New returns a Runner for s serving on lis. s and lis may be nil for
+// processes that only serve HTTP. drainTimeout bounds how long
+// in-flight RPCs may run after a shutdown signal before they are cut off;
+// closeTimeout bounds waiting for background jobs and each close hook.
+func New(s *grpc.Server, lis net.Listener, drainTimeout, closeTimeout time.Duration) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		server:       s,
+		lis:          lis,
+		drainTimeout: drainTimeout,
+		closeTimeout: closeTimeout,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Health is told when shutdown starts. Both *health.Server and
+// *healthcheck.Checker implement it.
+type Health interface {
+	Shutdown()
+}
+
+// SetHealth makes the runner mark every service NOT_SERVING as soon as
+// shutdown starts, so load balancers stop sending new calls while in-flight
+// ones drain.
+func (r *Runner) SetHealth(h Health) {
+	r.health = h
+}
+
+// AddHTTPServer listens on address and serves h alongside the gRPC server.
+// HTTP servers are shut down after gRPC calls have drained so health
+// endpoints keep answering while the server stops.
+func (r *Runner) AddHTTPServer(address string, h http.Handler) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	r.httpServers = append(r.httpServers, httpServer{server: &http.Server{Handler: h}, lis: lis})
+	return nil
+}
+
+// Context is cancelled during shutdown, once in-flight gRPC calls and HTTP
+// requests have drained, so calls still running can rely on what the jobs
+// do.
+func (r *Runner) Context() context.Context {
+	return r.ctx
+}
+
+// Go starts a background job. Its context is cancelled once the servers have
+// drained and the runner waits for it to return before closing resources.
+func (r *Runner) Go(fn func(ctx context.Context)) {
+	r.jobs.Add(1)
+	go func() {
+		defer r.jobs.Done()
+		fn(r.ctx)
+	}()
+}
+
+// OnClose registers a hook run after the server has stopped. Hooks run in
+// reverse registration order, each with its own closeTimeout deadline.
+func (r *Runner) OnClose(name string, fn func(ctx context.Context) error) {
+	r.closers = append(r.closers, closer{name: name, fn: fn})
+}
+
+// Run serves until a shutdown signal is received or the server fails, then
+// shuts everything down. It returns the error from Serve, if any.
+func (r *Runner) Run() error {
+	serveErr := make(chan error, 1+len(r.httpServers))
+	if r.server != nil {
+		go func() {
+			serveErr <- r.server.Serve(r.lis)
+		}()
+	}
+	for _, h := range r.httpServers {
+		h := h
+		go func() {
+			if err := h.server.Serve(h.lis); err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var err error
+	select {
+	case s := <-sig:
+		slog.Info("stopping the server", "signal", s.String())
+	case err = <-serveErr:
+		slog.Error("server stopped unexpectedly", "err", err)
+	}
+	r.shutdown(sig)
+	return err
+}
+
+func (r *Runner) shutdown(sig <-chan os.Signal) {
+	if r.health != nil {
+		r.health.Shutdown()
+	}
+
+	if r.server != nil {
+		r.drain(sig)
+	}
+
+	for _, h := range r.httpServers {
+		ctx, cancel := context.WithTimeout(context.Background(), r.closeTimeout)
+		if err := h.server.Shutdown(ctx); err != nil {
+			slog.Error("failed to stop HTTP server", "address", h.lis.Addr().String(), "err", err)
+		}
+		cancel()
+	}
+
+	r.cancel()
+	jobsDone := make(chan struct{})
+	go func() {
+		r.jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-time.After(r.closeTimeout):
+		slog.Warn("background jobs did not finish in time", "timeout", r.closeTimeout)
+	}
+
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		c := r.closers[i]
+		slog.Info("closing", "resource", c.name)
+		ctx, cancel := context.WithTimeout(context.Background(), r.closeTimeout)
+		if err := c.fn(ctx); err != nil {
+			slog.Error("failed to close", "resource", c.name, "err", err)
+		}
+		cancel()
+	}
+	slog.Info("server stopped gracefully")
+}
+
+// drain lets in-flight gRPC calls finish, cutting them off after the drain
+// timeout or on a second signal.
+func (r *Runner) drain(sig <-chan os.Signal) {
+	stopped := make(chan struct{})
+	go func() {
+		r.server.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(r.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		slog.Info("all in-flight calls finished")
+	case <-timer.C:
+		slog.Warn("drain timeout exceeded, forcing stop", "timeout", r.drainTimeout)
+		r.server.Stop()
+	case s := <-sig:
+		slog.Warn("signal received again, forcing stop", "signal", s.String())
+		r.server.Stop()
+	}
+}
@@ -0,0 +1,200 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// RequestIDKey is the metadata key carrying the request id. Callers may set
+// it; otherwise the server assigns one. Either way it is echoed back in the
+// response headers.
+const RequestIDKey = "x-request-id"
+
+// maxRequestIDLength bounds caller supplied ids so they cannot bloat logs.
+const maxRequestIDLength = 128
+
+type loggerKey struct{}
+type requestIDKey struct{}
+
+// FromContext returns the request scoped logger stored by the interceptors,
+// or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// RequestIDFromContext returns the request id of the call handled in ctx.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// UnaryServerInterceptor assigns a request id, makes a logger carrying it
+// available through FromContext and logs each call when it completes.
+func UnaryServerInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, id, l := begin(ctx, logger, info.FullMethod)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+		resp, err := handler(ctx, req)
+		logCall(ctx, l, start, err,
+			slog.Int("request_bytes", size(req)),
+			slog.Int("response_bytes", size(resp)),
+		)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls,
+// logging message counts and total payload sizes in each direction.
+func StreamServerInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, id, l := begin(ss.Context(), logger, info.FullMethod)
+		ss.SetHeader(metadata.Pairs(RequestIDKey, id))
+		ls := &loggingStream{ServerStream: ss, ctx: ctx}
+		err := handler(srv, ls)
+		logCall(ctx, l, start, err,
+			slog.Int("msgs_received", ls.received),
+			slog.Int("msgs_sent", ls.sent),
+			slog.Int("request_bytes", ls.receivedBytes),
+			slog.Int("response_bytes", ls.sentBytes),
+		)
+		return err
+	}
+}
+
+func begin(ctx context.Context, logger *slog.Logger, method string) (context.Context, string, *slog.Logger) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if vals := md.Get(RequestIDKey); len(vals) > 0 && validRequestID(vals[0]) {
+		id = vals[0]
+	} else {
+		id = newRequestID()
+	}
+	attrs := []interface{}{slog.String("request_id", id), slog.String("grpc.method", method)}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	l := logger.With(attrs...)
+	if l.Enabled(ctx, slog.LevelDebug) {
+		l.DebugContext(ctx, "rpc started", slog.Any("metadata", metadataAttrs(md)))
+	}
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	ctx = context.WithValue(ctx, loggerKey{}, l)
+	return ctx, id, l
+}
+
+func logCall(ctx context.Context, l *slog.Logger, start time.Time, err error, attrs ...slog.Attr) {
+	st := status.Convert(err)
+	attrs = append(attrs,
+		slog.String("peer", peerAddress(ctx)),
+		slog.String("grpc.code", st.Code().String()),
+		slog.Duration("duration", time.Since(start)),
+	)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", st.Message()))
+	}
+	l.LogAttrs(ctx, levelFor(st.Code()), "rpc finished", attrs...)
+}
+
+// levelFor logs server faults as errors, caller mistakes as warnings and
+// successful calls as info.
+func levelFor(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.Unimplemented, codes.DeadlineExceeded:
+		return slog.LevelError
+	}
+	return slog.LevelWarn
+}
+
+// metadataAttrs turns incoming metadata into a group so redaction applies to
+// sensitive keys such as authorization.
+func metadataAttrs(md metadata.MD) slog.Value {
+	attrs := make([]slog.Attr, 0, len(md))
+	for k, vv := range md {
+		if len(vv) == 1 {
+			attrs = append(attrs, slog.String(k, vv[0]))
+		} else {
+			attrs = append(attrs, slog.Any(k, vv))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func size(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok && msg != nil {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type loggingStream struct {
+	grpc.ServerStream
+	ctx context.Context
+
+	received, sent           int
+	receivedBytes, sentBytes int
+}
+
+func (s *loggingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *loggingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+		s.sentBytes += size(m)
+	}
+	return err
+}
+
+func (s *loggingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+		s.receivedBytes += size(m)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// RegisterHTTP mounts /loglevel on mux. GET reports the current level; PUT
+// or POST changes it, taking the level from the level query parameter or
+// the request body, e.g.
+//
+//	curl -XPUT -H 'Authorization: Bearer <token>' localhost:8081/loglevel?level=debug
+//
+// Changes must carry token as a bearer token. With an empty token the level
+// cannot be changed over HTTP.
+func RegisterHTTP(mux *http.ServeMux, level *slog.LevelVar, token string) {
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if token == "" {
+				http.Error(w, "changing the log level is disabled", http.StatusForbidden)
+				return
+			}
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "invalid bearer token", http.StatusForbidden)
+				return
+			}
+			v := r.URL.Query().Get("level")
+			if v == "" {
+				b, _ := ioutil.ReadAll(io.LimitReader(r.Body, 64))
+				v = strings.TrimSpace(string(b))
+			}
+			old := level.Level()
+			if err := level.UnmarshalText([]byte(v)); err != nil {
+				http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+				return
+			}
+			slog.Info("log level changed", "from", old, "to", level.Level())
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": level.Level().String()})
+	})
+}
@@ -0,0 +1,87 @@
+// Package logging sets up the structured log/slog logger shared by the
+// servers: JSON or logfmt output, a level that can be changed at runtime and
+// redaction of sensitive attributes. It also provides the gRPC interceptors
+// that assign request ids and write one access log line per call.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Formats accepted in Options.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the value of every redacted attribute.
+const Redacted = "[REDACTED]"
+
+// Options configures New.
+type Options struct {
+	// Format is json or text (logfmt).
+	Format string
+	// Level is the initial minimum level: debug, info, warn or error.
+	Level string
+	// Redact lists parts of attribute keys whose values are never written.
+	// A key is redacted when it contains any of them, ignoring case, at any
+	// nesting depth, so "token" also covers x-admin-token.
+	Redact []string
+}
+
+// New returns a logger writing to w and the LevelVar controlling it.
+func New(w io.Writer, opts Options) (*slog.Logger, *slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, nil, fmt.Errorf("logging: invalid level %q", opts.Level)
+	}
+	redact := make([]string, 0, len(opts.Redact))
+	for _, k := range opts.Redact {
+		if k != "" {
+			redact = append(redact, strings.ToLower(k))
+		}
+	}
+	ho := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			key := strings.ToLower(a.Key)
+			for _, k := range redact {
+				if strings.Contains(key, k) {
+					return slog.String(a.Key, Redacted)
+				}
+			}
+			return a
+		},
+	}
+	var h slog.Handler
+	switch opts.Format {
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, ho)
+	case FormatText:
+		h = slog.NewTextHandler(w, ho)
+	default:
+		return nil, nil, fmt.Errorf("logging: unknown format %q", opts.Format)
+	}
+	return slog.New(h), level, nil
+}
+
+// Setup builds the logger for opts on stderr and makes it the default for
+// both log/slog and the standard log package.
+func Setup(opts Options) (*slog.Logger, *slog.LevelVar, error) {
+	logger, level, err := New(os.Stderr, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.SetDefault(logger)
+	return logger, level, nil
+}
+
+// Fatal logs msg with args at error level on the default logger and exits.
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"github.com/akhil4chelsia/grpc-go-microservice/internal/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheHitsDesc = prometheus.NewDesc("cache_hits_total",
+		"Lookups answered from the cache.", []string{"cache"}, nil)
+	cacheMissesDesc = prometheus.NewDesc("cache_misses_total",
+		"Lookups not found in the cache, including expired entries.", []string{"cache"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc("cache_evictions_total",
+		"Entries evicted to make room for new ones.", []string{"cache"}, nil)
+	cacheEntriesDesc = prometheus.NewDesc("cache_entries",
+		"Entries currently held by the cache.", []string{"cache"}, nil)
+)
+
+// cacheCollector reads the statistics a cache already keeps at scrape time
+// rather than counting every lookup twice.
+type cacheCollector struct {
+	name  string
+	stats func() cache.Stats
+}
+
+// RegisterCache exports the statistics returned by stats on reg, labelled
+// with the cache name.
+func RegisterCache(reg prometheus.Registerer, name string, stats func() cache.Stats) {
+	reg.MustRegister(&cacheCollector{name: name, stats: stats})
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheEntriesDesc
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(s.Hits), c.name)
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(s.Misses), c.name)
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(s.Evictions), c.name)
+	ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(s.Entries), c.name)
+}
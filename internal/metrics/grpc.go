@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServerMetrics holds the per-method RPC metrics of a gRPC server.
+type ServerMetrics struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	received *prometheus.CounterVec
+	sent     *prometheus.CounterVec
+}
+
+var rpcLabels = []string{"grpc_type", "grpc_service", "grpc_method"}
+
+// NewServerMetrics creates the RPC metrics and registers them on reg.
+func NewServerMetrics(reg prometheus.Registerer) *ServerMetrics {
+	m := &ServerMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "RPCs started on the server.",
+		}, rpcLabels),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by status code.",
+		}, append(rpcLabels, "grpc_code")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time from the start of an RPC until the handler returned.",
+			Buckets: prometheus.DefBuckets,
+		}, rpcLabels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_in_flight",
+			Help: "RPCs currently being handled.",
+		}, rpcLabels),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_received_total",
+			Help: "Stream messages received from clients.",
+		}, rpcLabels),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_sent_total",
+			Help: "Stream messages sent to clients.",
+		}, rpcLabels),
+	}
+	reg.MustRegister(m.started, m.handled, m.latency, m.inFlight, m.received, m.sent)
+	return m
+}
+
+// InitializeMetrics creates the series of every method registered on s so
+// they are exported with zero values before the first call.
+func (m *ServerMetrics) InitializeMetrics(s *grpc.Server) {
+	for service, info := range s.GetServiceInfo() {
+		for _, method := range info.Methods {
+			labels := []string{rpcType(method.IsClientStream, method.IsServerStream), service, method.Name}
+			m.started.WithLabelValues(labels...)
+			m.latency.WithLabelValues(labels...)
+			m.inFlight.WithLabelValues(labels...)
+			m.handled.WithLabelValues(append(labels, codes.OK.String())...)
+			if method.IsClientStream || method.IsServerStream {
+				m.received.WithLabelValues(labels...)
+				m.sent.WithLabelValues(labels...)
+			}
+		}
+	}
+}
+
+// UnaryServerInterceptor records metrics for unary RPCs.
+func (m *ServerMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		labels := methodLabels("unary", info.FullMethod)
+		done := m.start(labels)
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records metrics for streaming RPCs, including the
+// number of messages exchanged.
+func (m *ServerMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		labels := methodLabels(rpcType(info.IsClientStream, info.IsServerStream), info.FullMethod)
+		done := m.start(labels)
+		err := handler(srv, &countingStream{
+			ServerStream: ss,
+			received:     m.received.WithLabelValues(labels...),
+			sent:         m.sent.WithLabelValues(labels...),
+		})
+		done(err)
+		return err
+	}
+}
+
+func (m *ServerMetrics) start(labels []string) func(error) {
+	begin := time.Now()
+	m.started.WithLabelValues(labels...).Inc()
+	inFlight := m.inFlight.WithLabelValues(labels...)
+	inFlight.Inc()
+	return func(err error) {
+		inFlight.Dec()
+		m.latency.WithLabelValues(labels...).Observe(time.Since(begin).Seconds())
+		m.handled.WithLabelValues(append(labels, status.Code(err).String())...).Inc()
+	}
+}
+
+type countingStream struct {
+	grpc.ServerStream
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func (s *countingStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.sent.Inc()
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		s.received.Inc()
+	}
+	return err
+}
+
+func rpcType(clientStream, serverStream bool) string {
+	switch {
+	case clientStream && serverStream:
+		return "bidi_stream"
+	case clientStream:
+		return "client_stream"
+	case serverStream:
+		return "server_stream"
+	}
+	return "unary"
+}
+
+// methodLabels splits a full method such as /blog.BlogService/ReadBlog into
+// the type, service and method labels.
+func methodLabels(typ, fullMethod string) []string {
+	service, method := "unknown", "unknown"
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		service, method = strings.TrimPrefix(fullMethod[:i], "/"), fullMethod[i+1:]
+	}
+	return []string{typ, service, method}
+}
@@ -0,0 +1,27 @@
+// Package metrics records Prometheus metrics for gRPC servers, the Mongo
+// operations and caches behind them, and serves them in the Prometheus text
+// format.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewRegistry returns a registry holding the standard Go runtime and process
+// collectors. Each server registers its own metrics on it.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// RegisterHTTP mounts /metrics on mux, serving everything gathered by g.
+func RegisterHTTP(mux *http.ServeMux, g prometheus.Gatherer) {
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoMetrics records the latency and failures of Mongo operations.
+type MongoMetrics struct {
+	latency *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+}
+
+// NewMongoMetrics creates the Mongo metrics and registers them on reg.
+func NewMongoMetrics(reg prometheus.Registerer) *MongoMetrics {
+	m := &MongoMetrics{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mongo_operation_duration_seconds",
+			Help:    "Time taken by Mongo operations.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"collection", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mongo_operation_errors_total",
+			Help: "Mongo operations that failed, by kind of failure.",
+		}, []string{"collection", "operation", "error"}),
+	}
+	reg.MustRegister(m.latency, m.errors)
+	return m
+}
+
+// Start begins timing operation op on collection. Call the returned function
+// with the operation's error once it has finished. A missing document is a
+// result, not a failure. Start on a nil *MongoMetrics records nothing.
+func (m *MongoMetrics) Start(collection, op string) func(error) {
+	if m == nil {
+		return func(error) {}
+	}
+	begin := time.Now()
+	return func(err error) {
+		m.latency.WithLabelValues(collection, op).Observe(time.Since(begin).Seconds())
+		if err != nil && err != mongo.ErrNoDocuments {
+			m.errors.WithLabelValues(collection, op, errorKind(err)).Inc()
+		}
+	}
+}
+
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return "timeout"
+	case mongo.IsDuplicateKeyError(err):
+		return "duplicate_key"
+	case mongo.IsNetworkError(err):
+		return "network"
+	}
+	return "other"
+}
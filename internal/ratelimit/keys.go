@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// KeyFunc names the client a call is counted against. req is nil for
+// streaming calls.
+type KeyFunc func(ctx context.Context, fullMethod string, req interface{}) string
+
+// ForwardedForKey is set by trusted proxies such as the blog HTTP gateway to
+// the address of the client they are calling on behalf of.
+const ForwardedForKey = "x-forwarded-for"
+
+// APIKeyMetadata is the metadata key clients send their API key in.
+const APIKeyMetadata = "x-api-key"
+
+// ParseProxies parses the addresses of trusted proxies, each an IP address
+// such as 127.0.0.1 or a CIDR block such as 10.0.0.0/8.
+func ParseProxies(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("%q: must be an IP address or a CIDR block", e)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("%q: must be an IP address or a CIDR block", e)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ByPeer keys calls by the client's IP address.
+func ByPeer(ctx context.Context, _ string, _ interface{}) string {
+	return "peer:" + peerHost(ctx)
+}
+
+// ByForwardedPeer returns a KeyFunc keying calls by the client's IP address.
+// Calls arriving from one of the trusted proxies with x-forwarded-for
+// metadata are keyed by the address they were forwarded for instead, so
+// every gateway user does not share a single bucket. That is the last
+// address of the list not itself a trusted proxy: clients can put anything
+// in front of it. Without trusted proxies it is ByPeer.
+func ByForwardedPeer(trusted []*net.IPNet) KeyFunc {
+	if len(trusted) == 0 {
+		return ByPeer
+	}
+	isTrusted := func(host string) bool {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, n := range trusted {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(ctx context.Context, _ string, _ interface{}) string {
+		host := peerHost(ctx)
+		if !isTrusted(host) {
+			return "peer:" + host
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		var hops []string
+		for _, v := range md.Get(ForwardedForKey) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			host = hop
+			if !isTrusted(hop) {
+				break
+			}
+		}
+		return "peer:" + host
+	}
+}
+
+// ByAPIKey returns a KeyFunc keying calls by the API key in their metadata
+// when it is one of keys, falling back to peer for calls without a known
+// key. Unknown keys are not trusted: a client could otherwise get a fresh
+// bucket for every call by making up a new key.
+func ByAPIKey(keys []string, peer KeyFunc) KeyFunc {
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k] = true
+	}
+	return func(ctx context.Context, method string, req interface{}) string {
+		if k := firstMetadata(ctx, APIKeyMetadata); k != "" && known[k] {
+			return "api_key:" + k
+		}
+		return peer(ctx, method, req)
+	}
+}
+
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host := p.Addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// fromPeer is the context of a call from ip carrying the metadata kv.
+func fromPeer(ip string, kv ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000},
+	})
+	if len(kv) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+	}
+	return ctx
+}
+
+func TestParseProxies(t *testing.T) {
+	nets, err := ParseProxies([]string{"127.0.0.1", "::1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseProxies() = %v", err)
+	}
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"192.168.0.1", false},
+	} {
+		got := false
+		for _, n := range nets {
+			got = got || n.Contains(net.ParseIP(tt.ip))
+		}
+		if got != tt.want {
+			t.Errorf("%s trusted = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"localhost", "10.0.0.0/33"} {
+		if _, err := ParseProxies([]string{bad}); err == nil {
+			t.Errorf("ParseProxies(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestByForwardedPeer(t *testing.T) {
+	trusted, err := ParseProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ByForwardedPeer(trusted)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"direct", fromPeer("192.0.2.1"), "peer:192.0.2.1"},
+		{"untrusted peer forwarding", fromPeer("192.0.2.1", ForwardedForKey, "198.51.100.7"), "peer:192.0.2.1"},
+		{"trusted proxy", fromPeer("127.0.0.1", ForwardedForKey, "198.51.100.7"), "peer:198.51.100.7"},
+		{"trusted proxy without metadata", fromPeer("127.0.0.1"), "peer:127.0.0.1"},
+		// The client made up the first hop; the last untrusted one is
+		// where the trusted chain starts.
+		{"spoofed hop", fromPeer("127.0.0.1", ForwardedForKey, "203.0.113.9, 198.51.100.7"), "peer:198.51.100.7"},
+		{"chain of proxies", fromPeer("127.0.0.1", ForwardedForKey, "198.51.100.7, 10.0.0.5"), "peer:198.51.100.7"},
+		{"several values", fromPeer("127.0.0.1", ForwardedForKey, "203.0.113.9", ForwardedForKey, "198.51.100.7,"), "peer:198.51.100.7"},
+		{"only proxies", fromPeer("127.0.0.1", ForwardedForKey, "10.0.0.5"), "peer:10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(tt.ctx, testMethod, nil); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// Without trusted proxies x-forwarded-for is ignored.
+	ctx := fromPeer("127.0.0.1", ForwardedForKey, "198.51.100.7")
+	if got := ByForwardedPeer(nil)(ctx, testMethod, nil); got != "peer:127.0.0.1" {
+		t.Errorf("key without trusted proxies = %q, want peer:127.0.0.1", got)
+	}
+}
+
+func TestByAPIKey(t *testing.T) {
+	key := ByAPIKey([]string{"known"}, ByPeer)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"known key", fromPeer("192.0.2.1", APIKeyMetadata, "known"), "api_key:known"},
+		{"unknown key", fromPeer("192.0.2.1", APIKeyMetadata, "made-up"), "peer:192.0.2.1"},
+		{"no key", fromPeer("192.0.2.1"), "peer:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(tt.ctx, testMethod, nil); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
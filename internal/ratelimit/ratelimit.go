@@ -0,0 +1,250 @@
+// Package ratelimit protects gRPC servers from clients that call too often
+// or hold too many streams open. Calls are limited per client with a token
+// bucket per method, and long-running streams with a per-client cap on
+// concurrent streams. Rejected calls fail with ResourceExhausted carrying a
+// google.rpc.RetryInfo detail that tells the client when to try again.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// AnyMethod configures the limit applied to methods without their own.
+const AnyMethod = "*"
+
+// Limit is a token bucket refilled at Rate tokens per second holding at
+// most Burst tokens. Each call takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimits parses entries of the form method=rate:burst, for example
+// /blog.BlogService/CreateBlog=0.5:5. The method * sets the default.
+func ParseLimits(entries []string) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(entries))
+	for _, e := range entries {
+		method, v, err := splitEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q: limit must be rate:burst", e)
+		}
+		r, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("%q: rate must be a positive number", e)
+		}
+		burst, err := strconv.Atoi(parts[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("%q: burst must be a positive integer", e)
+		}
+		limits[method] = Limit{Rate: r, Burst: burst}
+	}
+	return limits, nil
+}
+
+// ParseStreamLimits parses entries of the form method=n capping how many
+// streams of method one client may have open at once.
+func ParseStreamLimits(entries []string) (map[string]int, error) {
+	limits := make(map[string]int, len(entries))
+	for _, e := range entries {
+		method, v, err := splitEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q: stream limit must be a positive integer", e)
+		}
+		limits[method] = n
+	}
+	return limits, nil
+}
+
+func splitEntry(e string) (string, string, error) {
+	i := strings.LastIndex(e, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%q: expected method=limit", e)
+	}
+	method := strings.TrimSpace(e[:i])
+	if method != AnyMethod && !strings.HasPrefix(method, "/") {
+		return "", "", fmt.Errorf("%q: method must be a full method name such as /pkg.Service/Method or *", e)
+	}
+	return method, strings.TrimSpace(e[i+1:]), nil
+}
+
+// Options configures a Limiter.
+type Options struct {
+	// Key identifies the client a call is counted against.
+	Key KeyFunc
+	// Limits maps full method names, or AnyMethod, to call rates.
+	Limits map[string]Limit
+	// Streams maps full method names to the number of concurrent streams a
+	// client may hold.
+	Streams map[string]int
+	// StreamRetryAfter is the delay suggested to clients that hit a stream
+	// limit. Defaults to one second.
+	StreamRetryAfter time.Duration
+}
+
+// idleTTL is how long an unused bucket is kept. A full bucket carries no
+// state, so dropping it after the client goes quiet loses nothing.
+const idleTTL = 10 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// Limiter holds the per client buckets and stream counts.
+type Limiter struct {
+	opts Options
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+func New(opts Options) *Limiter {
+	if opts.Key == nil {
+		opts.Key = ByPeer
+	}
+	if opts.StreamRetryAfter <= 0 {
+		opts.StreamRetryAfter = time.Second
+	}
+	return &Limiter{
+		opts:    opts,
+		buckets: make(map[string]*bucket),
+		streams: make(map[string]int),
+	}
+}
+
+// allow takes a token for method from the bucket of key, returning how long
+// to wait when there is none.
+func (l *Limiter) allow(method, key string) (bool, time.Duration) {
+	limit, ok := l.opts.Limits[method]
+	if !ok {
+		if limit, ok = l.opts.Limits[AnyMethod]; !ok {
+			return true, 0
+		}
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	id := method + "\x00" + key
+	b := l.buckets[id]
+	if b == nil {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[id] = b
+	}
+	b.lastUsed = now
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.buckets {
+		if now.Sub(b.lastUsed) > idleTTL {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// acquireStream reserves a stream slot, returning a release function, or
+// false when key already holds the maximum.
+func (l *Limiter) acquireStream(method, key string) (func(), bool) {
+	max, ok := l.opts.Streams[method]
+	if !ok {
+		return func() {}, true
+	}
+	id := method + "\x00" + key
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[id] >= max {
+		return nil, false
+	}
+	l.streams[id]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.streams[id]--; l.streams[id] <= 0 {
+			delete(l.streams, id)
+		}
+	}, true
+}
+
+// UnaryServerInterceptor rejects calls over their method's rate.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := l.opts.Key(ctx, info.FullMethod, req)
+		if ok, wait := l.allow(info.FullMethod, key); !ok {
+			return nil, exhausted(wait, "Rate limit exceeded for %s, retry in %v", info.FullMethod, roundUp(wait))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams over their method's rate or
+// concurrency limit. The request message of a stream is not available
+// here, so the key function receives a nil request.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		key := l.opts.Key(ss.Context(), info.FullMethod, nil)
+		if ok, wait := l.allow(info.FullMethod, key); !ok {
+			return exhausted(wait, "Rate limit exceeded for %s, retry in %v", info.FullMethod, roundUp(wait))
+		}
+		release, ok := l.acquireStream(info.FullMethod, key)
+		if !ok {
+			return exhausted(l.opts.StreamRetryAfter, "Too many concurrent %s streams", info.FullMethod)
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func exhausted(wait time.Duration, format string, args ...interface{}) error {
+	st := status.Newf(codes.ResourceExhausted, format, args...)
+	if d, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(roundUp(wait))}); err == nil {
+		st = d
+	}
+	return st.Err()
+}
+
+// roundUp rounds wait up to whole milliseconds so clients never retry early.
+func roundUp(wait time.Duration) time.Duration {
+	return ((wait + time.Millisecond - 1) / time.Millisecond) * time.Millisecond
+}
+
+// RetryDelay returns the delay suggested by a RetryInfo detail of err.
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
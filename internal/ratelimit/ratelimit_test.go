@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testMethod = "/test.Service/Create"
+	testStream = "/test.Service/List"
+)
+
+// fixedKey counts every call against the same client.
+func fixedKey(context.Context, string, interface{}) string { return "client" }
+
+func unary(icpt grpc.UnaryServerInterceptor, method string) error {
+	_, err := icpt(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil })
+	return err
+}
+
+// serverStream is a grpc.ServerStream carrying only a context.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits([]string{"*=50:100", "/blog.BlogService/CreateBlog = 0.5:5"})
+	if err != nil {
+		t.Fatalf("ParseLimits() = %v", err)
+	}
+	if got := limits[AnyMethod]; got != (Limit{Rate: 50, Burst: 100}) {
+		t.Errorf("limit of * = %+v, want 50:100", got)
+	}
+	if got := limits["/blog.BlogService/CreateBlog"]; got != (Limit{Rate: 0.5, Burst: 5}) {
+		t.Errorf("limit of CreateBlog = %+v, want 0.5:5", got)
+	}
+	for _, bad := range []string{"CreateBlog=1:1", "*=1", "*=0:1", "*=1:0", "=1:1"} {
+		if _, err := ParseLimits([]string{bad}); err == nil {
+			t.Errorf("ParseLimits(%q) succeeded, want an error", bad)
+		}
+	}
+	if _, err := ParseStreamLimits([]string{"/blog.BlogService/ListBlog=0"}); err == nil {
+		t.Errorf("ParseStreamLimits() of a zero limit succeeded, want an error")
+	}
+}
+
+func TestUnaryTokenBucket(t *testing.T) {
+	l := New(Options{
+		Key: fixedKey,
+		Limits: map[string]Limit{
+			AnyMethod:  {Rate: 1000, Burst: 1000},
+			testMethod: {Rate: 1, Burst: 2},
+		},
+	})
+	icpt := l.UnaryServerInterceptor()
+	for i := 0; i < 2; i++ {
+		if err := unary(icpt, testMethod); err != nil {
+			t.Fatalf("call %d within the burst = %v", i+1, err)
+		}
+	}
+	err := unary(icpt, testMethod)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call over the burst = %v, want ResourceExhausted", err)
+	}
+	wait, ok := RetryDelay(err)
+	if !ok || wait <= 0 || wait > time.Second {
+		t.Errorf("RetryDelay() = %v, %v, want at most the 1s refill", wait, ok)
+	}
+	if wait%time.Millisecond != 0 {
+		t.Errorf("RetryDelay() = %v, want whole milliseconds", wait)
+	}
+	// Other methods have buckets of their own.
+	if err := unary(icpt, "/test.Service/Other"); err != nil {
+		t.Errorf("call to another method = %v", err)
+	}
+}
+
+func TestUnaryKeepsClientsApart(t *testing.T) {
+	l := New(Options{
+		Key: func(ctx context.Context, _ string, req interface{}) string {
+			return req.(string)
+		},
+		Limits: map[string]Limit{testMethod: {Rate: 1, Burst: 1}},
+	})
+	icpt := l.UnaryServerInterceptor()
+	call := func(client string) error {
+		_, err := icpt(context.Background(), client, &grpc.UnaryServerInfo{FullMethod: testMethod},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil })
+		return err
+	}
+	if err := call("a"); err != nil {
+		t.Fatalf("first call of a = %v", err)
+	}
+	if err := call("b"); err != nil {
+		t.Errorf("first call of b = %v, want its own bucket", err)
+	}
+	if err := call("a"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call of a = %v, want ResourceExhausted", err)
+	}
+}
+
+func TestUnlimitedMethod(t *testing.T) {
+	icpt := New(Options{Key: fixedKey, Limits: map[string]Limit{testMethod: {Rate: 1, Burst: 1}}}).UnaryServerInterceptor()
+	for i := 0; i < 10; i++ {
+		if err := unary(icpt, "/test.Service/Other"); err != nil {
+			t.Fatalf("call %d to a method without a limit = %v", i+1, err)
+		}
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	l := New(Options{
+		Key:              fixedKey,
+		Streams:          map[string]int{testStream: 1},
+		StreamRetryAfter: 3 * time.Second,
+	})
+	icpt := l.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: testStream, IsServerStream: true}
+	ss := &serverStream{ctx: context.Background()}
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- icpt(nil, ss, info, func(interface{}, grpc.ServerStream) error {
+			close(started)
+			<-finish
+			return nil
+		})
+	}()
+	<-started
+
+	noop := func(interface{}, grpc.ServerStream) error { return nil }
+	err := icpt(nil, ss, info, noop)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream = %v, want ResourceExhausted", err)
+	}
+	if wait, ok := RetryDelay(err); !ok || wait != 3*time.Second {
+		t.Errorf("RetryDelay() = %v, %v, want 3s", wait, ok)
+	}
+
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatalf("first stream = %v", err)
+	}
+	if err := icpt(nil, ss, info, noop); err != nil {
+		t.Errorf("stream after the first ended = %v", err)
+	}
+}
+
+func TestRetryDelayWithoutDetail(t *testing.T) {
+	if _, ok := RetryDelay(status.Error(codes.Unavailable, "down")); ok {
+		t.Errorf("RetryDelay() of an error without RetryInfo reported a delay")
+	}
+}
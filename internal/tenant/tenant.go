@@ -0,0 +1,130 @@
+// Package tenant works out which tenant a gRPC call is made for and carries
+// it in the call's context. Tenants are named either by the x-tenant-id
+// metadata, for servers reached only through trusted proxies, or by the
+// common name of the client's verified TLS certificate.
+package tenant
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the metadata key clients name their tenant in.
+const MetadataKey = "x-tenant-id"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant the call in ctx is made for.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Resolver names the tenant a call is made for, or returns "" when the
+// call names none.
+type Resolver func(ctx context.Context) string
+
+// FromMetadata resolves the tenant from the x-tenant-id metadata. Clients
+// can claim any tenant this way, so it is only safe behind a proxy that
+// sets the metadata itself.
+func FromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get(MetadataKey); len(vals) > 0 {
+		return strings.TrimSpace(vals[0])
+	}
+	return ""
+}
+
+// FromCertificate resolves the tenant from the common name of the client's
+// TLS certificate. Only certificates verified against the server's client
+// CAs count.
+func FromCertificate(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+// Authorizer checks that calls may be made for tenant id, returning a
+// status error when they may not.
+type Authorizer func(ctx context.Context, id string) error
+
+// UnaryServerInterceptor resolves the tenant of calls to methods starting
+// with one of prefixes, such as "/blog.BlogService/", and has authorize
+// check it before the call proceeds with the tenant in its context. Calls
+// naming no tenant fail with Unauthenticated. Calls to other methods are
+// passed straight through.
+func UnaryServerInterceptor(resolve Resolver, authorize Authorizer, prefixes ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !matches(info.FullMethod, prefixes) {
+			return handler(ctx, req)
+		}
+		ctx, err := check(ctx, resolve, authorize)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(resolve Resolver, authorize Authorizer, prefixes ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !matches(info.FullMethod, prefixes) {
+			return handler(srv, ss)
+		}
+		ctx, err := check(ss.Context(), resolve, authorize)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &tenantStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func check(ctx context.Context, resolve Resolver, authorize Authorizer) (context.Context, error) {
+	id := resolve(ctx)
+	if id == "" {
+		return nil, status.Error(codes.Unauthenticated, "Call names no tenant")
+	}
+	if err := authorize(ctx, id); err != nil {
+		return nil, err
+	}
+	return NewContext(ctx, id), nil
+}
+
+func matches(method string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(method, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantStream is a grpc.ServerStream whose context carries the tenant.
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporters selectable in Options.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Options configures New.
+type Options struct {
+	// ServiceName becomes the service.name resource attribute.
+	ServiceName string
+	// Exporter is one of none, stdout, file or otlp.
+	Exporter string
+	// Endpoint is the OTLP/HTTP traces URL used by the otlp exporter.
+	Endpoint string
+	// File is the path the file exporter appends to.
+	File string
+	// SampleRatio is the fraction of new traces recorded. Spans continuing
+	// a remote trace follow the caller's decision.
+	SampleRatio float64
+}
+
+// DefaultEndpoint is the standard local OTLP/HTTP traces endpoint.
+const DefaultEndpoint = "http://localhost:4318/v1/traces"
+
+// OptionsFromEnv reads the options of service from the standard
+// OpenTelemetry environment variables OTEL_TRACES_EXPORTER,
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and OTEL_TRACES_SAMPLER_ARG, plus
+// OTEL_TRACES_FILE for the file exporter. Tracing is off unless
+// OTEL_TRACES_EXPORTER is set.
+func OptionsFromEnv(service string) Options {
+	opts := Options{
+		ServiceName: service,
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		File:        os.Getenv("OTEL_TRACES_FILE"),
+		SampleRatio: 1,
+	}
+	if opts.Exporter == "console" {
+		opts.Exporter = ExporterStdout
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultEndpoint
+	}
+	if opts.File == "" {
+		opts.File = service + "-traces.jsonl"
+	}
+	if r, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil {
+		opts.SampleRatio = r
+	}
+	return opts
+}
+
+// New returns a Tracer exporting through the configured exporter. It
+// returns a nil *Tracer, which traces nothing, when the exporter is none
+// or empty.
+func New(opts Options) (*Tracer, error) {
+	t := &Tracer{
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	var exp sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		f, openErr := os.OpenFile(opts.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if openErr != nil {
+			return nil, openErr
+		}
+		t.file = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(opts.Endpoint))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		closeFile(t.file)
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.ServiceName),
+	))
+	if err != nil {
+		closeFile(t.file)
+		return nil, err
+	}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	t.tracer = t.provider.Tracer(scopeName)
+	// Export failures are reported here rather than failing the calls
+	// being traced.
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("tracing failed", "err", err)
+	}))
+	return t, nil
+}
+
+func closeFile(c io.Closer) {
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,91 @@
+// Package tracing records distributed traces across the example clients,
+// the servers and MongoDB with the OpenTelemetry SDK.
+//
+// Trace context and baggage travel in the W3C traceparent, tracestate and
+// baggage gRPC metadata entries, gRPC calls are traced by the otelgrpc
+// stats handlers, and spans are exported over OTLP/HTTP, to an
+// OpenTelemetry Collector or Jaeger for instance, or written as JSON to
+// stdout or a file.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+)
+
+const scopeName = "github.com/akhil4chelsia/grpc-go-microservice/internal/tracing"
+
+// Tracer starts spans and exports the sampled ones. A nil *Tracer is valid
+// and traces nothing.
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	// file is the file the file exporter writes to, closed by Shutdown.
+	file io.Closer
+}
+
+// Start begins a span named name as a child of the span in ctx, or as the
+// root of a new trace, and returns a context carrying it.
+func (t *Tracer) Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// ServerOption traces the calls to a grpc.Server, continuing the caller's
+// trace or starting one, with a server span around each call.
+func (t *Tracer) ServerOption() grpc.ServerOption {
+	if t == nil {
+		return grpc.EmptyServerOption{}
+	}
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(t.provider),
+		otelgrpc.WithPropagators(t.propagator),
+	))
+}
+
+// DialOption wraps each call of a client connection in a client span and
+// sends its trace context and baggage to the server. Stream spans end when
+// the stream does, however the caller stops using it.
+func (t *Tracer) DialOption() grpc.DialOption {
+	if t == nil {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+		otelgrpc.WithTracerProvider(t.provider),
+		otelgrpc.WithPropagators(t.propagator),
+	))
+}
+
+// Shutdown exports the spans still queued and releases the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	err := t.provider.Shutdown(ctx)
+	if t.file != nil {
+		err = errors.Join(err, t.file.Close())
+	}
+	return err
+}
+
+// RecordError marks span failed with err. A nil err is ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,90 @@
+// Package workerpool runs background jobs on a fixed number of goroutines
+// fed from a bounded queue, so bursts of work neither block the callers
+// queuing it nor start unbounded goroutines.
+package workerpool
+
+import (
+	"context"
+	"sync"
+)
+
+// Job is a unit of background work. ctx is cancelled when the pool is
+// closed without time to finish.
+type Job func(ctx context.Context)
+
+// Pool runs queued jobs. Submit is safe for concurrent use.
+type Pool struct {
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan Job
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New starts a Pool of workers goroutines with room for queue waiting jobs.
+// Call Close to finish the queued jobs and stop the workers.
+func New(workers, queue int) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		jobs:   make(chan Job, queue),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		if p.ctx.Err() != nil {
+			continue
+		}
+		job(p.ctx)
+	}
+}
+
+// Submit queues job and reports whether it did; jobs are refused while the
+// queue is full and once the pool is closed. It never blocks.
+func (p *Pool) Submit(job Job) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish. When
+// ctx is done first, running jobs are cancelled and the rest of the queue is
+// skipped.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}